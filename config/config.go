@@ -0,0 +1,521 @@
+// Package config loads the settings DataBot needs at startup.
+//
+// Settings start out as the development defaults, are then
+// overridden by an optional JSON or TOML file and finally by
+// DATABOT_* environment variables, so a deployment can keep secrets
+// out of the file entirely.
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+)
+
+// The secrets used when nothing else is configured. They are
+// checked into the repo, so Validate refuses to run with them in
+// production.
+const (
+	DevPepper  = "peter-picked-a-peck-of-pickled-peppers"
+	DevHMACKey = "secret-hmac-key"
+	DevCSRFKey = "dev-csrf-key-must-be-32-bytes-!!"
+
+	DevEncryptionKey = "dev-encryption-key"
+)
+
+// CSRFKeyBytes is the length gorilla/csrf requires for its auth key
+const CSRFKeyBytes = 32
+
+// The environments DataBot can run in
+const (
+	EnvDevelopment = "development"
+	EnvProduction  = "production"
+)
+
+var (
+	// ErrEnvInvalid is returned when Env is not one of the known environments
+	ErrEnvInvalid = errors.New("config: env must be development or production")
+
+	// ErrPortInvalid is returned when a port is outside of 1-65535
+	ErrPortInvalid = errors.New("config: port must be between 1 and 65535")
+
+	// ErrDialectInvalid is returned when the database dialect is unknown
+	ErrDialectInvalid = errors.New("config: database dialect must be postgres, mysql or sqlite3")
+
+	// ErrPepperRequired is returned when the password pepper is empty
+	ErrPepperRequired = errors.New("config: pepper is required")
+
+	// ErrHMACKeyRequired is returned when the HMAC key is empty
+	ErrHMACKeyRequired = errors.New("config: hmac_key is required")
+
+	// ErrKeyInvalid is returned when a pepper or HMAC key ID is
+	// reused or contains a colon, or an old key has no secret
+	ErrKeyInvalid = errors.New("config: key ids must be unique and can't contain \":\", and old keys need a secret")
+
+	// ErrCSRFKeyInvalid is returned when the CSRF key is not CSRFKeyBytes long
+	ErrCSRFKeyInvalid = errors.New("config: csrf_key must be 32 bytes long")
+
+	// ErrEncryptionKeyRequired is returned when the encryption key is empty
+	ErrEncryptionKeyRequired = errors.New("config: encryption_key is required")
+
+	// ErrDevSecret is returned in production mode when the pepper,
+	// HMAC key, CSRF key or encryption key is still the development default
+	ErrDevSecret = errors.New("config: refusing to run in production with the development pepper, hmac_key, csrf_key or encryption_key")
+
+	// ErrMailerInvalid is returned when the email backend is unknown
+	// or is missing the settings it needs
+	ErrMailerInvalid = errors.New("config: email.backend must be smtp (with smtp_host) or file (with dir)")
+
+	// ErrHasherInvalid is returned when the password hashing
+	// algorithm is unknown or its parameters are out of range
+	ErrHasherInvalid = errors.New("config: password.algorithm must be bcrypt (cost 4-31) or argon2id (time, memory and threads of at least 1)")
+
+	// ErrOIDCInvalid is returned when an OpenID Connect provider is
+	// configured without a client ID or its issuer isn't a URL
+	ErrOIDCInvalid = errors.New("config: oidc.issuer must be an https URL (http on localhost) and needs a client_id")
+
+	// ErrDeletionGraceInvalid is returned when the grace period
+	// before deleted accounts are purged is negative
+	ErrDeletionGraceInvalid = errors.New("config: deletion_grace_days can't be negative")
+
+	// ErrFormatUnknown is returned when the config file is not .json or .toml
+	ErrFormatUnknown = errors.New("config: config file must end in .json or .toml")
+)
+
+// Config holds every setting DataBot reads at startup. BaseURL is
+// the public address of the site, used to build links in emails.
+// RequireVerifiedEmail stops users from logging in until they
+// click the link in their verification email. EncryptionKey
+// encrypts secrets stored in the database, like TOTP secrets, and
+// can't be changed without losing them. Deleted accounts are kept
+// for DeletionGraceDays before they are purged.
+//
+// Pepper and HMACKey can be rotated: give the new one an ID and move
+// the old one, with its ID if it had one, to OldPeppers or
+// OldHMACKeys. Password hashes, sessions and API keys made with an
+// old key are redone with the current one when they are next used,
+// other tokens keep working as long as the old key is listed.
+type Config struct {
+	Env                  string         `json:"env" toml:"env"`
+	Port                 int            `json:"port" toml:"port"`
+	Pepper               string         `json:"pepper" toml:"pepper"`
+	PepperID             string         `json:"pepper_id" toml:"pepper_id"`
+	OldPeppers           []Key          `json:"old_peppers" toml:"old_peppers"`
+	HMACKey              string         `json:"hmac_key" toml:"hmac_key"`
+	HMACKeyID            string         `json:"hmac_key_id" toml:"hmac_key_id"`
+	OldHMACKeys          []Key          `json:"old_hmac_keys" toml:"old_hmac_keys"`
+	CSRFKey              string         `json:"csrf_key" toml:"csrf_key"`
+	EncryptionKey        string         `json:"encryption_key" toml:"encryption_key"`
+	BaseURL              string         `json:"base_url" toml:"base_url"`
+	RequireVerifiedEmail bool           `json:"require_verified_email" toml:"require_verified_email"`
+	DeletionGraceDays    int            `json:"deletion_grace_days" toml:"deletion_grace_days"`
+	Database             DatabaseConfig `json:"database" toml:"database"`
+	Email                EmailConfig    `json:"email" toml:"email"`
+	Password             PasswordConfig `json:"password" toml:"password"`
+	OIDC                 OIDCConfig     `json:"oidc" toml:"oidc"`
+}
+
+// Key is a pepper or HMAC key that is no longer current
+type Key struct {
+	ID     string `json:"id" toml:"id"`
+	Secret string `json:"secret" toml:"secret"`
+}
+
+// DatabaseConfig holds what is needed to open the database.
+// Host, Port, User, Password and SSLMode are ignored for SQLite,
+// where Name is the path of the database file.
+type DatabaseConfig struct {
+	Dialect  string `json:"dialect" toml:"dialect"`
+	Host     string `json:"host" toml:"host"`
+	Port     int    `json:"port" toml:"port"`
+	User     string `json:"user" toml:"user"`
+	Password string `json:"password" toml:"password"`
+	Name     string `json:"name" toml:"name"`
+	SSLMode  string `json:"sslmode" toml:"sslmode"`
+}
+
+// The email backends DataBot can send mail with
+const (
+	MailerSMTP = "smtp"
+	MailerFile = "file"
+)
+
+// EmailConfig picks how email is sent. The file backend writes .eml
+// files to Dir instead of sending anything.
+type EmailConfig struct {
+	Backend      string `json:"backend" toml:"backend"`
+	From         string `json:"from" toml:"from"`
+	Dir          string `json:"dir" toml:"dir"`
+	SMTPHost     string `json:"smtp_host" toml:"smtp_host"`
+	SMTPPort     int    `json:"smtp_port" toml:"smtp_port"`
+	SMTPUser     string `json:"smtp_user" toml:"smtp_user"`
+	SMTPPassword string `json:"smtp_password" toml:"smtp_password"`
+}
+
+// The algorithms passwords can be hashed with
+const (
+	HasherBcrypt   = "bcrypt"
+	HasherArgon2id = "argon2id"
+)
+
+// PasswordConfig picks how new passwords are hashed. It can be
+// changed at any time, existing hashes are upgraded when each user
+// next logs in. Argon2Memory is in KiB.
+type PasswordConfig struct {
+	Algorithm     string `json:"algorithm" toml:"algorithm"`
+	BcryptCost    int    `json:"bcrypt_cost" toml:"bcrypt_cost"`
+	Argon2Time    int    `json:"argon2_time" toml:"argon2_time"`
+	Argon2Memory  int    `json:"argon2_memory" toml:"argon2_memory"`
+	Argon2Threads int    `json:"argon2_threads" toml:"argon2_threads"`
+}
+
+// OIDCConfig is an OpenID Connect provider users can log in with.
+// It is turned off while Issuer is empty. Name is shown on the log
+// in button. The provider has to allow BaseURL followed by
+// /login/oidc/callback as a redirect URL.
+type OIDCConfig struct {
+	Name         string `json:"name" toml:"name"`
+	Issuer       string `json:"issuer" toml:"issuer"`
+	ClientID     string `json:"client_id" toml:"client_id"`
+	ClientSecret string `json:"client_secret" toml:"client_secret"`
+}
+
+// Enabled reports whether a provider is configured
+func (c OIDCConfig) Enabled() bool {
+	return c.Issuer != ""
+}
+
+// Default returns the development configuration
+func Default() Config {
+	return Config{
+		Env:           EnvDevelopment,
+		Port:          3000,
+		Pepper:        DevPepper,
+		HMACKey:       DevHMACKey,
+		CSRFKey:       DevCSRFKey,
+		EncryptionKey: DevEncryptionKey,
+		BaseURL:       "http://localhost:3000",
+
+		DeletionGraceDays: 30,
+		Database: DatabaseConfig{
+			Dialect: "postgres",
+			Host:    "localhost",
+			Port:    5432,
+			User:    "postgres",
+			Name:    "databot_dev",
+			SSLMode: "disable",
+		},
+		Email: EmailConfig{
+			Backend:  MailerFile,
+			From:     "DataBot <support@databot.local>",
+			Dir:      "tmp/email",
+			SMTPPort: 587,
+		},
+		Password: PasswordConfig{
+			Algorithm:     HasherBcrypt,
+			BcryptCost:    10,
+			Argon2Time:    3,
+			Argon2Memory:  64 * 1024,
+			Argon2Threads: 2,
+		},
+	}
+}
+
+// Load builds a Config from the defaults, the file at path (skipped
+// if path is empty) and the environment, in that order, and
+// validates the result.
+func Load(path string) (Config, error) {
+	c := Default()
+	if path != "" {
+		if err := c.loadFile(path); err != nil {
+			return c, err
+		}
+	}
+	if err := c.loadEnv(os.Getenv); err != nil {
+		return c, err
+	}
+	return c, c.Validate()
+}
+
+// loadFile decodes the config file over c. Unknown keys are an
+// error so a typo doesn't silently fall back to a default.
+func (c *Config) loadFile(path string) error {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+		dec := json.NewDecoder(bytes.NewReader(b))
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(c); err != nil {
+			return fmt.Errorf("config: %s: %v", path, err)
+		}
+	case ".toml":
+		md, err := toml.Decode(string(b), c)
+		if err != nil {
+			return fmt.Errorf("config: %s: %v", path, err)
+		}
+		if undecoded := md.Undecoded(); len(undecoded) > 0 {
+			return fmt.Errorf("config: %s: unknown key %q", path, undecoded[0].String())
+		}
+	default:
+		return ErrFormatUnknown
+	}
+	return nil
+}
+
+// loadEnv overrides c with any DATABOT_* variables that are set.
+// getenv is os.Getenv outside of tests.
+func (c *Config) loadEnv(getenv func(string) string) error {
+	strs := map[string]*string{
+		"DATABOT_ENV":            &c.Env,
+		"DATABOT_PEPPER":         &c.Pepper,
+		"DATABOT_PEPPER_ID":      &c.PepperID,
+		"DATABOT_HMAC_KEY":       &c.HMACKey,
+		"DATABOT_HMAC_KEY_ID":    &c.HMACKeyID,
+		"DATABOT_CSRF_KEY":       &c.CSRFKey,
+		"DATABOT_ENCRYPTION_KEY": &c.EncryptionKey,
+		"DATABOT_BASE_URL":       &c.BaseURL,
+		"DATABOT_DB_DIALECT":     &c.Database.Dialect,
+		"DATABOT_DB_HOST":        &c.Database.Host,
+		"DATABOT_DB_USER":        &c.Database.User,
+		"DATABOT_DB_PASSWORD":    &c.Database.Password,
+		"DATABOT_DB_NAME":        &c.Database.Name,
+		"DATABOT_DB_SSLMODE":     &c.Database.SSLMode,
+
+		"DATABOT_EMAIL_BACKEND":       &c.Email.Backend,
+		"DATABOT_EMAIL_FROM":          &c.Email.From,
+		"DATABOT_EMAIL_DIR":           &c.Email.Dir,
+		"DATABOT_EMAIL_SMTP_HOST":     &c.Email.SMTPHost,
+		"DATABOT_EMAIL_SMTP_USER":     &c.Email.SMTPUser,
+		"DATABOT_EMAIL_SMTP_PASSWORD": &c.Email.SMTPPassword,
+
+		"DATABOT_PASSWORD_ALGORITHM": &c.Password.Algorithm,
+
+		"DATABOT_OIDC_NAME":          &c.OIDC.Name,
+		"DATABOT_OIDC_ISSUER":        &c.OIDC.Issuer,
+		"DATABOT_OIDC_CLIENT_ID":     &c.OIDC.ClientID,
+		"DATABOT_OIDC_CLIENT_SECRET": &c.OIDC.ClientSecret,
+	}
+	for key, dst := range strs {
+		if v := getenv(key); v != "" {
+			*dst = v
+		}
+	}
+
+	ints := map[string]*int{
+		"DATABOT_PORT":            &c.Port,
+		"DATABOT_DB_PORT":         &c.Database.Port,
+		"DATABOT_EMAIL_SMTP_PORT": &c.Email.SMTPPort,
+
+		"DATABOT_DELETION_GRACE_DAYS": &c.DeletionGraceDays,
+
+		"DATABOT_PASSWORD_BCRYPT_COST":    &c.Password.BcryptCost,
+		"DATABOT_PASSWORD_ARGON2_TIME":    &c.Password.Argon2Time,
+		"DATABOT_PASSWORD_ARGON2_MEMORY":  &c.Password.Argon2Memory,
+		"DATABOT_PASSWORD_ARGON2_THREADS": &c.Password.Argon2Threads,
+	}
+	for key, dst := range ints {
+		v := getenv(key)
+		if v == "" {
+			continue
+		}
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return fmt.Errorf("config: %s must be a number, got %q", key, v)
+		}
+		*dst = n
+	}
+
+	bools := map[string]*bool{
+		"DATABOT_REQUIRE_VERIFIED_EMAIL": &c.RequireVerifiedEmail,
+	}
+	for key, dst := range bools {
+		v := getenv(key)
+		if v == "" {
+			continue
+		}
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return fmt.Errorf("config: %s must be true or false, got %q", key, v)
+		}
+		*dst = b
+	}
+	return nil
+}
+
+// Validate checks that the config can be used to start the server.
+// In production the development secrets are rejected.
+func (c Config) Validate() error {
+	switch c.Env {
+	case EnvDevelopment, EnvProduction:
+	default:
+		return ErrEnvInvalid
+	}
+	if c.Port < 1 || c.Port > 65535 {
+		return ErrPortInvalid
+	}
+	if c.Pepper == "" {
+		return ErrPepperRequired
+	}
+	if c.HMACKey == "" {
+		return ErrHMACKeyRequired
+	}
+	if !validKeys(c.PepperID, c.OldPeppers) || !validKeys(c.HMACKeyID, c.OldHMACKeys) {
+		return ErrKeyInvalid
+	}
+	if len(c.CSRFKey) != CSRFKeyBytes {
+		return ErrCSRFKeyInvalid
+	}
+	if c.EncryptionKey == "" {
+		return ErrEncryptionKeyRequired
+	}
+	if c.DeletionGraceDays < 0 {
+		return ErrDeletionGraceInvalid
+	}
+	if c.IsProd() && (c.Pepper == DevPepper || c.HMACKey == DevHMACKey ||
+		c.CSRFKey == DevCSRFKey || c.EncryptionKey == DevEncryptionKey) {
+		return ErrDevSecret
+	}
+	if err := c.Database.validate(); err != nil {
+		return err
+	}
+	if err := c.Password.validate(); err != nil {
+		return err
+	}
+	if err := c.OIDC.validate(); err != nil {
+		return err
+	}
+	return c.Email.validate()
+}
+
+// validKeys reports whether the current key ID and the old keys can
+// go in one keyring
+func validKeys(currentID string, old []Key) bool {
+	seen := map[string]bool{currentID: true}
+	if strings.Contains(currentID, ":") {
+		return false
+	}
+	for _, key := range old {
+		if seen[key.ID] || strings.Contains(key.ID, ":") || key.Secret == "" {
+			return false
+		}
+		seen[key.ID] = true
+	}
+	return true
+}
+
+func (c PasswordConfig) validate() error {
+	switch c.Algorithm {
+	case HasherBcrypt:
+		if c.BcryptCost < 4 || c.BcryptCost > 31 {
+			return ErrHasherInvalid
+		}
+	case HasherArgon2id:
+		if c.Argon2Time < 1 || c.Argon2Memory < 1 || c.Argon2Threads < 1 || c.Argon2Threads > 255 {
+			return ErrHasherInvalid
+		}
+	default:
+		return ErrHasherInvalid
+	}
+	return nil
+}
+
+// validate only allows plain http for a provider running locally,
+// the ID token and client secret travel over this connection
+func (c OIDCConfig) validate() error {
+	if !c.Enabled() {
+		return nil
+	}
+	u, err := url.Parse(c.Issuer)
+	if err != nil || u.Host == "" || c.ClientID == "" {
+		return ErrOIDCInvalid
+	}
+	switch u.Scheme {
+	case "https":
+	case "http":
+		if host := u.Hostname(); host != "localhost" && host != "127.0.0.1" {
+			return ErrOIDCInvalid
+		}
+	default:
+		return ErrOIDCInvalid
+	}
+	return nil
+}
+
+func (c EmailConfig) validate() error {
+	switch c.Backend {
+	case MailerSMTP:
+		if c.SMTPHost == "" || c.SMTPPort < 1 || c.SMTPPort > 65535 {
+			return ErrMailerInvalid
+		}
+	case MailerFile:
+		if c.Dir == "" {
+			return ErrMailerInvalid
+		}
+	default:
+		return ErrMailerInvalid
+	}
+	return nil
+}
+
+func (c DatabaseConfig) validate() error {
+	switch c.Dialect {
+	case "postgres", "mysql":
+		if c.Port < 1 || c.Port > 65535 {
+			return ErrPortInvalid
+		}
+	case "sqlite3":
+	default:
+		return ErrDialectInvalid
+	}
+	return nil
+}
+
+// IsProd reports whether the config is for production
+func (c Config) IsProd() bool {
+	return c.Env == EnvProduction
+}
+
+// Addr returns the address the web server should listen on
+func (c Config) Addr() string {
+	return fmt.Sprintf(":%d", c.Port)
+}
+
+// ConnectionInfo returns the connection string gorm expects for
+// the configured dialect
+func (c DatabaseConfig) ConnectionInfo() string {
+	switch c.Dialect {
+	case "mysql":
+		return fmt.Sprintf("%s:%s@tcp(%s:%d)/%s?charset=utf8mb4&parseTime=True",
+			c.User, c.Password, c.Host, c.Port, c.Name)
+	case "sqlite3":
+		return c.Name
+	default:
+		info := fmt.Sprintf("host=%s port=%d user=%s dbname=%s sslmode=%s",
+			pqQuote(c.Host), c.Port, pqQuote(c.User), pqQuote(c.Name), pqQuote(c.SSLMode))
+		if c.Password != "" {
+			info += " password=" + pqQuote(c.Password)
+		}
+		return info
+	}
+}
+
+// pqQuote quotes a value for a libpq key=value connection string,
+// so spaces, quotes and backslashes can't end it early or add
+// parameters
+func pqQuote(s string) string {
+	s = strings.Replace(s, `\`, `\\`, -1)
+	s = strings.Replace(s, `'`, `\'`, -1)
+	return "'" + s + "'"
+}
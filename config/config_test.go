@@ -0,0 +1,208 @@
+package config
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func writeConfig(t *testing.T, name, contents string) string {
+	dir, err := ioutil.TempDir("", "databot-config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	path := filepath.Join(dir, name)
+	if err := ioutil.WriteFile(path, []byte(contents), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestDefaultIsValid(t *testing.T) {
+	if err := Default().Validate(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLoadFiles(t *testing.T) {
+	files := map[string]string{
+		"config.json": `{"port": 8080, "database": {"dialect": "sqlite3", "name": "databot.db"}}`,
+		"config.toml": "port = 8080\n[database]\ndialect = \"sqlite3\"\nname = \"databot.db\"\n",
+	}
+	for name, contents := range files {
+		c, err := Load(writeConfig(t, name, contents))
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if c.Addr() != ":8080" {
+			t.Errorf("%s: Expected :8080. Received %s", name, c.Addr())
+		}
+		if c.Database.ConnectionInfo() != "databot.db" {
+			t.Errorf("%s: Expected databot.db. Received %s", name, c.Database.ConnectionInfo())
+		}
+		// Values missing from the file keep their defaults
+		if c.Pepper != DevPepper {
+			t.Errorf("%s: Expected the default pepper. Received %s", name, c.Pepper)
+		}
+	}
+}
+
+func TestConnectionInfoPostgres(t *testing.T) {
+	c := DatabaseConfig{
+		Dialect:  "postgres",
+		Host:     "localhost",
+		Port:     5432,
+		User:     "databot",
+		Name:     "databot_dev",
+		SSLMode:  "disable",
+		Password: `it's a\pass sslmode=disable`,
+	}
+	want := `host='localhost' port=5432 user='databot' dbname='databot_dev' sslmode='disable' password='it\'s a\\pass sslmode=disable'`
+	if info := c.ConnectionInfo(); info != want {
+		t.Errorf("Expected %s. Received %s", want, info)
+	}
+}
+
+func TestLoadUnknownKey(t *testing.T) {
+	for _, name := range []string{"config.json", "config.toml"} {
+		contents := `{"prot": 8080}`
+		if name == "config.toml" {
+			contents = "prot = 8080\n"
+		}
+		if _, err := Load(writeConfig(t, name, contents)); err == nil {
+			t.Errorf("%s: Expected an error for an unknown key", name)
+		}
+	}
+	if _, err := Load(writeConfig(t, "config.yaml", "")); err != ErrFormatUnknown {
+		t.Errorf("Expected ErrFormatUnknown. Received %v", err)
+	}
+}
+
+func TestLoadEnv(t *testing.T) {
+	env := map[string]string{
+		"DATABOT_PORT":                   "4000",
+		"DATABOT_DB_PASSWORD":            "hunter2",
+		"DATABOT_REQUIRE_VERIFIED_EMAIL": "true",
+	}
+	c := Default()
+	if err := c.loadEnv(func(key string) string { return env[key] }); err != nil {
+		t.Fatal(err)
+	}
+	if c.Port != 4000 || c.Database.Password != "hunter2" || !c.RequireVerifiedEmail {
+		t.Errorf("Expected env to override defaults. Received %+v", c)
+	}
+
+	env["DATABOT_DB_PORT"] = "five"
+	if err := c.loadEnv(func(key string) string { return env[key] }); err == nil {
+		t.Errorf("Expected an error for a non-numeric port")
+	}
+}
+
+func TestValidateDeletionGrace(t *testing.T) {
+	c := Default()
+	c.DeletionGraceDays = -1
+	if err := c.Validate(); err != ErrDeletionGraceInvalid {
+		t.Errorf("Expected ErrDeletionGraceInvalid. Received %v", err)
+	}
+	// Purging right away is allowed
+	c.DeletionGraceDays = 0
+	if err := c.Validate(); err != nil {
+		t.Errorf("Expected no error. Received %v", err)
+	}
+}
+
+func TestValidatePassword(t *testing.T) {
+	c := Default()
+	c.Password.Algorithm = "md5"
+	if err := c.Validate(); err != ErrHasherInvalid {
+		t.Errorf("Expected ErrHasherInvalid. Received %v", err)
+	}
+	c.Password.Algorithm = HasherArgon2id
+	if err := c.Validate(); err != nil {
+		t.Errorf("Expected no error. Received %v", err)
+	}
+	c.Password.Argon2Threads = 0
+	if err := c.Validate(); err != ErrHasherInvalid {
+		t.Errorf("Expected ErrHasherInvalid for 0 threads. Received %v", err)
+	}
+}
+
+func TestValidateProduction(t *testing.T) {
+	c := Default()
+	c.Env = EnvProduction
+	if err := c.Validate(); err != ErrDevSecret {
+		t.Errorf("Expected ErrDevSecret. Received %v", err)
+	}
+	c.Pepper = "a-real-pepper"
+	if err := c.Validate(); err != ErrDevSecret {
+		t.Errorf("Expected ErrDevSecret for the dev hmac key. Received %v", err)
+	}
+	c.HMACKey = "a-real-hmac-key"
+	if err := c.Validate(); err != ErrDevSecret {
+		t.Errorf("Expected ErrDevSecret for the dev csrf key. Received %v", err)
+	}
+	c.CSRFKey = "too-short"
+	if err := c.Validate(); err != ErrCSRFKeyInvalid {
+		t.Errorf("Expected ErrCSRFKeyInvalid. Received %v", err)
+	}
+	c.CSRFKey = "0123456789abcdef0123456789abcdef"
+	if err := c.Validate(); err != ErrDevSecret {
+		t.Errorf("Expected ErrDevSecret for the dev encryption key. Received %v", err)
+	}
+	c.EncryptionKey = "a-real-encryption-key"
+	if err := c.Validate(); err != nil {
+		t.Errorf("Expected no error. Received %v", err)
+	}
+}
+
+func TestValidateKeys(t *testing.T) {
+	c := Default()
+	c.PepperID = "2"
+	c.OldPeppers = []Key{{Secret: DevPepper}}
+	c.HMACKeyID = "2"
+	c.OldHMACKeys = []Key{{ID: "1", Secret: "old-hmac-key"}, {Secret: DevHMACKey}}
+	if err := c.Validate(); err != nil {
+		t.Errorf("Expected no error. Received %v", err)
+	}
+
+	cases := map[string]func(c *Config){
+		"duplicate id":  func(c *Config) { c.OldPeppers[0].ID = "2" },
+		"colon":         func(c *Config) { c.HMACKeyID = "a:b" },
+		"empty secret":  func(c *Config) { c.OldHMACKeys[0].Secret = "" },
+		"duplicate old": func(c *Config) { c.OldHMACKeys[1].ID = "1" },
+	}
+	for name, fn := range cases {
+		c := Default()
+		c.PepperID = "2"
+		c.OldPeppers = []Key{{Secret: DevPepper}}
+		c.HMACKeyID = "2"
+		c.OldHMACKeys = []Key{{ID: "1", Secret: "old-hmac-key"}, {Secret: DevHMACKey}}
+		fn(&c)
+		if err := c.Validate(); err != ErrKeyInvalid {
+			t.Errorf("%s: Expected ErrKeyInvalid. Received %v", name, err)
+		}
+	}
+}
+
+func TestValidateOIDC(t *testing.T) {
+	cases := map[string]struct {
+		oidc OIDCConfig
+		err  error
+	}{
+		"off":          {OIDCConfig{}, nil},
+		"https":        {OIDCConfig{Issuer: "https://accounts.google.com", ClientID: "databot"}, nil},
+		"localhost":    {OIDCConfig{Issuer: "http://localhost:5556/dex", ClientID: "databot"}, nil},
+		"no client id": {OIDCConfig{Issuer: "https://accounts.google.com"}, ErrOIDCInvalid},
+		"plain http":   {OIDCConfig{Issuer: "http://accounts.example.com", ClientID: "databot"}, ErrOIDCInvalid},
+		"not a url":    {OIDCConfig{Issuer: "accounts.google.com", ClientID: "databot"}, ErrOIDCInvalid},
+	}
+	for name, tc := range cases {
+		c := Default()
+		c.OIDC = tc.oidc
+		if err := c.Validate(); err != tc.err {
+			t.Errorf("%s: Expected %v. Received %v", name, tc.err, err)
+		}
+	}
+}
@@ -0,0 +1,49 @@
+// Package context stores request-scoped values like the signed in
+// user on a context.Context, with typed accessors so callers don't
+// have to deal with keys or type assertions.
+package context
+
+import (
+	"context"
+
+	"../models"
+)
+
+type privateKey string
+
+const (
+	userKey   privateKey = "user"
+	apiKeyKey privateKey = "api_key"
+)
+
+// WithUser returns a copy of ctx that carries the provided user
+func WithUser(ctx context.Context, user *models.User) context.Context {
+	return context.WithValue(ctx, userKey, user)
+}
+
+// User returns the user stored in ctx, or nil if there isn't one
+func User(ctx context.Context) *models.User {
+	if temp := ctx.Value(userKey); temp != nil {
+		if user, ok := temp.(*models.User); ok {
+			return user
+		}
+	}
+	return nil
+}
+
+// WithAPIKey returns a copy of ctx that records the request was
+// authenticated with the provided API key rather than a session
+func WithAPIKey(ctx context.Context, key *models.APIKey) context.Context {
+	return context.WithValue(ctx, apiKeyKey, key)
+}
+
+// APIKey returns the API key stored in ctx, or nil if the request
+// wasn't authenticated with one
+func APIKey(ctx context.Context) *models.APIKey {
+	if temp := ctx.Value(apiKeyKey); temp != nil {
+		if key, ok := temp.(*models.APIKey); ok {
+			return key
+		}
+	}
+	return nil
+}
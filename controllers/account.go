@@ -0,0 +1,234 @@
+package controllers
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"../context"
+	"../models"
+	"../views"
+)
+
+// AccountForm changes the current user's name and email address.
+// Current is the password, it is needed to change the address.
+type AccountForm struct {
+	Name    string `schema:"name"`
+	Email   string `schema:"email"`
+	Current string `schema:"current"`
+}
+
+// reauthWindow is how recently users without a password have to
+// have logged in on this device to change their email address
+const reauthWindow = 10 * time.Minute
+
+// PasswordForm changes the current user's password. Current is
+// left out by users who only ever signed in with single sign-on.
+type PasswordForm struct {
+	Current  string `schema:"current"`
+	Password string `schema:"password"`
+}
+
+// DeleteAccountForm confirms deleting the account by typing the
+// email address it belongs to
+type DeleteAccountForm struct {
+	Email string `schema:"email"`
+}
+
+// accountPage is the Yield of the account settings page
+type accountPage struct {
+	Form          AccountForm
+	Verified      bool
+	Email         string
+	PendingEmail  string
+	OIDCName      string
+	NoPassword    bool
+	DeletionGrace int
+}
+
+// newAccountPage returns the account page for user with the form
+// filled in from it
+func (u *Users) newAccountPage(user *models.User) *accountPage {
+	return &accountPage{
+		Form: AccountForm{
+			Name: user.Name,
+			// Saving the form again keeps a pending change
+			Email: user.VerifyAddress(),
+		},
+		Verified:      user.Verified(),
+		Email:         user.Email,
+		PendingEmail:  user.PendingEmail,
+		OIDCName:      u.OIDCName,
+		NoPassword:    user.NoPassword,
+		DeletionGrace: u.DeletionGraceDays,
+	}
+}
+
+// Account shows the forms to change the current user's name, email
+// address and password. Every account handler expects to run behind
+// middleware.RequireUser.
+//
+// GET /account
+func (u *Users) Account(w http.ResponseWriter, r *http.Request) {
+	u.AccountView.Render(w, r, u.newAccountPage(context.User(r.Context())))
+}
+
+// UpdateAccount saves the name and email address. Changing the
+// address takes the password, or a fresh login for users without
+// one, so a session alone can't take over the account. The new
+// address only replaces the old one once it is verified, so a link
+// is sent to it.
+//
+// POST /account
+func (u *Users) UpdateAccount(w http.ResponseWriter, r *http.Request) {
+	var vd views.Data
+	user := context.User(r.Context())
+	page := u.newAccountPage(user)
+	vd.Yield = page
+	if err := parseForm(r, &page.Form); err != nil {
+		vd.SetAlert(err)
+		u.AccountView.Render(w, r, vd)
+		return
+	}
+
+	email := strings.ToLower(strings.TrimSpace(page.Form.Email))
+	if email != user.Email && email != user.PendingEmail {
+		if user.NoPassword {
+			if !u.recentLogin(r) {
+				vd.AlertError("Please log in with " + u.OIDCName + " again to change your email address.")
+				vd.Status = http.StatusForbidden
+				u.AccountView.Render(w, r, vd)
+				return
+			}
+		} else if err := u.us.CheckPassword(user.ID, page.Form.Current); err != nil {
+			vd.SetAlert(err)
+			u.AccountView.Render(w, r, vd)
+			return
+		}
+	}
+
+	updated, err := u.us.UpdateProfile(user.ID, page.Form.Name, page.Form.Email)
+	if err != nil {
+		vd.SetAlert(err)
+		u.AccountView.Render(w, r, vd)
+		return
+	}
+	msg := "Your account was saved."
+	if updated.PendingEmail != "" && updated.PendingEmail != user.PendingEmail {
+		if err := u.sendVerify(updated); err != nil {
+			log.Println(err)
+		}
+		msg = "Your account was saved. Check your inbox for a link to verify " + updated.PendingEmail +
+			", until then we keep using " + updated.Email + "."
+	}
+	// Render reads the user from the context, which still has the
+	// old copy
+	r = r.WithContext(context.WithUser(r.Context(), updated))
+	vd.Yield = u.newAccountPage(updated)
+	vd.Alert = &views.Alert{
+		Level:   views.AlertLvlSuccess,
+		Message: msg,
+	}
+	u.AccountView.Render(w, r, vd)
+}
+
+// recentLogin reports whether the session on this device started
+// less than reauthWindow ago
+func (u *Users) recentLogin(r *http.Request) bool {
+	cookie, err := r.Cookie("remember_token")
+	if err != nil {
+		return false
+	}
+	session, err := u.ss.ByToken(cookie.Value)
+	if err != nil {
+		return false
+	}
+	return time.Since(session.CreatedAt) < reauthWindow
+}
+
+// ChangePassword sets a new password after checking the current
+// one. Every other device is signed out and this one gets a new
+// session.
+//
+// POST /account/password
+func (u *Users) ChangePassword(w http.ResponseWriter, r *http.Request) {
+	var vd views.Data
+	var form PasswordForm
+	user := context.User(r.Context())
+	vd.Yield = u.newAccountPage(user)
+	if err := parseForm(r, &form); err != nil {
+		vd.SetAlert(err)
+		u.AccountView.Render(w, r, vd)
+		return
+	}
+
+	if err := u.us.ChangePassword(user.ID, form.Current, form.Password); err != nil {
+		vd.SetAlert(err)
+		u.AccountView.Render(w, r, vd)
+		return
+	}
+	user.NoPassword = false
+	if err := u.signIn(w, r, user); err != nil {
+		http.Redirect(w, r, "/login", http.StatusFound)
+		return
+	}
+	vd.Yield = u.newAccountPage(user)
+	vd.Alert = &views.Alert{
+		Level:   views.AlertLvlSuccess,
+		Message: "Your password was changed and you were signed out on every other device.",
+	}
+	u.AccountView.Render(w, r, vd)
+}
+
+// Export downloads everything stored about the current user as
+// JSON
+//
+// GET /account/export
+func (u *Users) Export(w http.ResponseWriter, r *http.Request) {
+	user := context.User(r.Context())
+	export, err := u.us.Export(user.ID)
+	if err != nil {
+		httpError(w, err)
+		return
+	}
+	b, err := json.MarshalIndent(export, "", "  ")
+	if err != nil {
+		httpError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Disposition", `attachment; filename="databot-account.json"`)
+	w.Write(b)
+}
+
+// DeleteAccount deletes the current user once they typed their
+// email address to confirm, and signs them out
+//
+// POST /account/delete
+func (u *Users) DeleteAccount(w http.ResponseWriter, r *http.Request) {
+	var vd views.Data
+	var form DeleteAccountForm
+	user := context.User(r.Context())
+	vd.Yield = u.newAccountPage(user)
+	if err := parseForm(r, &form); err != nil {
+		vd.SetAlert(err)
+		u.AccountView.Render(w, r, vd)
+		return
+	}
+	if strings.ToLower(strings.TrimSpace(form.Email)) != user.Email {
+		vd.AlertError("Please type your email address to confirm you want to delete your account.")
+		vd.Status = http.StatusUnprocessableEntity
+		u.AccountView.Render(w, r, vd)
+		return
+	}
+
+	if err := u.us.DeleteAccount(user.ID); err != nil {
+		vd.SetAlert(err)
+		u.AccountView.Render(w, r, vd)
+		return
+	}
+	signOut(w)
+	http.Redirect(w, r, "/", http.StatusFound)
+}
@@ -0,0 +1,544 @@
+package controllers
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"../context"
+	"../email"
+	"../models"
+	"../views"
+	"github.com/gorilla/mux"
+)
+
+// NewAdmin is used to create the admin controller. This will panic
+// if the templates are not parsed correctly and should only be used
+// during initial setup.
+func NewAdmin(us models.UserService, ss models.SessionService, emailer *email.Client) *Admin {
+	return &Admin{
+		UsersView: views.NewView("bootstrap", "admin/users", "admin/status"),
+		UserView:  views.NewView("bootstrap", "admin/user", "admin/status"),
+		EditView:  views.NewView("bootstrap", "admin/edit"),
+		us:        us,
+		ss:        ss,
+		emailer:   emailer,
+	}
+}
+
+// Admin lets admins look after other users' accounts. Every handler
+// expects to run behind middleware.RequirePermission, with
+// models.PermUsersRead for the pages and PermUsersWrite or
+// PermUsersDelete for the changes. Only users with models.RoleAdmin
+// can change roles and permissions or touch another admin's account,
+// so those permissions can't be turned into more access.
+type Admin struct {
+	UsersView *views.View
+	UserView  *views.View
+	EditView  *views.View
+	us        models.UserService
+	ss        models.SessionService
+	emailer   *email.Client
+}
+
+// AdminSearchForm is the query string of the user list. Page starts
+// at 1.
+type AdminSearchForm struct {
+	Query string `schema:"q"`
+	Page  int    `schema:"page"`
+}
+
+// adminUsersPage is the Yield of the user list
+type adminUsersPage struct {
+	Users []models.User
+	Query string
+	Page  int
+	Pages int
+	Total int
+}
+
+// PrevPage and NextPage are used by the template for the pager,
+// they are 0 when there is no such page
+func (p *adminUsersPage) PrevPage() int {
+	if p.Page <= 1 {
+		return 0
+	}
+	return p.Page - 1
+}
+
+func (p *adminUsersPage) NextPage() int {
+	if p.Page >= p.Pages {
+		return 0
+	}
+	return p.Page + 1
+}
+
+// adminUserPage is the Yield of a single user's page. Self is set
+// when admins look at their own account, which they can't disable
+// or delete. Protected is set when the user is an admin and whoever
+// is looking isn't, so they can't change anything.
+type adminUserPage struct {
+	User        *models.User
+	Permissions []string
+	Sessions    []models.Session
+	Self        bool
+	Protected   bool
+}
+
+// AdminUserForm edits a user. Permissions holds every permission the
+// user should have, the ones that don't come with Role are granted.
+type AdminUserForm struct {
+	Name        string   `schema:"name"`
+	Email       string   `schema:"email"`
+	Role        string   `schema:"role"`
+	Permissions []string `schema:"permissions"`
+}
+
+// HasPermission is used by the template to check the boxes
+func (f AdminUserForm) HasPermission(perm string) bool {
+	for _, p := range f.Permissions {
+		if p == perm {
+			return true
+		}
+	}
+	return false
+}
+
+// adminEditPage is the Yield of the edit page. CanEditAccess is
+// set when the role, permissions and email address can be changed,
+// which only admins can do and never for themselves.
+type adminEditPage struct {
+	ID            uint
+	Form          AdminUserForm
+	Roles         []string
+	Permissions   []string
+	Self          bool
+	CanEditAccess bool
+}
+
+// Users lists a page of users, optionally only those whose name or
+// email address contains the search
+//
+// GET /admin/users
+func (ad *Admin) Users(w http.ResponseWriter, r *http.Request) {
+	var vd views.Data
+	var form AdminSearchForm
+	page := &adminUsersPage{Page: 1}
+	vd.Yield = page
+	if err := parseURLParams(r, &form); err != nil {
+		vd.SetAlert(err)
+		ad.UsersView.Render(w, r, vd)
+		return
+	}
+	if form.Page > 1 {
+		page.Page = form.Page
+	}
+	page.Query = form.Query
+	users, total, err := ad.us.Search(models.UserQuery{
+		Search: form.Query,
+		Offset: (page.Page - 1) * models.DefaultUsersPerPage,
+		Limit:  models.DefaultUsersPerPage,
+	})
+	if err != nil {
+		vd.SetAlert(err)
+		ad.UsersView.Render(w, r, vd)
+		return
+	}
+	page.Users = users
+	page.Total = total
+	page.Pages = (total + models.DefaultUsersPerPage - 1) / models.DefaultUsersPerPage
+	ad.UsersView.Render(w, r, vd)
+}
+
+// Show is a user's page with their sessions and what can be done
+// with the account
+//
+// GET /admin/users/{id}
+func (ad *Admin) Show(w http.ResponseWriter, r *http.Request) {
+	user, ok := ad.user(w, r)
+	if !ok {
+		return
+	}
+	ad.renderUser(w, r, views.Data{}, user)
+}
+
+// Edit renders the form to change a user's name, email address,
+// role and permissions
+//
+// GET /admin/users/{id}/edit
+func (ad *Admin) Edit(w http.ResponseWriter, r *http.Request) {
+	user, ok := ad.user(w, r)
+	if !ok {
+		return
+	}
+	var vd views.Data
+	page := ad.newEditPage(r, user)
+	vd.Yield = page
+	perms, err := ad.us.Permissions(user)
+	if err != nil {
+		vd.SetAlert(err)
+	}
+	page.Form.Permissions = perms
+	ad.EditView.Render(w, r, vd)
+}
+
+// Update saves the edit form. Only admins can change another user's
+// email address, since a reset link sent to it would hand over the
+// account. The new address waits until it is verified, a link is
+// sent to it and the user is signed out everywhere. Admins can't
+// change their own role, permissions or address here, so there is
+// always one admin left and their own address needs their password.
+//
+// POST /admin/users/{id}
+func (ad *Admin) Update(w http.ResponseWriter, r *http.Request) {
+	user, ok := ad.user(w, r)
+	if !ok {
+		return
+	}
+	var vd views.Data
+	page := ad.newEditPage(r, user)
+	vd.Yield = page
+	if ad.protected(r, user) {
+		vd.AlertError(msgProtected)
+		vd.Status = http.StatusForbidden
+		ad.EditView.Render(w, r, vd)
+		return
+	}
+	perms, err := ad.us.Permissions(user)
+	if err != nil {
+		vd.SetAlert(err)
+		ad.EditView.Render(w, r, vd)
+		return
+	}
+	if err := parseForm(r, &page.Form); err != nil {
+		vd.SetAlert(err)
+		ad.EditView.Render(w, r, vd)
+		return
+	}
+	accessChanged := page.Form.Role != user.Role || !samePermissions(page.Form.Permissions, perms)
+	if accessChanged && !page.CanEditAccess {
+		if page.Self {
+			vd.AlertError("You can't change your own role or permissions.")
+			vd.Status = http.StatusConflict
+		} else {
+			vd.AlertError("Only admins can change roles and permissions.")
+			vd.Status = http.StatusForbidden
+		}
+		ad.EditView.Render(w, r, vd)
+		return
+	}
+	// Compare the way the validator stores it, so only a real change
+	// needs an admin
+	email := strings.ToLower(strings.TrimSpace(page.Form.Email))
+	emailChanged := email != user.Email && email != user.PendingEmail
+	if emailChanged && !page.CanEditAccess {
+		if page.Self {
+			vd.AlertError("Change your own email address on your account page.")
+			vd.Status = http.StatusConflict
+		} else {
+			vd.AlertError("Only admins can change email addresses.")
+			vd.Status = http.StatusForbidden
+		}
+		ad.EditView.Render(w, r, vd)
+		return
+	}
+
+	updated, err := ad.us.UpdateProfile(user.ID, page.Form.Name, page.Form.Email)
+	if err != nil {
+		vd.SetAlert(err)
+		ad.EditView.Render(w, r, vd)
+		return
+	}
+	if emailChanged {
+		if err := ad.us.LogoutAll(user.ID); err != nil {
+			vd.SetAlert(err)
+			ad.EditView.Render(w, r, vd)
+			return
+		}
+	}
+	if page.Form.Role != user.Role {
+		if err := ad.us.SetRole(user.ID, page.Form.Role); err != nil {
+			vd.SetAlert(err)
+			ad.EditView.Render(w, r, vd)
+			return
+		}
+		if updated, err = ad.us.ByID(user.ID); err != nil {
+			vd.SetAlert(err)
+			ad.EditView.Render(w, r, vd)
+			return
+		}
+	}
+	if accessChanged {
+		if err := ad.updatePermissions(updated, page.Form); err != nil {
+			vd.SetAlert(err)
+			ad.EditView.Render(w, r, vd)
+			return
+		}
+	}
+	if updated.PendingEmail != "" && updated.PendingEmail != user.PendingEmail {
+		if err := ad.sendVerify(updated); err != nil {
+			log.Println(err)
+		}
+	}
+	vd.Alert = &views.Alert{
+		Level:   views.AlertLvlSuccess,
+		Message: "The account was saved.",
+	}
+	ad.renderUser(w, r, vd, updated)
+}
+
+// samePermissions reports whether a and b hold the same permissions
+func samePermissions(a, b []string) bool {
+	for _, perm := range models.Permissions {
+		inA := AdminUserForm{Permissions: a}.HasPermission(perm)
+		inB := AdminUserForm{Permissions: b}.HasPermission(perm)
+		if inA != inB {
+			return false
+		}
+	}
+	return true
+}
+
+// updatePermissions grants the permissions in the form that don't
+// come with the user's role and revokes the rest
+func (ad *Admin) updatePermissions(user *models.User, form AdminUserForm) error {
+	fromRole := make(map[string]bool)
+	for _, perm := range models.RolePermissions(user.Role) {
+		fromRole[perm] = true
+	}
+	for _, perm := range models.Permissions {
+		var err error
+		switch {
+		case fromRole[perm]:
+			continue
+		case form.HasPermission(perm):
+			err = ad.us.GrantPermission(user.ID, perm)
+		default:
+			err = ad.us.RevokePermission(user.ID, perm)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Disable stops the user from logging in and signs them out
+//
+// POST /admin/users/{id}/disable
+func (ad *Admin) Disable(w http.ResponseWriter, r *http.Request) {
+	ad.action(w, r, true, "The account was disabled and signed out everywhere.",
+		func(user *models.User) error {
+			return ad.us.Disable(user.ID)
+		})
+}
+
+// Enable lets a disabled user log in again
+//
+// POST /admin/users/{id}/enable
+func (ad *Admin) Enable(w http.ResponseWriter, r *http.Request) {
+	ad.action(w, r, false, "The account was enabled.",
+		func(user *models.User) error {
+			return ad.us.Enable(user.ID)
+		})
+}
+
+// ResetPassword stops the user's password from working and emails
+// them a link to choose a new one. The link goes to the address
+// stored when the token was made, never to a pending one.
+//
+// POST /admin/users/{id}/reset-password
+func (ad *Admin) ResetPassword(w http.ResponseWriter, r *http.Request) {
+	ad.action(w, r, false, "The password was reset and a link to choose a new one was emailed.",
+		func(user *models.User) error {
+			reset, token, err := ad.us.ForcePasswordReset(user.ID)
+			if err != nil {
+				return err
+			}
+			return ad.emailer.ResetPw(reset.Email, token)
+		})
+}
+
+// RevokeSessions signs the user out on every device
+//
+// POST /admin/users/{id}/sessions/revoke
+func (ad *Admin) RevokeSessions(w http.ResponseWriter, r *http.Request) {
+	ad.action(w, r, false, "The user was signed out everywhere.",
+		func(user *models.User) error {
+			return ad.us.LogoutAll(user.ID)
+		})
+}
+
+// RevokeSession signs the user out on one device
+//
+// POST /admin/users/{id}/sessions/{sid}/revoke
+func (ad *Admin) RevokeSession(w http.ResponseWriter, r *http.Request) {
+	sid, err := strconv.ParseUint(mux.Vars(r)["sid"], 10, 64)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	ad.action(w, r, false, "The session was signed out.",
+		func(user *models.User) error {
+			sessions, err := ad.ss.ByUserID(user.ID)
+			if err != nil {
+				return err
+			}
+			for _, s := range sessions {
+				if s.ID == uint(sid) {
+					return ad.ss.Delete(s.ID)
+				}
+			}
+			return models.ErrNotFound
+		})
+}
+
+// Delete signs the user out everywhere and deletes the account,
+// the same way users delete their own
+//
+// POST /admin/users/{id}/delete
+func (ad *Admin) Delete(w http.ResponseWriter, r *http.Request) {
+	user, ok := ad.user(w, r)
+	if !ok {
+		return
+	}
+	if ad.self(r, user) {
+		vd := views.Data{}
+		vd.AlertError("You can't delete your own account from here.")
+		vd.Status = http.StatusConflict
+		ad.renderUser(w, r, vd, user)
+		return
+	}
+	if ad.protected(r, user) {
+		vd := views.Data{}
+		vd.AlertError(msgProtected)
+		vd.Status = http.StatusForbidden
+		ad.renderUser(w, r, vd, user)
+		return
+	}
+	if err := ad.us.DeleteAccount(user.ID); err != nil {
+		httpError(w, err)
+		return
+	}
+	http.Redirect(w, r, "/admin/users", http.StatusFound)
+}
+
+// action runs fn for the user in the URL and shows their page with
+// msg, or with the error. notSelf refuses to do it to the admin's
+// own account. Only admins can do anything to another admin.
+func (ad *Admin) action(w http.ResponseWriter, r *http.Request, notSelf bool, msg string, fn func(*models.User) error) {
+	user, ok := ad.user(w, r)
+	if !ok {
+		return
+	}
+	var vd views.Data
+	switch {
+	case notSelf && ad.self(r, user):
+		vd.AlertError("You can't do that to your own account.")
+		vd.Status = http.StatusConflict
+	case ad.protected(r, user):
+		vd.AlertError(msgProtected)
+		vd.Status = http.StatusForbidden
+	default:
+		if err := fn(user); err != nil {
+			vd.SetAlert(err)
+			break
+		}
+		vd.Alert = &views.Alert{
+			Level:   views.AlertLvlSuccess,
+			Message: msg,
+		}
+		// Show what changed
+		if updated, err := ad.us.ByID(user.ID); err == nil {
+			user = updated
+		}
+	}
+	ad.renderUser(w, r, vd, user)
+}
+
+// user looks up the user with the ID in the URL and responds with
+// a 404 if there is none
+func (ad *Admin) user(w http.ResponseWriter, r *http.Request) (*models.User, bool) {
+	id, err := strconv.ParseUint(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		http.NotFound(w, r)
+		return nil, false
+	}
+	user, err := ad.us.ByID(uint(id))
+	if err != nil {
+		if err == models.ErrNotFound {
+			http.NotFound(w, r)
+		} else {
+			httpError(w, err)
+		}
+		return nil, false
+	}
+	return user, true
+}
+
+// self reports whether user is the admin making the request
+func (ad *Admin) self(r *http.Request, user *models.User) bool {
+	return context.User(r.Context()).ID == user.ID
+}
+
+// msgProtected is shown when someone who isn't an admin tries to
+// change an admin's account
+const msgProtected = "Only admins can change an admin's account."
+
+// isAdmin reports whether the user making the request has
+// models.RoleAdmin rather than just the users permissions
+func (ad *Admin) isAdmin(r *http.Request) bool {
+	return context.User(r.Context()).Role == models.RoleAdmin
+}
+
+// protected reports whether user is an admin and the user making
+// the request isn't
+func (ad *Admin) protected(r *http.Request, user *models.User) bool {
+	return user.Role == models.RoleAdmin && !ad.isAdmin(r)
+}
+
+func (ad *Admin) newEditPage(r *http.Request, user *models.User) *adminEditPage {
+	return &adminEditPage{
+		ID: user.ID,
+		Form: AdminUserForm{
+			Name: user.Name,
+			// Saving the form again keeps a pending change
+			Email: user.VerifyAddress(),
+			Role:  user.Role,
+		},
+		Roles:         models.Roles,
+		Permissions:   models.Permissions,
+		Self:          ad.self(r, user),
+		CanEditAccess: ad.isAdmin(r) && !ad.self(r, user),
+	}
+}
+
+// renderUser shows the user's page along with their permissions
+// and sessions
+func (ad *Admin) renderUser(w http.ResponseWriter, r *http.Request, vd views.Data, user *models.User) {
+	page := &adminUserPage{
+		User:      user,
+		Self:      ad.self(r, user),
+		Protected: ad.protected(r, user),
+	}
+	vd.Yield = page
+	perms, err := ad.us.Permissions(user)
+	if err == nil {
+		page.Permissions = perms
+		page.Sessions, err = ad.ss.ByUserID(user.ID)
+	}
+	if err != nil && vd.Alert == nil {
+		vd.SetAlert(err)
+	}
+	ad.UserView.Render(w, r, vd)
+}
+
+// sendVerify emails a verification link for the user's new address
+func (ad *Admin) sendVerify(user *models.User) error {
+	token, err := ad.us.InitiateVerify(user.ID)
+	if err != nil {
+		return err
+	}
+	return ad.emailer.Verify(user.Name, user.VerifyAddress(), token)
+}
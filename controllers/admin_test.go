@@ -0,0 +1,184 @@
+package controllers
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"../email"
+	"../models"
+	"github.com/gorilla/mux"
+)
+
+// adminFixture has an admin, a member with the users permissions
+// and the accounts they act on
+type adminFixture struct {
+	services *models.Services
+	mailer   *email.MemoryMailer
+	ad       *Admin
+	admin    models.User
+	boss     models.User
+	member   models.User
+	other    models.User
+}
+
+func newAdminFixture(t *testing.T) *adminFixture {
+	services := testingServices(t)
+	emailer, mailer := testingEmailer()
+	f := &adminFixture{
+		services: services,
+		mailer:   mailer,
+		ad:       NewAdmin(services.User, services.Session, emailer),
+		admin:    models.User{Name: "Michael", Email: "michael@dundermifflin.com", Password: "worlds-best-boss", Role: models.RoleAdmin},
+		boss:     models.User{Name: "Jan", Email: "jan@dundermifflin.com", Password: "serenity-by-jan", Role: models.RoleAdmin},
+		member:   models.User{Name: "Toby", Email: "toby@dundermifflin.com", Password: "costa-rica", Role: models.RoleMember},
+		other:    models.User{Name: "Kevin", Email: "kevin@dundermifflin.com", Password: "famous-chili", Role: models.RoleMember},
+	}
+	createUsers(t, services.User, &f.admin, &f.boss, &f.member, &f.other)
+	return f
+}
+
+// serve calls h as by, with target's ID in the URL
+func (f *adminFixture) serve(h http.HandlerFunc, by, target *models.User, form url.Values) *httptest.ResponseRecorder {
+	id := fmt.Sprint(target.ID)
+	r := newRequest("POST", "/admin/users/"+id, form, by)
+	r = mux.SetURLVars(r, map[string]string{"id": id})
+	w := httptest.NewRecorder()
+	h(w, r)
+	return w
+}
+
+// editForm is the edit form for user the way it is filled in
+func (f *adminFixture) editForm(t *testing.T, user *models.User) url.Values {
+	perms, err := f.services.User.Permissions(user)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return url.Values{
+		"name":        {user.Name},
+		"email":       {user.VerifyAddress()},
+		"role":        {user.Role},
+		"permissions": perms,
+	}
+}
+
+func TestAdminUpdate(t *testing.T) {
+	f := newAdminFixture(t)
+	tests := []struct {
+		name   string
+		by     *models.User
+		target *models.User
+		field  string
+		value  string
+		status int
+		msg    string
+	}{
+		{"member edits an admin", &f.member, &f.boss, "name", "Jan Levinson", http.StatusForbidden, msgProtected},
+		{"member changes a role", &f.member, &f.other, "role", models.RoleAdmin, http.StatusForbidden, "Only admins can change roles and permissions."},
+		{"member changes an email", &f.member, &f.other, "email", "kevin@cpa.com", http.StatusForbidden, "Only admins can change email addresses."},
+		{"admin changes own role", &f.admin, &f.admin, "role", models.RoleMember, http.StatusConflict, "You can't change your own role or permissions."},
+		{"admin changes own email", &f.admin, &f.admin, "email", "michael@scranton.com", http.StatusConflict, "Change your own email address on your account page."},
+	}
+	for _, tc := range tests {
+		form := f.editForm(t, tc.target)
+		form.Set(tc.field, tc.value)
+		w := f.serve(f.ad.Update, tc.by, tc.target, form)
+		expectResponse(t, tc.name, w, tc.status, tc.msg)
+		user, err := f.services.User.ByID(tc.target.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if user.Name != tc.target.Name || user.Role != tc.target.Role || user.VerifyAddress() != tc.target.Email {
+			t.Errorf("%s: Expected nothing to change. Received %+v", tc.name, user)
+		}
+	}
+
+	// Anyone with the permission can fix a name
+	form := f.editForm(t, &f.other)
+	form.Set("name", "Kevin Malone")
+	w := f.serve(f.ad.Update, &f.member, &f.other, form)
+	expectResponse(t, "member changes a name", w, http.StatusOK, "The account was saved.")
+	if len(f.mailer.Messages()) != 0 {
+		t.Errorf("Expected no emails. Received %+v", f.mailer.Messages())
+	}
+}
+
+func TestAdminUpdateEmail(t *testing.T) {
+	f := newAdminFixture(t)
+	session := models.Session{UserID: f.other.ID}
+	if err := f.services.Session.Create(&session); err != nil {
+		t.Fatal(err)
+	}
+
+	form := f.editForm(t, &f.other)
+	form.Set("email", "Kevin@CPA.com")
+	w := f.serve(f.ad.Update, &f.admin, &f.other, form)
+	expectResponse(t, "admin changes an email", w, http.StatusOK, "The account was saved.")
+
+	user, err := f.services.User.ByID(f.other.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.Email != "kevin@dundermifflin.com" || user.PendingEmail != "kevin@cpa.com" {
+		t.Errorf("Expected the new address to wait until it is verified. Received %q %q", user.Email, user.PendingEmail)
+	}
+	if _, err := f.services.User.ByRemember(session.Token); err != models.ErrNotFound {
+		t.Errorf("Expected the user to be signed out. Received %v", err)
+	}
+	msgs := f.mailer.Messages()
+	if len(msgs) != 1 || msgs[0].To != "kevin@cpa.com" || !strings.Contains(msgs[0].Text, "/verify?token=") {
+		t.Errorf("Expected a verification link for the new address. Received %+v", msgs)
+	}
+}
+
+func TestAdminAction(t *testing.T) {
+	f := newAdminFixture(t)
+
+	w := f.serve(f.ad.ResetPassword, &f.member, &f.boss, url.Values{})
+	expectResponse(t, "member resets an admin", w, http.StatusForbidden, msgProtected)
+	if _, err := f.services.User.Authenticate(f.boss.Email, "serenity-by-jan"); err != nil {
+		t.Errorf("Expected the admin's password to keep working. Received %v", err)
+	}
+
+	w = f.serve(f.ad.Disable, &f.admin, &f.admin, url.Values{})
+	expectResponse(t, "admin disables self", w, http.StatusConflict, "You can't do that to your own account.")
+	if user, err := f.services.User.ByID(f.admin.ID); err != nil || user.Disabled() {
+		t.Errorf("Expected the admin to stay enabled. Received %v", err)
+	}
+
+	// The link never goes to an address that isn't verified
+	if _, err := f.services.User.UpdateProfile(f.other.ID, f.other.Name, "kevin@cpa.com"); err != nil {
+		t.Fatal(err)
+	}
+	w = f.serve(f.ad.ResetPassword, &f.member, &f.other, url.Values{})
+	expectResponse(t, "member resets a member", w, http.StatusOK, "The password was reset")
+	msgs := f.mailer.Messages()
+	if len(msgs) != 1 || msgs[0].To != "kevin@dundermifflin.com" || !strings.Contains(msgs[0].Text, "/reset?token=") {
+		t.Errorf("Expected a reset link for the stored address. Received %+v", msgs)
+	}
+}
+
+func TestAdminDelete(t *testing.T) {
+	f := newAdminFixture(t)
+
+	w := f.serve(f.ad.Delete, &f.admin, &f.admin, url.Values{})
+	expectResponse(t, "admin deletes self", w, http.StatusConflict, "You can't delete your own account from here.")
+	w = f.serve(f.ad.Delete, &f.member, &f.boss, url.Values{})
+	expectResponse(t, "member deletes an admin", w, http.StatusForbidden, msgProtected)
+	for _, user := range []*models.User{&f.admin, &f.boss} {
+		if _, err := f.services.User.ByID(user.ID); err != nil {
+			t.Errorf("Expected %s to be kept. Received %v", user.Email, err)
+		}
+	}
+
+	w = f.serve(f.ad.Delete, &f.admin, &f.other, url.Values{})
+	if w.Code != http.StatusFound {
+		t.Errorf("Expected a redirect. Received %d", w.Code)
+	}
+	if _, err := f.services.User.ByID(f.other.ID); err != models.ErrNotFound {
+		t.Errorf("Expected the user to be deleted. Received %v", err)
+	}
+}
@@ -0,0 +1,156 @@
+package controllers
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"../context"
+	"../models"
+	"../views"
+	"github.com/gorilla/mux"
+)
+
+// NewAPIKeys is used to create a new API keys controller. This
+// will panic if the templates are not parsed correctly and should
+// only be used during initial setup.
+func NewAPIKeys(aks models.APIKeyService) *APIKeys {
+	return &APIKeys{
+		IndexView: views.NewView("bootstrap", "api_keys/index"),
+		aks:       aks,
+	}
+}
+
+// APIKeys lets users manage the keys their scripts use. Every
+// handler expects to run behind middleware.RequireUser.
+type APIKeys struct {
+	IndexView *views.View
+	aks       models.APIKeyService
+}
+
+// APIKeyForm creates a new key. ExpiresIn is in days, 0 for a key
+// that never expires.
+type APIKeyForm struct {
+	Name      string   `schema:"name"`
+	Scopes    []string `schema:"scopes"`
+	ExpiresIn int      `schema:"expires_in"`
+}
+
+// HasScope is used by the template to check the scope boxes
+func (f APIKeyForm) HasScope(scope string) bool {
+	for _, s := range f.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// apiKeysPage is the Yield of the API keys page. Created is the key
+// that was just made, the only time its Key is available.
+type apiKeysPage struct {
+	Keys    []models.APIKey
+	Created *models.APIKey
+	Form    APIKeyForm
+	Scopes  []string
+}
+
+// Index lists the user's API keys along with a form for a new one
+//
+// GET /api-keys
+func (ak *APIKeys) Index(w http.ResponseWriter, r *http.Request) {
+	var vd views.Data
+	page := ak.newPage()
+	vd.Yield = page
+	if err := ak.loadKeys(r, page); err != nil {
+		vd.SetAlert(err)
+	}
+	ak.IndexView.Render(w, r, vd)
+}
+
+// Create makes a new key and shows it once
+//
+// POST /api-keys
+func (ak *APIKeys) Create(w http.ResponseWriter, r *http.Request) {
+	var vd views.Data
+	var form APIKeyForm
+	page := ak.newPage()
+	vd.Yield = page
+	if err := parseForm(r, &form); err != nil {
+		vd.SetAlert(err)
+		ak.render(w, r, vd, page)
+		return
+	}
+	page.Form = form
+	key := models.APIKey{
+		UserID: context.User(r.Context()).ID,
+		Name:   form.Name,
+		Scopes: strings.Join(form.Scopes, " "),
+	}
+	if form.ExpiresIn != 0 {
+		expires := time.Now().AddDate(0, 0, form.ExpiresIn)
+		key.ExpiresAt = &expires
+	}
+	if err := ak.aks.Create(&key); err != nil {
+		vd.SetAlert(err)
+		ak.render(w, r, vd, page)
+		return
+	}
+	page.Created = &key
+	page.Form = ak.newPage().Form
+	vd.Alert = &views.Alert{
+		Level:   views.AlertLvlSuccess,
+		Message: "Copy your new API key now, it won't be shown again.",
+	}
+	ak.render(w, r, vd, page)
+}
+
+// Revoke deletes one of the user's keys. Scripts using it stop
+// working right away.
+//
+// POST /api-keys/{id}/revoke
+func (ak *APIKeys) Revoke(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseUint(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	user := context.User(r.Context())
+	if err := ak.aks.Revoke(user.ID, uint(id)); err != nil {
+		if err == models.ErrNotFound {
+			http.NotFound(w, r)
+			return
+		}
+		httpError(w, err)
+		return
+	}
+	http.Redirect(w, r, "/api-keys", http.StatusFound)
+}
+
+func (ak *APIKeys) newPage() *apiKeysPage {
+	return &apiKeysPage{
+		Form: APIKeyForm{
+			Scopes:    []string{models.ScopeRead},
+			ExpiresIn: 90,
+		},
+		Scopes: models.APIScopes,
+	}
+}
+
+// render shows the page with the user's current keys
+func (ak *APIKeys) render(w http.ResponseWriter, r *http.Request, vd views.Data, page *apiKeysPage) {
+	if err := ak.loadKeys(r, page); err != nil && vd.Alert == nil {
+		vd.SetAlert(err)
+	}
+	ak.IndexView.Render(w, r, vd)
+}
+
+func (ak *APIKeys) loadKeys(r *http.Request, page *apiKeysPage) error {
+	keys, err := ak.aks.ByUserID(context.User(r.Context()).ID)
+	if err != nil {
+		return err
+	}
+	page.Keys = keys
+	return nil
+}
@@ -1,19 +1,75 @@
 package controllers
 
 import (
+	"log"
 	"net/http"
+	"net/url"
 
 	"github.com/gorilla/schema"
+	"../models"
+	"../views"
 )
 
+// formError is returned by the parse helpers when the request
+// can't be read into the form, which is the client's fault
+type formError struct {
+	err error
+}
+
+func (e formError) Error() string {
+	return "controllers: parsing form: " + e.err.Error()
+}
+
+func (e formError) Unwrap() error {
+	return e.err
+}
+
+func (e formError) Public() string {
+	return "We couldn't read that form. Please check what you entered and try again."
+}
+
+func (e formError) Status() int {
+	return http.StatusBadRequest
+}
+
+var _ models.PublicError = formError{}
+
 func parseForm(r *http.Request, dst interface{}) error {
 	if err := r.ParseForm(); err != nil{
-		return(err)
+		return formError{err}
+	}
+	return parseValues(r.PostForm, dst)
+}
+
+// parseURLParams decodes the query string, for GET pages that
+// prefill a form from a link
+func parseURLParams(r *http.Request, dst interface{}) error {
+	if err := r.ParseForm(); err != nil {
+		return formError{err}
 	}
+	return parseValues(r.Form, dst)
+}
 
+func parseValues(values url.Values, dst interface{}) error {
 	dec := schema.NewDecoder()
-	if err := dec.Decode(dst, r.PostForm); err != nil{
-		return(err)
+	// Forms carry fields like the CSRF token that aren't
+	// part of the destination struct
+	dec.IgnoreUnknownKeys(true)
+	if err := dec.Decode(dst, values); err != nil{
+		return formError{err}
 	}
 	return nil
-}
\ No newline at end of file
+}
+
+// httpError responds with a plain text error for handlers that
+// don't have a view to re-render. Public model errors are shown
+// with their own status, anything else is logged and hidden
+// behind a 500.
+func httpError(w http.ResponseWriter, err error) {
+	if pErr, ok := models.IsPublic(err); ok {
+		http.Error(w, pErr.Public(), pErr.Status())
+		return
+	}
+	log.Println(err)
+	http.Error(w, views.AlertMsgGeneric, http.StatusInternalServerError)
+}
@@ -0,0 +1,74 @@
+package controllers
+
+import (
+	"html/template"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"../context"
+	"../email"
+	"../models"
+	"../views"
+)
+
+func init() {
+	views.LayoutDir = "../views/layouts/"
+	views.TemplateDir = "../views/"
+	email.TemplateDir = "../views/email/"
+}
+
+func testingServices(t *testing.T) *models.Services {
+	services, err := models.NewServices(
+		models.WithMemory(),
+		models.WithUser("test-pepper", "test-hmac-key"),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return services
+}
+
+// testingEmailer returns an email client along with the mailer that
+// keeps everything it sends
+func testingEmailer() (*email.Client, *email.MemoryMailer) {
+	mm := &email.MemoryMailer{}
+	return email.NewClient(mm, "support@databot.local", "https://databot.example"), mm
+}
+
+func createUsers(t *testing.T, us models.UserService, users ...*models.User) {
+	for _, user := range users {
+		if err := us.Create(user); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// newRequest returns a request made by user, or by nobody if user
+// is nil. A form is sent as the POST body.
+func newRequest(method, target string, form url.Values, user *models.User) *http.Request {
+	var r *http.Request
+	if form != nil {
+		r = httptest.NewRequest(method, target, strings.NewReader(form.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	} else {
+		r = httptest.NewRequest(method, target, nil)
+	}
+	if user != nil {
+		r = r.WithContext(context.WithUser(r.Context(), user))
+	}
+	return r
+}
+
+// expectResponse checks the status and that the page shows msg
+func expectResponse(t *testing.T, name string, w *httptest.ResponseRecorder, status int, msg string) {
+	t.Helper()
+	if w.Code != status {
+		t.Errorf("%s: Expected status %d. Received %d", name, status, w.Code)
+	}
+	if !strings.Contains(w.Body.String(), template.HTMLEscapeString(msg)) {
+		t.Errorf("%s: Expected the page to say %q", name, msg)
+	}
+}
@@ -0,0 +1,145 @@
+package controllers
+
+import (
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"../hash"
+	"../models"
+	"../oidc"
+	"../views"
+)
+
+const (
+	// oidcCookie keeps the state, nonce and PKCE verifier of a login
+	// with the OpenID Connect provider until it redirects back
+	oidcCookie = "login_oidc"
+
+	// oidcLoginTTL is how long someone has to log in at the provider
+	oidcLoginTTL = 10 * time.Minute
+)
+
+// OIDCLogin sends the user to the OpenID Connect provider to log in
+//
+// GET /login/oidc
+func (u *Users) OIDCLogin(w http.ResponseWriter, r *http.Request) {
+	if u.OIDC == nil {
+		http.NotFound(w, r)
+		return
+	}
+	ar, err := oidc.NewAuthRequest()
+	if err != nil {
+		httpError(w, err)
+		return
+	}
+	authURL, err := u.OIDC.AuthURL(r.Context(), ar)
+	if err != nil {
+		httpError(w, err)
+		return
+	}
+	cookie := http.Cookie{
+		Name:     oidcCookie,
+		Value:    strings.Join([]string{ar.State, ar.Nonce, ar.Verifier}, "."),
+		Path:     "/login/oidc",
+		MaxAge:   int(oidcLoginTTL / time.Second),
+		HttpOnly: true,
+		// Lax so the cookie comes along on the provider's redirect
+		SameSite: http.SameSiteLaxMode,
+	}
+	http.SetCookie(w, &cookie)
+	http.Redirect(w, r, authURL, http.StatusFound)
+}
+
+// OIDCCallback is where the provider sends the user back to. It
+// checks the login came from this browser, verifies the ID token
+// and logs in the user linked to it, going through the second login
+// step if they have one.
+//
+// GET /login/oidc/callback
+func (u *Users) OIDCCallback(w http.ResponseWriter, r *http.Request) {
+	if u.OIDC == nil {
+		http.NotFound(w, r)
+		return
+	}
+	var vd views.Data
+	vd.Yield = u.newLoginForm("")
+	ar, ok := oidcAuthRequest(r)
+	clearOIDC(w)
+	q := r.URL.Query()
+	switch {
+	case !ok || !hash.Equal(q.Get("state"), ar.State):
+		vd.AlertError("That took too long or didn't start here. Please log in again.")
+		vd.Status = http.StatusBadRequest
+		u.LoginView.Render(w, r, vd)
+		return
+	case q.Get("error") != "":
+		// Usually the user pressed cancel at the provider
+		vd.AlertError("You weren't logged in with " + u.OIDCName + ". Please try again.")
+		vd.Status = http.StatusUnauthorized
+		u.LoginView.Render(w, r, vd)
+		return
+	}
+
+	claims, err := u.OIDC.Exchange(r.Context(), q.Get("code"), ar)
+	if err != nil {
+		log.Println(err)
+		vd.AlertError("We couldn't log you in with " + u.OIDCName + ". Please try again.")
+		vd.Status = http.StatusUnauthorized
+		u.LoginView.Render(w, r, vd)
+		return
+	}
+	user, err := u.us.LoginExternal(models.ExternalLogin{
+		Issuer:        claims.Issuer,
+		Subject:       claims.Subject,
+		Email:         claims.Email,
+		EmailVerified: bool(claims.EmailVerified),
+		Name:          claims.Name,
+	})
+	if err != nil {
+		vd.SetAlert(err)
+		u.LoginView.Render(w, r, vd)
+		return
+	}
+
+	if user.TOTPEnabled() {
+		u.startSecondFactor(w, r, user)
+		return
+	}
+	if err := u.signIn(w, r, user); err != nil {
+		httpError(w, err)
+		return
+	}
+	http.Redirect(w, r, "/cookietest", http.StatusFound)
+}
+
+// oidcAuthRequest reads back the values OIDCLogin stored
+func oidcAuthRequest(r *http.Request) (*oidc.AuthRequest, bool) {
+	cookie, err := r.Cookie(oidcCookie)
+	if err != nil {
+		return nil, false
+	}
+	parts := strings.Split(cookie.Value, ".")
+	if len(parts) != 3 || parts[0] == "" {
+		return nil, false
+	}
+	return &oidc.AuthRequest{
+		State:    parts[0],
+		Nonce:    parts[1],
+		Verifier: parts[2],
+	}, true
+}
+
+// clearOIDC expires the cookie so a login can only be completed once
+func clearOIDC(w http.ResponseWriter) {
+	cookie := http.Cookie{
+		Name:     oidcCookie,
+		Value:    "",
+		Path:     "/login/oidc",
+		Expires:  time.Unix(0, 0),
+		MaxAge:   -1,
+		HttpOnly: true,
+	}
+	http.SetCookie(w, &cookie)
+}
@@ -0,0 +1,41 @@
+package controllers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"../oidc"
+)
+
+func TestOIDCCallbackRejected(t *testing.T) {
+	services := testingServices(t)
+	emailer, _ := testingEmailer()
+	u := NewUsers(services.User, services.Session, emailer)
+	u.OIDC = oidc.NewClient(oidc.Config{
+		Issuer:      "https://accounts.example.com",
+		ClientID:    "databot",
+		RedirectURL: "https://databot.example/login/oidc/callback",
+	})
+	u.OIDCName = "Example"
+
+	tests := map[string]string{
+		"no cookie":      "",
+		"state mismatch": "state-a.nonce.verifier",
+		"empty state":    ".nonce.verifier",
+	}
+	for name, cookie := range tests {
+		r := newRequest("GET", "/login/oidc/callback?state=state-b&code=abc", nil, nil)
+		if cookie != "" {
+			r.AddCookie(&http.Cookie{Name: oidcCookie, Value: cookie})
+		}
+		w := httptest.NewRecorder()
+		u.OIDCCallback(w, r)
+		expectResponse(t, name, w, http.StatusBadRequest, "Please log in again.")
+		for _, c := range w.Result().Cookies() {
+			if c.Name == "remember_token" {
+				t.Errorf("%s: Expected no session. Received %+v", name, c)
+			}
+		}
+	}
+}
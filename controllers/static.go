@@ -1,15 +1,46 @@
 package controllers
 
-import "../views"
+import (
+	"log"
+	"net/http"
+
+	"github.com/gorilla/csrf"
+	"../views"
+)
 
 func NewStatic() *Static{
 	return &Static{
 		HomeView: views.NewView("bootstrap", "static/home"),
 		ContactView: views.NewView("bootstrap", "static/contact"),
+		ForbiddenView: views.NewView("bootstrap", "static/forbidden"),
+		PermissionDeniedView: views.NewView("bootstrap", "static/permission_denied"),
 	}
 }
 
 type Static struct {
 	HomeView *views.View 
 	ContactView *views.View 
-}
\ No newline at end of file
+	ForbiddenView *views.View
+	PermissionDeniedView *views.View
+}
+
+// CSRFFailure renders the forbidden page when a form is posted
+// without a valid CSRF token. It is the csrf.ErrorHandler.
+func (s *Static) CSRFFailure(w http.ResponseWriter, r *http.Request) {
+	log.Println("csrf:", csrf.FailureReason(r))
+	w.Header().Set("Content-Type", "text/html")
+	w.WriteHeader(http.StatusForbidden)
+	if err := s.ForbiddenView.Render(w, r, nil); err != nil {
+		log.Println(err)
+	}
+}
+
+// PermissionDenied renders the page for signed in users who aren't
+// allowed to see what they asked for. It is the Forbidden handler
+// of middleware.RequirePermission.
+func (s *Static) PermissionDenied(w http.ResponseWriter, r *http.Request) {
+	vd := views.Data{Status: http.StatusForbidden}
+	if err := s.PermissionDeniedView.Render(w, r, vd); err != nil {
+		log.Println(err)
+	}
+}
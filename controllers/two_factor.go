@@ -0,0 +1,223 @@
+package controllers
+
+import (
+	"encoding/base64"
+	"html/template"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"../context"
+	"../models"
+	"../totp"
+	"../views"
+	"github.com/skip2/go-qrcode"
+)
+
+// secondFactorCookie holds the token for the second login step
+// between the password and the code
+const secondFactorCookie = "login_2fa"
+
+// TOTPForm is used by every form that takes a two-factor code.
+// Secret is only posted back to draw the QR code again after a
+// wrong code, the secret that counts is the one stored on the user.
+type TOTPForm struct {
+	Code   string `schema:"code"`
+	Secret string `schema:"secret"`
+}
+
+// twoFactorPage is the Yield of the two-factor settings page. QR
+// and Secret are only set while setting it up.
+type twoFactorPage struct {
+	Enabled bool
+	Secret  string
+	QR      template.URL
+}
+
+// TwoFactor shows whether two-factor authentication is turned on,
+// with a button to set it up or a form to turn it off. Expects to
+// run behind middleware.RequireUser.
+//
+// GET /2fa
+func (u *Users) TwoFactor(w http.ResponseWriter, r *http.Request) {
+	user := context.User(r.Context())
+	u.TwoFactorView.Render(w, r, &twoFactorPage{Enabled: user.TOTPEnabled()})
+}
+
+// SetupTOTP gives the user a new secret and shows it as a QR code
+// to scan with their authenticator app
+//
+// POST /2fa/setup
+func (u *Users) SetupTOTP(w http.ResponseWriter, r *http.Request) {
+	var vd views.Data
+	user := context.User(r.Context())
+	page := twoFactorPage{Enabled: user.TOTPEnabled()}
+	vd.Yield = &page
+	secret, uri, err := u.us.InitiateTOTP(user.ID)
+	if err == nil {
+		page.Secret = secret
+		page.QR, err = qrDataURI(uri)
+	}
+	if err != nil {
+		vd.SetAlert(err)
+	}
+	u.TwoFactorView.Render(w, r, vd)
+}
+
+// EnableTOTP checks the first code from the app, turns on
+// two-factor authentication and shows the recovery codes once
+//
+// POST /2fa/enable
+func (u *Users) EnableTOTP(w http.ResponseWriter, r *http.Request) {
+	var vd views.Data
+	var form TOTPForm
+	user := context.User(r.Context())
+	page := twoFactorPage{Enabled: user.TOTPEnabled()}
+	vd.Yield = &page
+	if err := parseForm(r, &form); err != nil {
+		vd.SetAlert(err)
+		u.TwoFactorView.Render(w, r, vd)
+		return
+	}
+	codes, err := u.us.EnableTOTP(user.ID, form.Code)
+	if err != nil {
+		vd.SetAlert(err)
+		if form.Secret != "" && !page.Enabled {
+			page.Secret = form.Secret
+			page.QR, _ = qrDataURI(totpURI(user, form.Secret))
+		}
+		u.TwoFactorView.Render(w, r, vd)
+		return
+	}
+	vd.Yield = codes
+	vd.Alert = &views.Alert{
+		Level:   views.AlertLvlSuccess,
+		Message: "Two-factor authentication is on.",
+	}
+	u.RecoveryCodesView.Render(w, r, vd)
+}
+
+// DisableTOTP turns off two-factor authentication
+//
+// POST /2fa/disable
+func (u *Users) DisableTOTP(w http.ResponseWriter, r *http.Request) {
+	var vd views.Data
+	var form TOTPForm
+	user := context.User(r.Context())
+	vd.Yield = &twoFactorPage{Enabled: user.TOTPEnabled()}
+	if err := parseForm(r, &form); err != nil {
+		vd.SetAlert(err)
+		u.TwoFactorView.Render(w, r, vd)
+		return
+	}
+	if err := u.us.DisableTOTP(user.ID, form.Code); err != nil {
+		vd.SetAlert(err)
+		u.TwoFactorView.Render(w, r, vd)
+		return
+	}
+	vd.Yield = &twoFactorPage{}
+	vd.Alert = &views.Alert{
+		Level:   views.AlertLvlSuccess,
+		Message: "Two-factor authentication is off.",
+	}
+	u.TwoFactorView.Render(w, r, vd)
+}
+
+// LoginTOTP is the second login step. It checks the code against
+// the user whose password was accepted and signs them in.
+//
+// POST /login/2fa
+func (u *Users) LoginTOTP(w http.ResponseWriter, r *http.Request) {
+	var vd views.Data
+	var form TOTPForm
+	cookie, err := r.Cookie(secondFactorCookie)
+	if err != nil {
+		http.Redirect(w, r, "/login", http.StatusFound)
+		return
+	}
+	if err := parseForm(r, &form); err != nil {
+		vd.SetAlert(err)
+		u.LoginTOTPView.Render(w, r, vd)
+		return
+	}
+
+	ip := clientIP(r)
+	if wait := u.ipLimiter.Wait(ip); wait > 0 {
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+		vd.SetAlert(models.ErrTooManyAttempts)
+		u.LoginTOTPView.Render(w, r, vd)
+		return
+	}
+
+	user, err := u.us.CompleteSecondFactor(cookie.Value, form.Code)
+	switch err {
+	case nil:
+	case models.ErrTokenInvalid:
+		clearSecondFactor(w)
+		vd.Yield = u.newLoginForm("")
+		vd.AlertError("That took too long. Please log in again.")
+		vd.Status = http.StatusUnauthorized
+		u.LoginView.Render(w, r, vd)
+		return
+	case models.ErrTOTPInvalid:
+		u.ipLimiter.Fail(ip)
+		fallthrough
+	default:
+		vd.SetAlert(err)
+		u.LoginTOTPView.Render(w, r, vd)
+		return
+	}
+
+	clearSecondFactor(w)
+	if err := u.signIn(w, r, user); err != nil {
+		httpError(w, err)
+		return
+	}
+	http.Redirect(w, r, "/cookietest", http.StatusFound)
+}
+
+// startSecondFactor sends a user whose password was right on to
+// the second login step instead of signing them in
+func (u *Users) startSecondFactor(w http.ResponseWriter, r *http.Request, user *models.User) {
+	token, err := u.us.InitiateSecondFactor(user.ID)
+	if err != nil {
+		httpError(w, err)
+		return
+	}
+	cookie := http.Cookie{
+		Name:     secondFactorCookie,
+		Value:    token,
+		Path:     "/login",
+		HttpOnly: true,
+	}
+	http.SetCookie(w, &cookie)
+	http.Redirect(w, r, "/login/2fa", http.StatusFound)
+}
+
+// clearSecondFactor expires the second login step cookie
+func clearSecondFactor(w http.ResponseWriter) {
+	cookie := http.Cookie{
+		Name:     secondFactorCookie,
+		Value:    "",
+		Path:     "/login",
+		Expires:  time.Unix(0, 0),
+		MaxAge:   -1,
+		HttpOnly: true,
+	}
+	http.SetCookie(w, &cookie)
+}
+
+// qrDataURI draws uri as a QR code and returns it as a data: URI
+// that can be used as an image src
+func qrDataURI(uri string) (template.URL, error) {
+	png, err := qrcode.Encode(uri, qrcode.Medium, 256)
+	if err != nil {
+		return "", err
+	}
+	return template.URL("data:image/png;base64," + base64.StdEncoding.EncodeToString(png)), nil
+}
+
+func totpURI(user *models.User, secret string) string {
+	return totp.URI(models.TOTPIssuer, user.Email, secret)
+}
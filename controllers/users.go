@@ -2,10 +2,19 @@ package controllers
 
 import (
 	"fmt"
+	"log"
+	"math"
+	"net"
 	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"../context"
+	"../email"
 	"../models"
+	"../oidc"
+	"../throttle"
 	"../views"
-	"../rand"
 )
 
 // Putting this here is for consistency later
@@ -14,19 +23,51 @@ import (
 //This will panic if the templeates are not
 //parsed correctly and should only be used during
 //inital setup.
-func NewUsers(us models.UserService) *Users {
+func NewUsers(us models.UserService, ss models.SessionService, emailer *email.Client) *Users {
 	return &Users{
 		NewView: views.NewView("bootstrap", "users/new"),
 		LoginView: views.NewView("bootstrap", "users/login"),
+		ForgotPwView: views.NewView("bootstrap", "users/forgot_pw"),
+		ResetPwView: views.NewView("bootstrap", "users/reset_pw"),
+		VerifyView: views.NewView("bootstrap", "users/verify"),
+		TwoFactorView: views.NewView("bootstrap", "users/two_factor"),
+		RecoveryCodesView: views.NewView("bootstrap", "users/recovery_codes"),
+		LoginTOTPView: views.NewView("bootstrap", "users/login_2fa"),
+		AccountView: views.NewView("bootstrap", "users/account"),
 		us: us,
+		ss: ss,
+		emailer: emailer,
+		// One address can make a few more mistakes than one
+		// account, people share IPs behind NATs
+		ipLimiter: throttle.NewLimiter(20, time.Second, 15*time.Minute),
+		// Matches the lockout stored on the user, but also covers
+		// addresses without an account so they look the same
+		emailLimiter: throttle.NewLimiter(models.LockoutFree, models.LockoutBase, models.LockoutMax),
 	}
 }
 
 type Users struct {
 	NewView *views.View
 	LoginView *views.View
+	ForgotPwView *views.View
+	ResetPwView *views.View
+	VerifyView *views.View
+	TwoFactorView *views.View
+	RecoveryCodesView *views.View
+	LoginTOTPView *views.View
+	AccountView *views.View
+	// OIDC is the OpenID Connect provider users can log in with,
+	// nil if there is none. OIDCName is shown on its button.
+	OIDC *oidc.Client
+	OIDCName string
+	// DeletionGraceDays is how long deleted accounts are kept
+	// before they are purged, it is shown on the account page
+	DeletionGraceDays int
 	us models.UserService
-
+	ss models.SessionService
+	emailer *email.Client
+	ipLimiter *throttle.Limiter
+	emailLimiter *throttle.Limiter
 }
 
 // New is used to render the form where a user can create a 
@@ -34,7 +75,7 @@ type Users struct {
 //
 // GET /signup
 func (u *Users) New(w http.ResponseWriter, r *http.Request) {
-	u.NewView.Render(w, nil)
+	u.NewView.Render(w, r, nil)
 }
 
 type SignupForm struct {
@@ -48,21 +89,43 @@ type SignupForm struct {
 //
 // POST /signup
 func (u *Users) Create(w http.ResponseWriter, r *http.Request){
+	var vd views.Data
 	var form SignupForm
+	vd.Yield = &form
 	if err := parseForm(r, &form); err != nil {
-		panic(err)
+		vd.SetAlert(err)
+		u.NewView.Render(w, r, vd)
+		return
 	}
 	user := models.User{
 		Name: form.Name,
 		Email: form.Email,
 		Password: form.Password,
 	}
+	// Never send the password back to the browser
+	form.Password = ""
 
 	if err := u.us.Create(&user); err != nil{
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		vd.SetAlert(err)
+		u.NewView.Render(w, r, vd)
+		return
+	}
+	// The account exists either way, so don't fail the signup.
+	// A new link can be requested from the verify page.
+	if err := u.sendVerify(&user); err != nil {
+		log.Println(err)
+	}
+	if u.us.VerificationRequired() {
+		vd.Yield = u.newLoginForm(user.Email)
+		vd.Alert = &views.Alert{
+			Level: views.AlertLvlSuccess,
+			Message: "Almost done! Check your inbox for a link to verify your email address, then log in.",
+		}
+		u.LoginView.Render(w, r, vd)
+		return
 	}
 
-	err := u.signIn(w, &user)
+	err := u.signIn(w, r, &user)
 	if err != nil {
 		// Check that this is correct
 		http.Redirect(w, r, "/login", http.StatusFound)
@@ -71,9 +134,28 @@ func (u *Users) Create(w http.ResponseWriter, r *http.Request){
 	http.Redirect(w, r, "/cookietest", http.StatusFound)
 }
 
+// LoginForm is the Yield of the login page. OIDCName is only
+// set when users can also log in with an OpenID Connect provider.
 type LoginForm struct {
 	Email string `schema:"email"`
 	Password string `schema:"password"`
+	OIDCName string `schema:"-"`
+}
+
+// newLoginForm returns a login form prefilled with email
+func (u *Users) newLoginForm(email string) *LoginForm {
+	form := LoginForm{Email: email}
+	if u.OIDC != nil {
+		form.OIDCName = u.OIDCName
+	}
+	return &form
+}
+
+// LoginPage renders the login form
+//
+// GET /login
+func (u *Users) LoginPage(w http.ResponseWriter, r *http.Request) {
+	u.LoginView.Render(w, r, u.newLoginForm(""))
 }
 
 // Login is used to verify the provided email address and
@@ -81,66 +163,338 @@ type LoginForm struct {
 //
 // POST /login
 func (u *Users) Login(w http.ResponseWriter, r *http.Request) {
-	form := LoginForm{}
-	if err := parseForm(r, &form); err != nil {
-		panic(err)
+	var vd views.Data
+	form := u.newLoginForm("")
+	vd.Yield = form
+	if err := parseForm(r, form); err != nil {
+		vd.SetAlert(err)
+		u.LoginView.Render(w, r, vd)
+		return
 	}
 
-	user, err := u.us.Authenticate(form.Email, form.Password)
+	// Never send the password back to the browser
+	password := form.Password
+	form.Password = ""
+
+	ip := clientIP(r)
+	email := strings.ToLower(strings.TrimSpace(form.Email))
+	if wait := u.loginWait(ip, email); wait > 0 {
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+		vd.SetAlert(models.ErrTooManyAttempts)
+		u.LoginView.Render(w, r, vd)
+		return
+	}
+
+	user, err := u.us.Authenticate(form.Email, password)
 	if err != nil {
 		switch err {
-		case models.ErrPasswordIncorrect:
-			fmt.Fprintln(w, "Invalid Password Provided")
-		case models.ErrNotFound:
-			fmt.Fprintln(w, "Invalid Email Address")
-		default:
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+		case models.ErrCredentialsInvalid:
+			u.ipLimiter.Fail(ip)
+			u.emailLimiter.Fail(email)
+		case models.ErrEmailNotVerified:
+			// The password was right, so the old link may well have
+			// expired. Send a new one.
+			if err := u.resendVerify(form.Email); err != nil {
+				log.Println(err)
+			}
+		case models.ErrPasswordResetRequired:
+			// Same for the reset link an admin had sent
+			if err := u.sendReset(form.Email); err != nil {
+				log.Println(err)
+			}
 		}
+		vd.SetAlert(err)
+		u.LoginView.Render(w, r, vd)
+		return
 	}
+	u.emailLimiter.Reset(email)
 
+	if user.TOTPEnabled() {
+		u.startSecondFactor(w, r, user)
+		return
+	}
 
-	err = u.signIn(w, user)
+	err = u.signIn(w, r, user)
 	if err != nil {
-	  http.Error(w, err.Error(), http.StatusInternalServerError)
+	  httpError(w, err)
 	  return
 	}
 	http.Redirect(w, r, "/cookietest", http.StatusFound)
 }
 
-func (u *Users) signIn(w http.ResponseWriter, user *models.User) error {
-	// Make sure we have a remember token available
-	if user.Remember == "" {
-		token, err := rand.RememberToken()
-		if err != nil {
-			return err
-		}
-		user.Remember = token
-		err = u.us.Update(user)
-		if err != nil {
-			return err
+// loginWait returns how long logins from ip or for email have to
+// wait after too many wrong passwords
+func (u *Users) loginWait(ip, email string) time.Duration {
+	wait := u.ipLimiter.Wait(ip)
+	if w := u.emailLimiter.Wait(email); w > wait {
+		wait = w
+	}
+	return wait
+}
+
+// ResetPwForm is used by both the forgot and reset password pages
+type ResetPwForm struct {
+	Email string `schema:"email"`
+	Token string `schema:"token"`
+	Password string `schema:"password"`
+}
+
+// InitiateReset creates a password reset token for the email
+// address. The page looks the same whether or not the address has
+// an account so it can't be used to find out who signed up.
+//
+// POST /forgot
+func (u *Users) InitiateReset(w http.ResponseWriter, r *http.Request) {
+	var vd views.Data
+	var form ResetPwForm
+	vd.Yield = &form
+	if err := parseForm(r, &form); err != nil {
+		vd.SetAlert(err)
+		u.ForgotPwView.Render(w, r, vd)
+		return
+	}
+
+	user, token, err := u.us.InitiateReset(form.Email)
+	switch err {
+	case nil:
+		// An error here would give away that the account exists
+		if err := u.emailer.ResetPw(user.Email, token); err != nil {
+			log.Println(err)
 		}
+	case models.ErrNotFound:
+	default:
+		vd.SetAlert(err)
+		u.ForgotPwView.Render(w, r, vd)
+		return
+	}
+	vd.Alert = &views.Alert{
+		Level: views.AlertLvlSuccess,
+		Message: "If that email address has an account, instructions to reset your password are on their way.",
+	}
+	u.ForgotPwView.Render(w, r, vd)
+}
+
+// ResetPw renders the reset password form, filling in the token
+// from the link that was sent
+//
+// GET /reset
+func (u *Users) ResetPw(w http.ResponseWriter, r *http.Request) {
+	var vd views.Data
+	var form ResetPwForm
+	vd.Yield = &form
+	if err := parseURLParams(r, &form); err != nil {
+		vd.SetAlert(err)
+	}
+	u.ResetPwView.Render(w, r, vd)
+}
+
+// CompleteReset sets the new password and signs the user in
+//
+// POST /reset
+func (u *Users) CompleteReset(w http.ResponseWriter, r *http.Request) {
+	var vd views.Data
+	var form ResetPwForm
+	vd.Yield = &form
+	if err := parseForm(r, &form); err != nil {
+		vd.SetAlert(err)
+		u.ResetPwView.Render(w, r, vd)
+		return
+	}
+
+	user, err := u.us.CompleteReset(form.Token, form.Password)
+	form.Password = ""
+	if err != nil {
+		vd.SetAlert(err)
+		u.ResetPwView.Render(w, r, vd)
+		return
+	}
+	// A new password doesn't skip the second factor
+	if user.TOTPEnabled() {
+		u.startSecondFactor(w, r, user)
+		return
+	}
+
+	if err := u.signIn(w, r, user); err != nil {
+		http.Redirect(w, r, "/login", http.StatusFound)
+		return
+	}
+	http.Redirect(w, r, "/cookietest", http.StatusFound)
+}
+
+type VerifyForm struct {
+	Token string `schema:"token"`
+}
+
+// Verify marks the email address the link was sent to as verified
+// and sends the welcome email, or tells the old address when it was
+// a new one
+//
+// GET /verify
+func (u *Users) Verify(w http.ResponseWriter, r *http.Request) {
+	var vd views.Data
+	vd.Yield = context.User(r.Context())
+	var form VerifyForm
+	if err := parseURLParams(r, &form); err != nil {
+		vd.SetAlert(err)
+		u.VerifyView.Render(w, r, vd)
+		return
+	}
+	if form.Token == "" {
+		u.VerifyView.Render(w, r, vd)
+		return
+	}
+
+	user, previous, err := u.us.CompleteVerify(form.Token)
+	if err != nil {
+		vd.SetAlert(err)
+		u.VerifyView.Render(w, r, vd)
+		return
+	}
+	if previous != "" {
+		err = u.emailer.EmailChanged(user.Name, previous, user.Email)
+	} else {
+		err = u.emailer.Welcome(user.Name, user.Email)
+	}
+	if err != nil {
+		log.Println(err)
+	}
+	// Render reads the user from the context, which still has the
+	// unverified copy
+	r = r.WithContext(context.WithUser(r.Context(), user))
+	vd.Yield = user
+	vd.Alert = &views.Alert{
+		Level: views.AlertLvlSuccess,
+		Message: "Thanks, your email address is verified.",
+	}
+	u.VerifyView.Render(w, r, vd)
+}
+
+// ResendVerify sends a new verification link to the current user.
+// Expects to run behind middleware.RequireUser.
+//
+// POST /verify
+func (u *Users) ResendVerify(w http.ResponseWriter, r *http.Request) {
+	var vd views.Data
+	user := context.User(r.Context())
+	vd.Yield = user
+	if err := u.sendVerify(user); err != nil {
+		vd.SetAlert(err)
+		u.VerifyView.Render(w, r, vd)
+		return
+	}
+	vd.Alert = &views.Alert{
+		Level: views.AlertLvlSuccess,
+		Message: "A new link is on its way to " + user.VerifyAddress() + ".",
+	}
+	u.VerifyView.Render(w, r, vd)
+}
+
+// sendVerify emails the user a link to verify their email address
+func (u *Users) sendVerify(user *models.User) error {
+	token, err := u.us.InitiateVerify(user.ID)
+	if err != nil {
+		return err
+	}
+	return u.emailer.Verify(user.Name, user.VerifyAddress(), token)
+}
+
+// resendVerify is sendVerify for a user that isn't signed in
+func (u *Users) resendVerify(email string) error {
+	user, err := u.us.ByEmail(email)
+	if err != nil {
+		return err
+	}
+	return u.sendVerify(user)
+}
+
+// sendReset emails a new password reset link to the user with the
+// provided address
+func (u *Users) sendReset(email string) error {
+	user, token, err := u.us.InitiateReset(email)
+	if err != nil {
+		return err
+	}
+	return u.emailer.ResetPw(user.Email, token)
+}
+
+// signIn starts a new session for the user on this device and
+// sets its token as the remember_token cookie
+func (u *Users) signIn(w http.ResponseWriter, r *http.Request, user *models.User) error {
+	session := models.Session{
+		UserID: user.ID,
+		UserAgent: r.UserAgent(),
+		IP: clientIP(r),
+	}
+	if err := u.ss.Create(&session); err != nil {
+		return err
 	}
 
 	cookie := http.Cookie{
 		Name: "remember_token",
-		Value: user.Remember,
+		Value: session.Token,
+		Path: "/",
+		Expires: session.ExpiresAt,
 		HttpOnly: true,
 	}
 	http.SetCookie(w, &cookie)
 	return nil
 }
 
-// COokieTest is used to display cookies set on the current user
-func (u *Users) CookieTest(w http.ResponseWriter, r *http.Request){
+// Logout ends the session on this device and clears the cookie
+//
+// POST /logout
+func (u *Users) Logout(w http.ResponseWriter, r *http.Request) {
 	cookie, err := r.Cookie("remember_token")
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	if err == nil {
+		if err := u.us.Logout(cookie.Value); err != nil {
+			httpError(w, err)
+			return
+		}
+	}
+	signOut(w)
+	http.Redirect(w, r, "/", http.StatusFound)
+}
+
+// LogoutAll ends every session the current user has so they
+// are signed out on all of their devices. Expects to run behind
+// middleware.RequireUser.
+//
+// POST /logout/all
+func (u *Users) LogoutAll(w http.ResponseWriter, r *http.Request) {
+	user := context.User(r.Context())
+	if err := u.us.LogoutAll(user.ID); err != nil {
+		httpError(w, err)
 		return
 	}
-	user, err := u.us.ByRemember(cookie.Value)
+	signOut(w)
+	http.Redirect(w, r, "/", http.StatusFound)
+}
+
+// signOut expires the remember_token cookie
+func signOut(w http.ResponseWriter) {
+	cookie := http.Cookie{
+		Name: "remember_token",
+		Value: "",
+		Path: "/",
+		Expires: time.Unix(0, 0),
+		MaxAge: -1,
+		HttpOnly: true,
+	}
+	http.SetCookie(w, &cookie)
+}
+
+// clientIP returns the IP address the request came from
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
+		return r.RemoteAddr
 	}
+	return host
+}
+
+// COokieTest is used to display the user signed in with the
+// current cookie. Expects to run behind middleware.RequireUser.
+func (u *Users) CookieTest(w http.ResponseWriter, r *http.Request){
+	user := context.User(r.Context())
 	fmt.Fprintln(w, user)
 }
\ No newline at end of file
@@ -0,0 +1,37 @@
+package controllers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"../models"
+)
+
+func TestInitiateReset(t *testing.T) {
+	services := testingServices(t)
+	emailer, mailer := testingEmailer()
+	u := NewUsers(services.User, services.Session, emailer)
+	user := models.User{Name: "Kelly", Email: "kelly@dundermifflin.com", Password: "business-bitch"}
+	createUsers(t, services.User, &user)
+	if _, err := services.User.UpdateProfile(user.ID, user.Name, "kelly@vance.com"); err != nil {
+		t.Fatal(err)
+	}
+
+	// The link goes to the stored address, whatever was typed in,
+	// and never to a pending one
+	for _, typed := range []string{" Kelly@DunderMifflin.com ", "kelly@vance.com"} {
+		w := httptest.NewRecorder()
+		u.InitiateReset(w, newRequest("POST", "/forgot", url.Values{"email": {typed}}, nil))
+		expectResponse(t, typed, w, http.StatusOK, "instructions to reset your password are on their way")
+	}
+	msgs := mailer.Messages()
+	if len(msgs) != 1 {
+		t.Fatalf("Expected 1 message. Received %+v", msgs)
+	}
+	if msgs[0].To != "kelly@dundermifflin.com" || !strings.Contains(msgs[0].Text, "/reset?token=") {
+		t.Errorf("Expected a reset link for the stored address. Received %+v", msgs[0])
+	}
+}
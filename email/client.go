@@ -0,0 +1,84 @@
+package email
+
+import (
+	"net/url"
+)
+
+// Client sends each of the emails DataBot knows about through the
+// provided Mailer. Links in emails start with BaseURL, it is never
+// taken from the request so a forged Host header can't end up in
+// a password reset link.
+type Client struct {
+	Mailer
+	From    string
+	BaseURL string
+
+	welcome *Template
+	resetPw *Template
+	verify  *Template
+	changed *Template
+}
+
+// NewClient parses every email template. Like NewTemplate this
+// panics if a template is broken.
+func NewClient(mailer Mailer, from, baseURL string) *Client {
+	return &Client{
+		Mailer:  mailer,
+		From:    from,
+		BaseURL: baseURL,
+		welcome: NewTemplate("welcome"),
+		resetPw: NewTemplate("reset_pw"),
+		verify:  NewTemplate("verify"),
+		changed: NewTemplate("email_changed"),
+	}
+}
+
+// Welcome is sent once a user has verified their email address
+func (c *Client) Welcome(toName, toEmail string) error {
+	data := struct {
+		Name    string
+		BaseURL string
+	}{toName, c.BaseURL}
+	return c.send(c.welcome, toEmail, data)
+}
+
+// ResetPw sends the link to reset a password with token
+func (c *Client) ResetPw(toEmail, token string) error {
+	v := url.Values{}
+	v.Set("token", token)
+	data := struct {
+		Token string
+		URL   string
+	}{token, c.BaseURL + "/reset?" + v.Encode()}
+	return c.send(c.resetPw, toEmail, data)
+}
+
+// Verify sends the link to verify an email address with token
+func (c *Client) Verify(toName, toEmail, token string) error {
+	v := url.Values{}
+	v.Set("token", token)
+	data := struct {
+		Name string
+		URL  string
+	}{toName, c.BaseURL + "/verify?" + v.Encode()}
+	return c.send(c.verify, toEmail, data)
+}
+
+// EmailChanged tells the old address that the account has moved to
+// newEmail, in case it wasn't the owner who moved it
+func (c *Client) EmailChanged(toName, oldEmail, newEmail string) error {
+	data := struct {
+		Name     string
+		NewEmail string
+		BaseURL  string
+	}{toName, newEmail, c.BaseURL}
+	return c.send(c.changed, oldEmail, data)
+}
+
+func (c *Client) send(t *Template, to string, data interface{}) error {
+	msg, err := t.Message(c.From, to, data)
+	if err != nil {
+		return err
+	}
+	return c.Send(msg)
+}
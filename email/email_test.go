@@ -0,0 +1,103 @@
+package email
+
+import (
+	"io/ioutil"
+	"mime"
+	"net/mail"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func init() {
+	TemplateDir = "../views/email/"
+}
+
+func TestMessageBytes(t *testing.T) {
+	msg := Message{
+		From:    "DataBot <support@databot.local>",
+		To:      "ryan@dundermifflin.com",
+		Subject: "Wüphf",
+		Text:    "plain body",
+		HTML:    "<p>html body</p>",
+	}
+	b, err := msg.Bytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsed, err := mail.ReadMessage(strings.NewReader(string(b)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	subject, err := new(mime.WordDecoder).DecodeHeader(parsed.Header.Get("Subject"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if subject != msg.Subject {
+		t.Errorf("Expected subject %q. Received %q", msg.Subject, subject)
+	}
+	if !strings.HasPrefix(parsed.Header.Get("Content-Type"), "multipart/alternative") {
+		t.Errorf("Expected multipart/alternative. Received %s", parsed.Header.Get("Content-Type"))
+	}
+	body, _ := ioutil.ReadAll(parsed.Body)
+	for _, want := range []string{"plain body", "<p>html body</p>"} {
+		if !strings.Contains(string(body), want) {
+			t.Errorf("Expected body to contain %q", want)
+		}
+	}
+}
+
+func TestFileMailer(t *testing.T) {
+	dir, err := ioutil.TempDir("", "databot-email")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	fm := &FileMailer{Dir: dir}
+	if err := fm.Send(Message{From: "a@b.com", To: "c@d.com", Subject: "hi", Text: "hello"}); err != nil {
+		t.Fatal(err)
+	}
+	files, err := filepath.Glob(filepath.Join(dir, "*.eml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 1 {
+		t.Fatalf("Expected 1 .eml file. Received %d", len(files))
+	}
+}
+
+func TestClientResetPw(t *testing.T) {
+	mm := &MemoryMailer{}
+	c := NewClient(mm, "support@databot.local", "https://databot.example")
+	if err := c.ResetPw("kelly@dundermifflin.com", "abc+/="); err != nil {
+		t.Fatal(err)
+	}
+	msgs := mm.Messages()
+	if len(msgs) != 1 {
+		t.Fatalf("Expected 1 message. Received %d", len(msgs))
+	}
+	link := "https://databot.example/reset?token=abc%2B%2F%3D"
+	if !strings.Contains(msgs[0].Text, link) {
+		t.Errorf("Expected the text body to contain %s. Received %s", link, msgs[0].Text)
+	}
+	if msgs[0].To != "kelly@dundermifflin.com" || msgs[0].Subject == "" {
+		t.Errorf("Unexpected message %+v", msgs[0])
+	}
+}
+
+func TestClientEmailChanged(t *testing.T) {
+	mm := &MemoryMailer{}
+	c := NewClient(mm, "support@databot.local", "https://databot.example")
+	if err := c.EmailChanged("Kelly", "kelly@dundermifflin.com", "kelly@vance.com"); err != nil {
+		t.Fatal(err)
+	}
+	msgs := mm.Messages()
+	if len(msgs) != 1 {
+		t.Fatalf("Expected 1 message. Received %d", len(msgs))
+	}
+	if msgs[0].To != "kelly@dundermifflin.com" || !strings.Contains(msgs[0].Text, "kelly@vance.com") {
+		t.Errorf("Unexpected message %+v", msgs[0])
+	}
+}
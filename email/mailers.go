@@ -0,0 +1,99 @@
+package email
+
+import (
+	"io/ioutil"
+	"log"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// SMTPMailer sends messages through an SMTP server. Username and
+// Password are optional, PLAIN auth is only used when they are set.
+type SMTPMailer struct {
+	Host     string
+	Port     int
+	Username string
+	Password string
+}
+
+var _ Mailer = &SMTPMailer{}
+
+func (sm *SMTPMailer) Send(msg Message) error {
+	b, err := msg.Bytes()
+	if err != nil {
+		return err
+	}
+	from, err := mail.ParseAddress(msg.From)
+	if err != nil {
+		return err
+	}
+	to, err := mail.ParseAddress(msg.To)
+	if err != nil {
+		return err
+	}
+	var auth smtp.Auth
+	if sm.Username != "" {
+		auth = smtp.PlainAuth("", sm.Username, sm.Password, sm.Host)
+	}
+	addr := net.JoinHostPort(sm.Host, strconv.Itoa(sm.Port))
+	return smtp.SendMail(addr, auth, from.Address, []string{to.Address}, b)
+}
+
+// FileMailer writes every message to Dir as an .eml file instead of
+// sending it. Any mail client can open them.
+type FileMailer struct {
+	Dir string
+}
+
+var _ Mailer = &FileMailer{}
+
+func (fm *FileMailer) Send(msg Message) error {
+	b, err := msg.Bytes()
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(fm.Dir, 0755); err != nil {
+		return err
+	}
+	f, err := ioutil.TempFile(fm.Dir, time.Now().Format("20060102-150405-*.eml"))
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(b); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	log.Println("email: wrote", f.Name())
+	return nil
+}
+
+// MemoryMailer keeps every message it is asked to send. It is
+// safe for concurrent use.
+type MemoryMailer struct {
+	mu       sync.Mutex
+	messages []Message
+}
+
+var _ Mailer = &MemoryMailer{}
+
+func (mm *MemoryMailer) Send(msg Message) error {
+	mm.mu.Lock()
+	defer mm.mu.Unlock()
+	mm.messages = append(mm.messages, msg)
+	return nil
+}
+
+// Messages returns a copy of everything sent so far
+func (mm *MemoryMailer) Messages() []Message {
+	mm.mu.Lock()
+	defer mm.mu.Unlock()
+	return append([]Message(nil), mm.messages...)
+}
@@ -0,0 +1,57 @@
+package email
+
+import (
+	"bytes"
+	"html/template"
+	"path/filepath"
+	"strings"
+	texttemplate "text/template"
+)
+
+var (
+	// TemplateDir is where the email templates live, the same way
+	// views.TemplateDir works for pages
+	TemplateDir string = "views/email/"
+	TemplateExt string = ".gohtml"
+)
+
+// Template renders one kind of email. Each template file defines
+// "subject", "text" and "html". The HTML part goes through
+// html/template so data is escaped, the subject and text parts
+// are plain text and go through text/template.
+type Template struct {
+	text *texttemplate.Template
+	html *template.Template
+}
+
+// NewTemplate parses TemplateDir + name + TemplateExt. This will
+// panic if the template can't be parsed and should only be used
+// during initial setup, like views.NewView.
+func NewTemplate(name string) *Template {
+	file := filepath.Join(TemplateDir, name+TemplateExt)
+	return &Template{
+		text: texttemplate.Must(texttemplate.ParseFiles(file)),
+		html: template.Must(template.ParseFiles(file)),
+	}
+}
+
+// Message renders the template with data into a message for to
+func (t *Template) Message(from, to string, data interface{}) (Message, error) {
+	var subject, text, html bytes.Buffer
+	if err := t.text.ExecuteTemplate(&subject, "subject", data); err != nil {
+		return Message{}, err
+	}
+	if err := t.text.ExecuteTemplate(&text, "text", data); err != nil {
+		return Message{}, err
+	}
+	if err := t.html.ExecuteTemplate(&html, "html", data); err != nil {
+		return Message{}, err
+	}
+	return Message{
+		From:    from,
+		To:      to,
+		Subject: strings.TrimSpace(subject.String()),
+		Text:    strings.TrimSpace(text.String()),
+		HTML:    strings.TrimSpace(html.String()),
+	}, nil
+}
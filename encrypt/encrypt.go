@@ -0,0 +1,69 @@
+// Package encrypt encrypts the secrets we have to be able to read
+// back, like TOTP secrets, before they are stored.
+package encrypt
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+
+	"../rand"
+)
+
+// ErrCiphertextInvalid is returned by Decrypt when the ciphertext
+// was changed or encrypted with a different key
+var ErrCiphertextInvalid = errors.New("encrypt: ciphertext is not valid")
+
+// NewAESGCM returns an AESGCM using the SHA-256 of key as its
+// AES-256 key, so key can be any length
+func NewAESGCM(key string) AESGCM {
+	sum := sha256.Sum256([]byte(key))
+	block, err := aes.NewCipher(sum[:])
+	if err != nil {
+		// Only happens for key sizes other than 16, 24 or 32 bytes
+		panic(err)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		panic(err)
+	}
+	return AESGCM{
+		aead: aead,
+	}
+}
+
+// AESGCM is a wrapper around crypto/cipher's AES-GCM that works
+// with strings, like hash.HMAC does
+type AESGCM struct {
+	aead cipher.AEAD
+}
+
+// Encrypt returns plaintext encrypted with a random nonce,
+// base64 URL encoded with the nonce in front
+func (a AESGCM) Encrypt(plaintext string) (string, error) {
+	nonce, err := rand.Bytes(a.aead.NonceSize())
+	if err != nil {
+		return "", err
+	}
+	b := a.aead.Seal(nonce, nonce, []byte(plaintext), nil)
+	return base64.URLEncoding.EncodeToString(b), nil
+}
+
+// Decrypt reverses Encrypt
+func (a AESGCM) Decrypt(ciphertext string) (string, error) {
+	b, err := base64.URLEncoding.DecodeString(ciphertext)
+	if err != nil {
+		return "", ErrCiphertextInvalid
+	}
+	n := a.aead.NonceSize()
+	if len(b) < n {
+		return "", ErrCiphertextInvalid
+	}
+	plaintext, err := a.aead.Open(nil, b[:n], b[n:], nil)
+	if err != nil {
+		return "", ErrCiphertextInvalid
+	}
+	return string(plaintext), nil
+}
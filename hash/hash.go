@@ -5,27 +5,59 @@ import (
 	"crypto/sha256"
 	"encoding/base64"
 	"hash"
+	"sync"
 )
 
 // New HMAC creates and returns a new HMAC object
 func NewHMAC(key string) HMAC {
-	h := hmac.New(sha256.New, []byte(key))
+	secret := []byte(key)
 	return HMAC {
-		hmac: h,
+		pool: &sync.Pool{
+			New: func() interface{} {
+				return hmac.New(sha256.New, secret)
+			},
+		},
 	}
 }
 
 // HMAC is a wrapper around the crypto/hmac
-// package. Making it a little easier to use
+// package. Making it a little easier to use.
+// It is safe for concurrent use: a hash.Hash can't
+// be shared between goroutines, so each call takes
+// one from a pool.
 type HMAC struct {
-	hmac hash.Hash
+	pool *sync.Pool
 }
 
 // Hash will hash the provided input string using
 // HMAC with the secret key provided when the HMAC object was created
 func (h HMAC) Hash(input string) string {
-	h.hmac.Reset()
-	h.hmac.Write([]byte(input))
-	b := h.hmac.Sum(nil)
+	b := h.Bytes([]byte(input))
 	return base64.URLEncoding.EncodeToString(b)
 }
+
+// Bytes is Hash without the base64 encoding
+func (h HMAC) Bytes(input []byte) []byte {
+	mac := h.pool.Get().(hash.Hash)
+	defer h.pool.Put(mac)
+	mac.Reset()
+	mac.Write(input)
+	return mac.Sum(nil)
+}
+
+// Verify reports whether hash is what Hash returns for input.
+// It takes the same time however much of hash is right, so use
+// it instead of == whenever hash came from a user.
+func (h HMAC) Verify(input, hash string) bool {
+	return Equal(h.Hash(input), hash)
+}
+
+// VerifyBytes is Verify for the raw MAC returned by Bytes
+func (h HMAC) VerifyBytes(input, mac []byte) bool {
+	return hmac.Equal(h.Bytes(input), mac)
+}
+
+// Equal compares two hashes in constant time
+func Equal(a, b string) bool {
+	return hmac.Equal([]byte(a), []byte(b))
+}
@@ -0,0 +1,55 @@
+package hash
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+)
+
+// TestHMACConcurrent shares one HMAC between goroutines the way the
+// models do between requests. Run with -race.
+func TestHMACConcurrent(t *testing.T) {
+	h := NewHMAC("secret-hmac-key")
+	inputs := make([]string, 50)
+	want := make([]string, len(inputs))
+	for i := range inputs {
+		inputs[i] = fmt.Sprintf("remember-token-%d", i)
+		want[i] = NewHMAC("secret-hmac-key").Hash(inputs[i])
+	}
+
+	var wg sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for n := 0; n < 100; n++ {
+				i := n % len(inputs)
+				if got := h.Hash(inputs[i]); got != want[i] {
+					t.Errorf("Hash(%q): Expected %s. Received %s", inputs[i], want[i], got)
+					return
+				}
+				if !h.Verify(inputs[i], want[i]) {
+					t.Errorf("Verify(%q): Expected true", inputs[i])
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func BenchmarkHMAC(b *testing.B) {
+	h := NewHMAC("secret-hmac-key")
+	for i := 0; i < b.N; i++ {
+		h.Hash("remember-token")
+	}
+}
+
+func BenchmarkHMACParallel(b *testing.B) {
+	h := NewHMAC("secret-hmac-key")
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			h.Hash("remember-token")
+		}
+	})
+}
@@ -0,0 +1,85 @@
+package hash
+
+import "strings"
+
+// Key is a secret with an ID. IDs can't contain ":", they are
+// stored in front of every hash the key makes so the right key can
+// be found again after it was rotated.
+type Key struct {
+	ID     string
+	Secret string
+}
+
+// NewKeyring returns a Keyring that hashes with current and can
+// still check hashes made with any of the previous keys
+func NewKeyring(current Key, previous ...Key) Keyring {
+	keys := append([]Key{current}, previous...)
+	hmacs := make([]HMAC, len(keys))
+	for i, key := range keys {
+		hmacs[i] = NewHMAC(key.Secret)
+	}
+	return Keyring{
+		keys:  keys,
+		hmacs: hmacs,
+	}
+}
+
+// Keyring is a set of HMAC keys, to allow rotating the key without
+// throwing away everything hashed with the old one. Hashes look like
+// "<id>:<hmac>", or just the HMAC for a key without an ID, which is
+// how hashes were made before keys had IDs.
+type Keyring struct {
+	keys  []Key
+	hmacs []HMAC
+}
+
+// Current returns the key new hashes are made with
+func (kr Keyring) Current() Key {
+	return kr.keys[0]
+}
+
+// Keys returns every key, the current key first
+func (kr Keyring) Keys() []Key {
+	return append([]Key(nil), kr.keys...)
+}
+
+// Hash hashes input with the current key
+func (kr Keyring) Hash(input string) string {
+	return kr.hash(0, input)
+}
+
+// Hashes returns input hashed with every key, the current key
+// first. It is for looking up values that are stored hashed.
+func (kr Keyring) Hashes(input string) []string {
+	hashes := make([]string, len(kr.keys))
+	for i := range kr.keys {
+		hashes[i] = kr.hash(i, input)
+	}
+	return hashes
+}
+
+// Verify reports whether hash is input hashed with one of the keys,
+// and if so whether that was an old key and hash should be replaced
+func (kr Keyring) Verify(input, hash string) (ok, stale bool) {
+	id := ""
+	if i := strings.Index(hash, ":"); i >= 0 {
+		id = hash[:i]
+	}
+	for i, key := range kr.keys {
+		if key.ID != id {
+			continue
+		}
+		if Equal(kr.hash(i, input), hash) {
+			return true, i > 0
+		}
+	}
+	return false, false
+}
+
+func (kr Keyring) hash(i int, input string) string {
+	h := kr.hmacs[i].Hash(input)
+	if id := kr.keys[i].ID; id != "" {
+		return id + ":" + h
+	}
+	return h
+}
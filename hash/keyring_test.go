@@ -0,0 +1,40 @@
+package hash
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestKeyring(t *testing.T) {
+	old := NewKeyring(Key{Secret: "secret-hmac-key"})
+	legacy := old.Hash("token")
+	if legacy != NewHMAC("secret-hmac-key").Hash("token") {
+		t.Errorf("Expected a key without an ID to hash like HMAC. Received %s", legacy)
+	}
+
+	kr := NewKeyring(Key{ID: "2", Secret: "new-key"}, Key{Secret: "secret-hmac-key"})
+	current := kr.Hash("token")
+	if !strings.HasPrefix(current, "2:") {
+		t.Errorf("Expected the key ID in front of the hash. Received %s", current)
+	}
+	hashes := kr.Hashes("token")
+	if len(hashes) != 2 || hashes[0] != current || hashes[1] != legacy {
+		t.Errorf("Expected the current hash and then the old one. Received %v", hashes)
+	}
+
+	cases := []struct {
+		hash      string
+		ok, stale bool
+	}{
+		{current, true, false},
+		{legacy, true, true},
+		{old.Hash("other"), false, false},
+		{"3:" + legacy, false, false},
+	}
+	for _, c := range cases {
+		ok, stale := kr.Verify("token", c.hash)
+		if ok != c.ok || stale != c.stale {
+			t.Errorf("Verify(%q): Expected %v, %v. Received %v, %v", c.hash, c.ok, c.stale, ok, stale)
+		}
+	}
+}
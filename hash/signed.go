@@ -0,0 +1,108 @@
+package hash
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+)
+
+var (
+	// ErrTokenInvalid is returned by Parse when a token is malformed,
+	// its signature doesn't match or it was signed for another purpose
+	ErrTokenInvalid = errors.New("hash: token is invalid")
+
+	// ErrTokenExpired is returned by Parse for a correctly signed
+	// token that is past its expiry
+	ErrTokenExpired = errors.New("hash: token has expired")
+)
+
+// signedPayload is what a signed token carries
+type signedPayload struct {
+	Expires int64           `json:"exp"`
+	Data    json.RawMessage `json:"data"`
+}
+
+// Sign returns a URL-safe token carrying v, encoded as JSON, that
+// expires after ttl. purpose is signed along with it, so a token
+// issued for one purpose is rejected by Parse for any other. Tokens
+// are signed, not encrypted: anyone holding one can read v.
+func (h HMAC) Sign(purpose string, v interface{}, ttl time.Duration) (string, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+	payload, err := json.Marshal(signedPayload{
+		Expires: time.Now().Add(ttl).Unix(),
+		Data:    data,
+	})
+	if err != nil {
+		return "", err
+	}
+	encoded := base64.RawURLEncoding.EncodeToString(payload)
+	mac := h.Bytes(signingInput(purpose, encoded))
+	return encoded + "." + base64.RawURLEncoding.EncodeToString(mac), nil
+}
+
+// Parse checks a token made by Sign for purpose and decodes what it
+// carries into v
+func (h HMAC) Parse(purpose, token string, v interface{}) error {
+	i := strings.LastIndex(token, ".")
+	if i < 0 {
+		return ErrTokenInvalid
+	}
+	encoded := token[:i]
+	mac, err := base64.RawURLEncoding.DecodeString(token[i+1:])
+	if err != nil || !h.VerifyBytes(signingInput(purpose, encoded), mac) {
+		return ErrTokenInvalid
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return ErrTokenInvalid
+	}
+	var sp signedPayload
+	if err := json.Unmarshal(payload, &sp); err != nil {
+		return ErrTokenInvalid
+	}
+	if !time.Now().Before(time.Unix(sp.Expires, 0)) {
+		return ErrTokenExpired
+	}
+	if err := json.Unmarshal(sp.Data, v); err != nil {
+		return ErrTokenInvalid
+	}
+	return nil
+}
+
+func signingInput(purpose, encoded string) []byte {
+	return []byte(purpose + "." + encoded)
+}
+
+// Sign is HMAC.Sign with the current key. If the key has an ID the
+// token starts with "<id>." so Parse knows which key to check it with.
+func (kr Keyring) Sign(purpose string, v interface{}, ttl time.Duration) (string, error) {
+	token, err := kr.hmacs[0].Sign(purpose, v, ttl)
+	if err != nil || kr.keys[0].ID == "" {
+		return token, err
+	}
+	return kr.keys[0].ID + "." + token, nil
+}
+
+// Parse is HMAC.Parse with whichever key signed the token, so tokens
+// signed before a key rotation keep working until they expire or the
+// old key is dropped
+func (kr Keyring) Parse(purpose, token string, v interface{}) error {
+	// The payload and signature never contain a ".", so anything
+	// before them is the key ID
+	id := ""
+	if parts := strings.Split(token, "."); len(parts) > 2 {
+		id = strings.Join(parts[:len(parts)-2], ".")
+		token = strings.Join(parts[len(parts)-2:], ".")
+	}
+	for i, key := range kr.keys {
+		if key.ID == id {
+			return kr.hmacs[i].Parse(purpose, token, v)
+		}
+	}
+	return ErrTokenInvalid
+}
@@ -0,0 +1,76 @@
+package hash
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+type testClaims struct {
+	UserID uint   `json:"uid"`
+	Email  string `json:"email"`
+}
+
+func TestSign(t *testing.T) {
+	h := NewHMAC("secret-hmac-key")
+	claims := testClaims{UserID: 7, Email: "jim@dundermifflin.com"}
+	token, err := h.Sign("verify", claims, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.ContainsAny(token, "+/=") {
+		t.Errorf("Expected a URL-safe token. Received %s", token)
+	}
+
+	var got testClaims
+	if err := h.Parse("verify", token, &got); err != nil {
+		t.Fatal(err)
+	}
+	if got != claims {
+		t.Errorf("Expected %+v. Received %+v", claims, got)
+	}
+
+	cases := map[string]struct {
+		h       HMAC
+		purpose string
+		token   string
+		err     error
+	}{
+		"other purpose": {h, "reset", token, ErrTokenInvalid},
+		"other key":     {NewHMAC("other-key"), "verify", token, ErrTokenInvalid},
+		"tampered":      {h, "verify", "x" + token, ErrTokenInvalid},
+		"no signature":  {h, "verify", strings.Split(token, ".")[0], ErrTokenInvalid},
+		"empty":         {h, "verify", "", ErrTokenInvalid},
+	}
+	for name, c := range cases {
+		if err := c.h.Parse(c.purpose, c.token, &got); err != c.err {
+			t.Errorf("%s: Expected %v. Received %v", name, c.err, err)
+		}
+	}
+
+	expired, _ := h.Sign("verify", claims, -time.Second)
+	if err := h.Parse("verify", expired, &got); err != ErrTokenExpired {
+		t.Errorf("Expected ErrTokenExpired. Received %v", err)
+	}
+}
+
+func TestKeyringSign(t *testing.T) {
+	old := NewKeyring(Key{Secret: "secret-hmac-key"})
+	kr := NewKeyring(Key{ID: "2", Secret: "new-key"}, Key{Secret: "secret-hmac-key"})
+
+	oldToken, _ := old.Sign("verify", "pam", time.Hour)
+	newToken, _ := kr.Sign("verify", "pam", time.Hour)
+	if !strings.HasPrefix(newToken, "2.") {
+		t.Errorf("Expected the key ID in front of the token. Received %s", newToken)
+	}
+	for _, token := range []string{oldToken, newToken} {
+		var s string
+		if err := kr.Parse("verify", token, &s); err != nil || s != "pam" {
+			t.Errorf("Expected %s to parse. Received %q, %v", token, s, err)
+		}
+	}
+	var s string
+	if err := old.Parse("verify", newToken, &s); err != ErrTokenInvalid {
+		t.Errorf("Expected ErrTokenInvalid for an unknown key ID. Received %v", err)
+	}
+}
@@ -7,46 +7,208 @@ package main
 
 // gorilla/mux
 import (
+	"./config"
 	"./controllers"
+	"./email"
+	"./hash"
+	"./middleware"
 	"./models"
+	"./oidc"
+	"flag"
 	"fmt"
+	"log"
 	"net/http"
+	"time"
 
+	"github.com/gorilla/csrf"
 	"github.com/gorilla/mux"
 )
 
-// Will remove the passwords later
-const (
-	host     = "localhost"
-	port     = 5432
-	user     = "postgres"
-	password = "alexnoah"
-	dbname   = "databot_dev"
-)
-
 func main() {
-	// Connect to UserService Model
-	psqlInfo := fmt.Sprintf("host=%s port=%d user=%s password=%s dbname=%s sslmode=disable",
-		host, port, user, password, dbname)
+	configPath := flag.String("config", "", "path to a .json or .toml config file")
+	makeAdmin := flag.String("make-admin", "", "give the user with this email address the admin role and exit")
+	flag.Parse()
+	cfg, err := config.Load(*configPath)
+	must(err)
 
-	us, err := models.NewUserService(psqlInfo)
+	// Connect to the database and build the model services
+	dbCfg := cfg.Database
+	services, err := models.NewServices(
+		models.WithGorm(dbCfg.Dialect, dbCfg.ConnectionInfo()),
+		models.WithLogMode(!cfg.IsProd()),
+		models.WithPeppers(hash.Key{ID: cfg.PepperID, Secret: cfg.Pepper}, hashKeys(cfg.OldPeppers)...),
+		models.WithHMACKeys(hash.Key{ID: cfg.HMACKeyID, Secret: cfg.HMACKey}, hashKeys(cfg.OldHMACKeys)...),
+		models.WithPasswordHasher(newPasswordHasher(cfg.Password)),
+		models.WithEncryptionKey(cfg.EncryptionKey),
+		models.WithRequireVerified(cfg.RequireVerifiedEmail),
+	)
 	must(err)
-	defer us.Close()
-	//us.DestructiveReset()
-	us.AutoMigrate()
+	defer services.Close()
+	//services.DestructiveReset()
+	must(services.AutoMigrate())
+
+	// The first admin has to come from somewhere, after that admins
+	// can manage roles themselves
+	if *makeAdmin != "" {
+		user, err := services.User.ByEmail(*makeAdmin)
+		must(err)
+		must(services.User.SetRole(user.ID, models.RoleAdmin))
+		fmt.Println(user.Email, "is now an admin")
+		return
+	}
+
+	emailer := email.NewClient(newMailer(cfg.Email), cfg.Email.From, cfg.BaseURL)
+	go purgeDeleted(services.User, time.Duration(cfg.DeletionGraceDays)*24*time.Hour)
+	http.ListenAndServe(cfg.Addr(), newRouter(cfg, services, emailer))
+}
 
+// newRouter builds the controllers and middleware and registers
+// every route on a new router
+func newRouter(cfg config.Config, services *models.Services, emailer *email.Client) *mux.Router {
 	staticC := controllers.NewStatic()
-	usersC := controllers.NewUsers(us)
+	usersC := controllers.NewUsers(services.User, services.Session, emailer)
+	apiKeysC := controllers.NewAPIKeys(services.APIKey)
+	adminC := controllers.NewAdmin(services.User, services.Session, emailer)
+	if cfg.OIDC.Enabled() {
+		usersC.OIDC = oidc.NewClient(oidc.Config{
+			Issuer: cfg.OIDC.Issuer,
+			ClientID: cfg.OIDC.ClientID,
+			ClientSecret: cfg.OIDC.ClientSecret,
+			RedirectURL: cfg.BaseURL + "/login/oidc/callback",
+		})
+		usersC.OIDCName = cfg.OIDC.Name
+		if usersC.OIDCName == "" {
+			usersC.OIDCName = "single sign-on"
+		}
+	}
+	usersC.DeletionGraceDays = cfg.DeletionGraceDays
+
+	userMw := middleware.User{
+		UserService: services.User,
+	}
+	apiKeyMw := middleware.APIKey{
+		Keys: services.APIKey,
+		Users: services.User,
+	}
+	requireUserMw := middleware.RequireUser{}
+	requirePermMw := middleware.RequirePermission{
+		UserService: services.User,
+		Forbidden: http.HandlerFunc(staticC.PermissionDenied),
+	}
+	canRead := func(h http.HandlerFunc) http.HandlerFunc {
+		return requirePermMw.ApplyFn(models.PermUsersRead, h)
+	}
+	canWrite := func(h http.HandlerFunc) http.HandlerFunc {
+		return requirePermMw.ApplyFn(models.PermUsersWrite, h)
+	}
+
+	// Every POST needs the token from {{csrfField}}, otherwise the
+	// forbidden page is rendered instead of calling the handler
+	csrfMw := csrf.Protect(
+		[]byte(cfg.CSRFKey),
+		csrf.Secure(cfg.IsProd()),
+		csrf.Path("/"),
+		csrf.ErrorHandler(http.HandlerFunc(staticC.CSRFFailure)),
+	)
 
 	r := mux.NewRouter()
+	// Scripts send an API key instead of the session cookie, only
+	// on the routes wrapped in apiKeyMw.Allow. This runs first so
+	// requests with a valid key skip the CSRF check.
+	r.Use(apiKeyMw.Apply)
+	r.Use(csrfMw)
+	// Every request gets the signed in user, if any, in its context
+	r.Use(userMw.Apply)
 	r.Handle("/", staticC.HomeView).Methods("GET")
 	r.Handle("/contact", staticC.ContactView).Methods("GET")
 	r.Handle("/signup", usersC.NewView).Methods("GET")
 	r.HandleFunc("/signup", usersC.Create).Methods("POST")
-	r.Handle("/login", usersC.LoginView).Methods("GET")
+	r.HandleFunc("/login", usersC.LoginPage).Methods("GET")
 	r.HandleFunc("/login", usersC.Login).Methods("POST")
-	r.HandleFunc("/cookietest", usersC.CookieTest).Methods("GET")
-	http.ListenAndServe(":3000", r)
+	r.Handle("/forgot", usersC.ForgotPwView).Methods("GET")
+	r.HandleFunc("/forgot", usersC.InitiateReset).Methods("POST")
+	r.HandleFunc("/reset", usersC.ResetPw).Methods("GET")
+	r.HandleFunc("/reset", usersC.CompleteReset).Methods("POST")
+	r.HandleFunc("/verify", usersC.Verify).Methods("GET")
+	r.HandleFunc("/verify", requireUserMw.ApplyFn(usersC.ResendVerify)).Methods("POST")
+	r.Handle("/login/2fa", usersC.LoginTOTPView).Methods("GET")
+	r.HandleFunc("/login/2fa", usersC.LoginTOTP).Methods("POST")
+	r.HandleFunc("/login/oidc", usersC.OIDCLogin).Methods("GET")
+	r.HandleFunc("/login/oidc/callback", usersC.OIDCCallback).Methods("GET")
+	r.HandleFunc("/account", requireUserMw.ApplyFn(usersC.Account)).Methods("GET")
+	r.HandleFunc("/account", requireUserMw.ApplyFn(usersC.UpdateAccount)).Methods("POST")
+	r.HandleFunc("/account/password", requireUserMw.ApplyFn(usersC.ChangePassword)).Methods("POST")
+	r.HandleFunc("/account/export", requireUserMw.ApplyFn(usersC.Export)).Methods("GET")
+	r.HandleFunc("/account/delete", requireUserMw.ApplyFn(usersC.DeleteAccount)).Methods("POST")
+	r.HandleFunc("/2fa", requireUserMw.ApplyFn(usersC.TwoFactor)).Methods("GET")
+	r.HandleFunc("/2fa/setup", requireUserMw.ApplyFn(usersC.SetupTOTP)).Methods("POST")
+	r.HandleFunc("/2fa/enable", requireUserMw.ApplyFn(usersC.EnableTOTP)).Methods("POST")
+	r.HandleFunc("/2fa/disable", requireUserMw.ApplyFn(usersC.DisableTOTP)).Methods("POST")
+	r.HandleFunc("/api-keys", requireUserMw.ApplyFn(apiKeysC.Index)).Methods("GET")
+	r.HandleFunc("/api-keys", requireUserMw.ApplyFn(apiKeysC.Create)).Methods("POST")
+	r.HandleFunc("/api-keys/{id:[0-9]+}/revoke", requireUserMw.ApplyFn(apiKeysC.Revoke)).Methods("POST")
+	r.HandleFunc("/admin/users", canRead(adminC.Users)).Methods("GET")
+	r.HandleFunc("/admin/users/{id:[0-9]+}", canRead(adminC.Show)).Methods("GET")
+	r.HandleFunc("/admin/users/{id:[0-9]+}/edit", canRead(adminC.Edit)).Methods("GET")
+	r.HandleFunc("/admin/users/{id:[0-9]+}", canWrite(adminC.Update)).Methods("POST")
+	r.HandleFunc("/admin/users/{id:[0-9]+}/disable", canWrite(adminC.Disable)).Methods("POST")
+	r.HandleFunc("/admin/users/{id:[0-9]+}/enable", canWrite(adminC.Enable)).Methods("POST")
+	r.HandleFunc("/admin/users/{id:[0-9]+}/reset-password", canWrite(adminC.ResetPassword)).Methods("POST")
+	r.HandleFunc("/admin/users/{id:[0-9]+}/sessions/revoke", canWrite(adminC.RevokeSessions)).Methods("POST")
+	r.HandleFunc("/admin/users/{id:[0-9]+}/sessions/{sid:[0-9]+}/revoke", canWrite(adminC.RevokeSession)).Methods("POST")
+	r.HandleFunc("/admin/users/{id:[0-9]+}/delete", requirePermMw.ApplyFn(models.PermUsersDelete, adminC.Delete)).Methods("POST")
+	r.HandleFunc("/logout", usersC.Logout).Methods("POST")
+	r.HandleFunc("/logout/all", requireUserMw.ApplyFn(usersC.LogoutAll)).Methods("POST")
+	apiKeyMw.Allow(r.HandleFunc("/cookietest", requireUserMw.ApplyFn(usersC.CookieTest)).Methods("GET"))
+	return r
+}
+
+// purgeDeleted removes accounts for good once they have been
+// deleted for longer than grace, checking every hour
+func purgeDeleted(us models.UserService, grace time.Duration) {
+	for {
+		n, err := us.PurgeDeleted(time.Now().Add(-grace))
+		if err != nil {
+			log.Println("purging deleted accounts:", err)
+		} else if n > 0 {
+			log.Printf("purged %d deleted accounts", n)
+		}
+		time.Sleep(time.Hour)
+	}
+}
+
+// newMailer returns the email backend picked in the config
+func newMailer(cfg config.EmailConfig) email.Mailer {
+	if cfg.Backend == config.MailerSMTP {
+		return &email.SMTPMailer{
+			Host: cfg.SMTPHost,
+			Port: cfg.SMTPPort,
+			Username: cfg.SMTPUser,
+			Password: cfg.SMTPPassword,
+		}
+	}
+	return &email.FileMailer{
+		Dir: cfg.Dir,
+	}
+}
+
+// hashKeys converts the old keys from the config for the models
+func hashKeys(keys []config.Key) []hash.Key {
+	ret := make([]hash.Key, len(keys))
+	for i, key := range keys {
+		ret[i] = hash.Key{ID: key.ID, Secret: key.Secret}
+	}
+	return ret
+}
+
+// newPasswordHasher returns the password hasher picked in the config
+func newPasswordHasher(cfg config.PasswordConfig) models.PasswordHasher {
+	if cfg.Algorithm == config.HasherArgon2id {
+		return models.NewArgon2idHasher(uint32(cfg.Argon2Time), uint32(cfg.Argon2Memory), uint8(cfg.Argon2Threads))
+	}
+	hasher, err := models.NewBcryptHasher(cfg.BcryptCost)
+	must(err)
+	return hasher
 }
 
 func must(err error) {
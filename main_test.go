@@ -0,0 +1,68 @@
+package main
+
+import (
+	"./config"
+	"./email"
+	"./models"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+)
+
+// TestAPIKeyRoutes sends a write key to every route. Only the ones
+// wrapped in apiKeyMw.Allow may accept it, the rest have to refuse
+// it before their handler runs.
+func TestAPIKeyRoutes(t *testing.T) {
+	services, err := models.NewServices(
+		models.WithMemory(),
+		models.WithUser("test-pepper", "test-hmac-key"),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	user := models.User{Email: "david@dundermifflin.com", Password: "suck-it-sucker", Role: models.RoleAdmin}
+	if err := services.User.Create(&user); err != nil {
+		t.Fatal(err)
+	}
+	key := models.APIKey{UserID: user.ID, Name: "script", Scopes: models.ScopeWrite}
+	if err := services.APIKey.Create(&key); err != nil {
+		t.Fatal(err)
+	}
+	cfg := config.Default()
+	emailer := email.NewClient(&email.MemoryMailer{}, cfg.Email.From, cfg.BaseURL)
+	router := newRouter(cfg, services, emailer)
+
+	allowed := map[string]bool{
+		"GET /cookietest": true,
+	}
+	vars := strings.NewReplacer("{id:[0-9]+}", fmt.Sprint(user.ID), "{sid:[0-9]+}", "1")
+	err = router.Walk(func(route *mux.Route, _ *mux.Router, _ []*mux.Route) error {
+		tpl, err := route.GetPathTemplate()
+		if err != nil {
+			return err
+		}
+		methods, err := route.GetMethods()
+		if err != nil {
+			return err
+		}
+		for _, method := range methods {
+			r := httptest.NewRequest(method, vars.Replace(tpl), nil)
+			r.Header.Set("Authorization", "Bearer "+key.Key)
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, r)
+			refused := w.Code == http.StatusForbidden && strings.Contains(w.Body.String(), "API keys can't be used here")
+			name := method + " " + tpl
+			if refused == allowed[name] {
+				t.Errorf("%s: Expected the key to be allowed %v. Received %d %q", name, allowed[name], w.Code, w.Body.String())
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,114 @@
+package middleware
+
+import (
+	"log"
+	"net/http"
+	"strings"
+
+	"../context"
+	"../models"
+	"github.com/gorilla/csrf"
+	"github.com/gorilla/mux"
+)
+
+// APIKey authenticates requests that send an API key in an
+// "Authorization: Bearer" header. The key's owner is stored in the
+// request context just like User does for the session cookie, so
+// handlers work the same either way, and the key itself is stored
+// too. A bad key is rejected with a 401 rather than carrying on
+// without a user, and a key without ScopeWrite can only make GET
+// and HEAD requests.
+//
+// Keys only work on routes passed to Allow, every other route
+// refuses them with a 403, so a new page is safe from a leaked key
+// until someone decides otherwise. Apply has to be passed to
+// Router.Use so the matched route is known when it runs.
+//
+// APIKey has to run before the CSRF middleware. A request with a
+// valid key can't come from a form on another site, browsers don't
+// add the header themselves, so the CSRF check is skipped for it.
+type APIKey struct {
+	Keys  models.APIKeyService
+	Users models.UserService
+
+	allowed map[*mux.Route]bool
+}
+
+// Allow lets API keys be used on route and returns it, so it can
+// wrap a route where it is registered.
+func (mw *APIKey) Allow(route *mux.Route) *mux.Route {
+	if mw.allowed == nil {
+		mw.allowed = make(map[*mux.Route]bool)
+	}
+	mw.allowed[route] = true
+	return route
+}
+
+// Apply is a mux.MiddlewareFunc so it can be passed to Router.Use
+func (mw *APIKey) Apply(next http.Handler) http.Handler {
+	return mw.ApplyFn(next.ServeHTTP)
+}
+
+func (mw *APIKey) ApplyFn(next http.HandlerFunc) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token, ok := bearerToken(r)
+		if !ok {
+			next(w, r)
+			return
+		}
+		if !mw.allowed[mux.CurrentRoute(r)] {
+			authError(w, `Bearer error="insufficient_scope"`,
+				"API keys can't be used here, this can only be done from the website.", http.StatusForbidden)
+			return
+		}
+		key, err := mw.Keys.ByKey(token)
+		if err != nil {
+			if err != models.ErrNotFound {
+				log.Println(err)
+			}
+			authError(w, `Bearer error="invalid_token"`, "Invalid API key.", http.StatusUnauthorized)
+			return
+		}
+		if !key.HasScope(requiredScope(r)) {
+			authError(w, `Bearer error="insufficient_scope", scope="`+models.ScopeWrite+`"`,
+				"This API key can't make changes.", http.StatusForbidden)
+			return
+		}
+		user, err := mw.Users.ByID(key.UserID)
+		if err != nil || user.Disabled() {
+			authError(w, `Bearer error="invalid_token"`, "Invalid API key.", http.StatusUnauthorized)
+			return
+		}
+		if err := mw.Keys.Touch(key); err != nil {
+			log.Println(err)
+		}
+		ctx := context.WithUser(r.Context(), user)
+		ctx = context.WithAPIKey(ctx, key)
+		next(w, csrf.UnsafeSkipCheck(r.WithContext(ctx)))
+	})
+}
+
+// bearerToken returns the token from an "Authorization: Bearer"
+// header, if the request has one
+func bearerToken(r *http.Request) (string, bool) {
+	header := r.Header.Get("Authorization")
+	const prefix = "Bearer "
+	if len(header) < len(prefix) || !strings.EqualFold(header[:len(prefix)], prefix) {
+		return "", false
+	}
+	return strings.TrimSpace(header[len(prefix):]), true
+}
+
+// requiredScope is the scope a key needs for the request's method
+func requiredScope(r *http.Request) string {
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		return models.ScopeRead
+	}
+	return models.ScopeWrite
+}
+
+func authError(w http.ResponseWriter, challenge, msg string, status int) {
+	w.Header().Set("WWW-Authenticate", challenge)
+	http.Error(w, msg, status)
+}
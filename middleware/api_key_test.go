@@ -0,0 +1,78 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"../context"
+	"../models"
+	"github.com/gorilla/mux"
+)
+
+func TestAPIKey(t *testing.T) {
+	services, err := models.NewServices(
+		models.WithMemory(),
+		models.WithUser("test-pepper", "test-hmac-key"),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	user := models.User{Email: "darryl@dundermifflin.com", Password: "philyourself"}
+	if err := services.User.Create(&user); err != nil {
+		t.Fatal(err)
+	}
+	readKey := models.APIKey{UserID: user.ID, Name: "read", Scopes: models.ScopeRead}
+	writeKey := models.APIKey{UserID: user.ID, Name: "write", Scopes: models.ScopeWrite}
+	for _, key := range []*models.APIKey{&readKey, &writeKey} {
+		if err := services.APIKey.Create(key); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	apiKeyMw := APIKey{Keys: services.APIKey, Users: services.User}
+	userMw := User{UserService: services.User}
+	var seen *models.User
+	handler := userMw.ApplyFn(func(w http.ResponseWriter, r *http.Request) {
+		seen = context.User(r.Context())
+	})
+	router := mux.NewRouter()
+	router.Use(apiKeyMw.Apply)
+	apiKeyMw.Allow(router.HandleFunc("/cookietest", handler))
+	router.HandleFunc("/account", handler)
+
+	tests := map[string]struct {
+		method string
+		path   string
+		header string
+		status int
+		user   bool
+	}{
+		"no header":           {"GET", "/cookietest", "", http.StatusOK, false},
+		"basic auth":          {"GET", "/cookietest", "Basic dXNlcjpwYXNz", http.StatusOK, false},
+		"bad key":             {"GET", "/cookietest", "Bearer dbk_notarealkey", http.StatusUnauthorized, false},
+		"read key GET":        {"GET", "/cookietest", "Bearer " + readKey.Key, http.StatusOK, true},
+		"read key POST":       {"POST", "/cookietest", "Bearer " + readKey.Key, http.StatusForbidden, false},
+		"write key POST":      {"POST", "/cookietest", "bearer " + writeKey.Key, http.StatusOK, true},
+		"not allowed":         {"GET", "/account", "Bearer " + writeKey.Key, http.StatusForbidden, false},
+		"not allowed, no key": {"GET", "/account", "", http.StatusOK, false},
+	}
+	for name, tc := range tests {
+		seen = nil
+		r := httptest.NewRequest(tc.method, tc.path, nil)
+		if tc.header != "" {
+			r.Header.Set("Authorization", tc.header)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, r)
+		if w.Code != tc.status {
+			t.Errorf("%s: Expected status %d. Received %d", name, tc.status, w.Code)
+		}
+		if tc.status != http.StatusOK && w.Header().Get("WWW-Authenticate") == "" {
+			t.Errorf("%s: Expected a WWW-Authenticate header", name)
+		}
+		if got := seen != nil && seen.ID == user.ID; got != tc.user {
+			t.Errorf("%s: Expected the user in the context to be %v. Received %v", name, tc.user, seen)
+		}
+	}
+}
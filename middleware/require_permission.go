@@ -0,0 +1,60 @@
+package middleware
+
+import (
+	"log"
+	"net/http"
+
+	"../context"
+	"../models"
+)
+
+// RequirePermission only lets users through whose role or grants
+// include a permission. Like RequireUser it redirects to /login
+// when nobody is signed in, so User has to run first. Signed in
+// users without the permission get Forbidden, or a plain 403 if it
+// is nil.
+//
+//	r.Use(requirePermMw.Apply(models.PermUsersRead))
+//	r.HandleFunc("/admin/users/{id}/delete",
+//		requirePermMw.ApplyFn(models.PermUsersDelete, adminC.Delete))
+type RequirePermission struct {
+	models.UserService
+	Forbidden http.Handler
+}
+
+// Apply returns a mux.MiddlewareFunc that requires perm, so it can
+// be passed to Router.Use
+func (mw *RequirePermission) Apply(perm string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return mw.ApplyFn(perm, next.ServeHTTP)
+	}
+}
+
+func (mw *RequirePermission) ApplyFn(perm string, next http.HandlerFunc) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user := context.User(r.Context())
+		if user == nil {
+			http.Redirect(w, r, "/login", http.StatusFound)
+			return
+		}
+		ok, err := mw.UserService.HasPermission(user, perm)
+		if err != nil {
+			log.Println(err)
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+		if !ok {
+			mw.forbidden(w, r)
+			return
+		}
+		next(w, r)
+	})
+}
+
+func (mw *RequirePermission) forbidden(w http.ResponseWriter, r *http.Request) {
+	if mw.Forbidden == nil {
+		http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+		return
+	}
+	mw.Forbidden.ServeHTTP(w, r)
+}
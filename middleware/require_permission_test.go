@@ -0,0 +1,60 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"../context"
+	"../models"
+)
+
+func TestRequirePermission(t *testing.T) {
+	services, err := models.NewServices(
+		models.WithMemory(),
+		models.WithUser("test-pepper", "test-hmac-key"),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	member := models.User{Email: "kevin@dundermifflin.com", Password: "famous-chili"}
+	admin := models.User{Email: "michael@dundermifflin.com", Password: "thats-what-she-said", Role: models.RoleAdmin}
+	granted := models.User{Email: "toby@dundermifflin.com", Password: "scranton-strangler"}
+	for _, u := range []*models.User{&member, &admin, &granted} {
+		if err := services.User.Create(u); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := services.User.GrantPermission(granted.ID, models.PermUsersDelete); err != nil {
+		t.Fatal(err)
+	}
+
+	mw := RequirePermission{
+		UserService: services.User,
+		Forbidden: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusTeapot)
+		}),
+	}
+	handler := mw.Apply(models.PermUsersDelete)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	tests := map[string]struct {
+		user   *models.User
+		status int
+	}{
+		"signed out": {nil, http.StatusFound},
+		"member":     {&member, http.StatusTeapot},
+		"admin":      {&admin, http.StatusOK},
+		"granted":    {&granted, http.StatusOK},
+	}
+	for name, tc := range tests {
+		r := httptest.NewRequest("POST", "/admin/users/1/delete", nil)
+		if tc.user != nil {
+			r = r.WithContext(context.WithUser(r.Context(), tc.user))
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		if w.Code != tc.status {
+			t.Errorf("%s: Expected status %d. Received %d", name, tc.status, w.Code)
+		}
+	}
+}
@@ -0,0 +1,62 @@
+// Package middleware holds the http.Handler wrappers used by the router.
+package middleware
+
+import (
+	"net/http"
+
+	"../context"
+	"../models"
+)
+
+// User looks up the user for the remember_token cookie once per
+// request and stores it in the request context. It never blocks a
+// request, pages that need a user should also use RequireUser.
+// Requests APIKey already found a user for are left alone.
+type User struct {
+	models.UserService
+}
+
+// Apply is a mux.MiddlewareFunc so it can be passed to Router.Use
+func (mw *User) Apply(next http.Handler) http.Handler {
+	return mw.ApplyFn(next.ServeHTTP)
+}
+
+func (mw *User) ApplyFn(next http.HandlerFunc) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// APIKey already found the user for this request
+		if context.User(r.Context()) != nil {
+			next(w, r)
+			return
+		}
+		cookie, err := r.Cookie("remember_token")
+		if err != nil {
+			next(w, r)
+			return
+		}
+		user, err := mw.UserService.ByRemember(cookie.Value)
+		if err != nil {
+			next(w, r)
+			return
+		}
+		ctx := context.WithUser(r.Context(), user)
+		next(w, r.WithContext(ctx))
+	})
+}
+
+// RequireUser redirects to /login unless User found a signed in
+// user for the request. User has to run first.
+type RequireUser struct{}
+
+func (mw *RequireUser) Apply(next http.Handler) http.Handler {
+	return mw.ApplyFn(next.ServeHTTP)
+}
+
+func (mw *RequireUser) ApplyFn(next http.HandlerFunc) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if context.User(r.Context()) == nil {
+			http.Redirect(w, r, "/login", http.StatusFound)
+			return
+		}
+		next(w, r)
+	})
+}
@@ -0,0 +1,63 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"../context"
+	"../models"
+)
+
+func TestRequireUser(t *testing.T) {
+	services, err := models.NewServices(
+		models.WithMemory(),
+		models.WithUser("test-pepper", "test-hmac-key"),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	user := models.User{Email: "angela@dundermifflin.com", Password: "sprinkles"}
+	if err := services.User.Create(&user); err != nil {
+		t.Fatal(err)
+	}
+	session := models.Session{UserID: user.ID}
+	if err := services.Session.Create(&session); err != nil {
+		t.Fatal(err)
+	}
+
+	userMw := User{UserService: services.User}
+	requireUserMw := RequireUser{}
+	var seen *models.User
+	handler := userMw.Apply(requireUserMw.ApplyFn(func(w http.ResponseWriter, r *http.Request) {
+		seen = context.User(r.Context())
+	}))
+
+	tests := map[string]struct {
+		cookie   string
+		status   int
+		location string
+	}{
+		"no cookie":     {"", http.StatusFound, "/login"},
+		"bad cookie":    {"not-a-session", http.StatusFound, "/login"},
+		"valid session": {session.Token, http.StatusOK, ""},
+	}
+	for name, tc := range tests {
+		seen = nil
+		r := httptest.NewRequest("GET", "/cookietest", nil)
+		if tc.cookie != "" {
+			r.AddCookie(&http.Cookie{Name: "remember_token", Value: tc.cookie})
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		if w.Code != tc.status {
+			t.Errorf("%s: Expected status %d. Received %d", name, tc.status, w.Code)
+		}
+		if loc := w.Header().Get("Location"); loc != tc.location {
+			t.Errorf("%s: Expected Location %q. Received %q", name, tc.location, loc)
+		}
+		if tc.status == http.StatusOK && (seen == nil || seen.ID != user.ID) {
+			t.Errorf("%s: Expected user %d in the context. Received %v", name, user.ID, seen)
+		}
+	}
+}
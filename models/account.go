@@ -0,0 +1,95 @@
+package models
+
+import (
+	"net/http"
+	"strings"
+)
+
+var (
+	// ErrPasswordIncorrect is returned by CheckPassword and
+	// ChangePassword when the current password is wrong
+	ErrPasswordIncorrect = newPublicError("models: current password is incorrect",
+		"Your current password is incorrect.", http.StatusUnauthorized)
+)
+
+// UpdateProfile changes the user's name and email address. A new
+// email address goes through the same checks as at signup and waits
+// in PendingEmail until the user follows a new verification link,
+// so logins and reset links keep going to the old one until then.
+func (us *userService) UpdateProfile(userID uint, name, email string) (*User, error) {
+	if strings.TrimSpace(email) == "" {
+		return nil, ErrEmailRequired
+	}
+	user, err := us.ByID(userID)
+	if err != nil {
+		return nil, err
+	}
+	user.Name = name
+	// The validator drops it again when it is the current address
+	user.PendingEmail = email
+	if err := us.Update(user); err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+// ChangePassword sets a new password once current is checked the
+// same way as a login, so wrong guesses count towards the lockout.
+// Users without a password don't have a current one to give. Every
+// session is ended, the caller has to sign the user in again.
+func (us *userService) ChangePassword(userID uint, current, newPw string) error {
+	if newPw == "" {
+		return ErrPasswordRequired
+	}
+	user, err := us.ByID(userID)
+	if err != nil {
+		return err
+	}
+	if user.Locked() {
+		return ErrTooManyAttempts
+	}
+	if !user.NoPassword {
+		if err := us.CheckPassword(userID, current); err != nil {
+			return err
+		}
+		// CheckPassword may have updated the user
+		if user, err = us.ByID(userID); err != nil {
+			return err
+		}
+	}
+	// Update runs passwordMinLength and hashPassword
+	user.Password = newPw
+	user.FailedLogins = 0
+	user.LockedUntil = nil
+	if err := us.Update(user); err != nil {
+		return err
+	}
+	return us.LogoutAll(user.ID)
+}
+
+// CheckPassword checks current the same way as a login, without
+// caring whether the user could log in right now. Users without a
+// password have nothing to check against.
+func (us *userService) CheckPassword(userID uint, current string) error {
+	user, err := us.ByID(userID)
+	if err != nil {
+		return err
+	}
+	if user.Locked() {
+		return ErrTooManyAttempts
+	}
+	if user.NoPassword {
+		return ErrPasswordIncorrect
+	}
+	_, err = us.Authenticate(user.Email, current)
+	switch err {
+	case nil, ErrEmailNotVerified:
+		// An unverified email address doesn't lock them out of
+		// their own account
+		return nil
+	case ErrCredentialsInvalid:
+		return ErrPasswordIncorrect
+	default:
+		return err
+	}
+}
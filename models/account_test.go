@@ -0,0 +1,144 @@
+package models
+
+import (
+	"testing"
+	"time"
+)
+
+func TestUpdateProfile(t *testing.T) {
+	us, err := testingUserService()
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	jim := User{Name: "Jim", Email: "jim@dundermifflin.com", Password: "big-tuna", EmailVerifiedAt: &now}
+	pam := User{Name: "Pam", Email: "pam@dundermifflin.com", Password: "receptionist"}
+	for _, user := range []*User{&jim, &pam} {
+		if err := us.Create(user); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	user, err := us.UpdateProfile(jim.ID, "Jim Halpert", " JIM@dundermifflin.com ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.Name != "Jim Halpert" || user.Email != "jim@dundermifflin.com" || !user.Verified() {
+		t.Errorf("Expected the same address to stay verified. Received %q %q %v", user.Name, user.Email, user.Verified())
+	}
+
+	if _, err := us.UpdateProfile(jim.ID, "Jim", "pam@dundermifflin.com"); err != ErrEmailTaken {
+		t.Errorf("Expected ErrEmailTaken. Received %v", err)
+	}
+	if _, err := us.UpdateProfile(jim.ID, "Jim", "big tuna"); err != ErrEmailInvalid {
+		t.Errorf("Expected ErrEmailInvalid. Received %v", err)
+	}
+
+	if _, err := us.UpdateProfile(jim.ID, "Jim", " "); err != ErrEmailRequired {
+		t.Errorf("Expected ErrEmailRequired. Received %v", err)
+	}
+
+	user, err = us.UpdateProfile(jim.ID, "Jim", "Jim@Athlead.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.Email != "jim@dundermifflin.com" || user.PendingEmail != "jim@athlead.com" || !user.Verified() {
+		t.Errorf("Expected the new address to wait. Received %q %q %v", user.Email, user.PendingEmail, user.Verified())
+	}
+	// Going back to the current address cancels it
+	user, err = us.UpdateProfile(jim.ID, "Jim", "jim@dundermifflin.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.PendingEmail != "" {
+		t.Errorf("Expected no pending address. Received %q", user.PendingEmail)
+	}
+}
+
+func TestCheckPassword(t *testing.T) {
+	us, err := testingUserService()
+	if err != nil {
+		t.Fatal(err)
+	}
+	user := User{Name: "Stanley", Email: "stanley@dundermifflin.com", Password: "pretzel-day"}
+	if err := us.Create(&user); err != nil {
+		t.Fatal(err)
+	}
+	if err := us.CheckPassword(user.ID, "pretzel-day"); err != nil {
+		t.Errorf("Expected the right password to pass. Received %v", err)
+	}
+	if err := us.CheckPassword(user.ID, "crossword"); err != ErrPasswordIncorrect {
+		t.Errorf("Expected ErrPasswordIncorrect. Received %v", err)
+	}
+	for i := 0; i < LockoutFree; i++ {
+		us.CheckPassword(user.ID, "crossword")
+	}
+	if err := us.CheckPassword(user.ID, "pretzel-day"); err != ErrTooManyAttempts {
+		t.Errorf("Expected ErrTooManyAttempts. Received %v", err)
+	}
+
+	sso := User{Name: "Ryan", Email: "ryan@wuphf.com", NoPassword: true}
+	if err := us.Create(&sso); err != nil {
+		t.Fatal(err)
+	}
+	if err := us.CheckPassword(sso.ID, ""); err != ErrPasswordIncorrect {
+		t.Errorf("Expected ErrPasswordIncorrect without a password. Received %v", err)
+	}
+}
+
+func TestChangePassword(t *testing.T) {
+	s, err := testingServices()
+	if err != nil {
+		t.Fatal(err)
+	}
+	us := s.User
+	user := User{Name: "Dwight", Email: "dwight@dundermifflin.com", Password: "beets-bears"}
+	if err := us.Create(&user); err != nil {
+		t.Fatal(err)
+	}
+	session := Session{UserID: user.ID}
+	if err := s.Session.Create(&session); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := us.ChangePassword(user.ID, "battlestar", "galactica"); err != ErrPasswordIncorrect {
+		t.Errorf("Expected ErrPasswordIncorrect. Received %v", err)
+	}
+	if err := us.ChangePassword(user.ID, "beets-bears", "short"); err != ErrPasswordTooShort {
+		t.Errorf("Expected ErrPasswordTooShort. Received %v", err)
+	}
+	if err := us.ChangePassword(user.ID, "beets-bears", "battlestar"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := us.Authenticate(user.Email, "battlestar"); err != nil {
+		t.Errorf("Expected the new password to work. Received %v", err)
+	}
+	if _, err := us.ByRemember(session.Token); err != ErrNotFound {
+		t.Errorf("Expected the session to be ended. Received %v", err)
+	}
+}
+
+func TestChangePasswordNoPassword(t *testing.T) {
+	us, err := testingUserService()
+	if err != nil {
+		t.Fatal(err)
+	}
+	user, err := us.LoginExternal(ExternalLogin{
+		Issuer:        "https://accounts.example.com",
+		Subject:       "creed",
+		Email:         "creed@dundermifflin.com",
+		EmailVerified: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := us.ChangePassword(user.ID, "", "www.creedthoughts.gov"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := us.Authenticate(user.Email, "www.creedthoughts.gov"); err != nil {
+		t.Errorf("Expected the new password to work. Received %v", err)
+	}
+	if err := us.ChangePassword(user.ID, "", "quality-assurance"); err != ErrPasswordIncorrect {
+		t.Errorf("Expected ErrPasswordIncorrect once a password is set. Received %v", err)
+	}
+}
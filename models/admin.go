@@ -0,0 +1,116 @@
+package models
+
+import (
+	"net/http"
+	"strings"
+	"time"
+)
+
+var (
+	// ErrAccountDisabled is returned when a disabled user tries to
+	// log in, whichever way they try. Single sign-on returns it for
+	// deleted users too.
+	ErrAccountDisabled = newPublicError("models: account is disabled",
+		"This account has been disabled. Please contact us if you think this is a mistake.", http.StatusForbidden)
+
+	// ErrPasswordResetRequired is returned by Authenticate when the
+	// password was right but an admin asked the user to pick a new one
+	ErrPasswordResetRequired = newPublicError("models: password reset required",
+		"You need to choose a new password. We've emailed you a link to do it.", http.StatusForbidden)
+)
+
+const (
+	// DefaultUsersPerPage is the page size Search uses when the
+	// query doesn't set one
+	DefaultUsersPerPage = 25
+
+	// MaxUsersPerPage is the most users Search returns at once
+	MaxUsersPerPage = 100
+)
+
+// UserQuery picks a page of users for Search. Search matches part
+// of the name or email address, every user matches an empty one.
+type UserQuery struct {
+	Search string
+	Offset int
+	Limit  int
+}
+
+// likeEscaper escapes the LIKE wildcards in a search with "!",
+// which unlike a backslash means the same in every dialect
+var likeEscaper = strings.NewReplacer("!", "!!", "%", "!%", "_", "!_")
+
+// Disabled reports whether an admin disabled the account
+func (u *User) Disabled() bool {
+	return u.DisabledAt != nil
+}
+
+// Disable stops the user from logging in and signs them out
+// everywhere, until Enable is called
+func (us *userService) Disable(userID uint) error {
+	user, err := us.ByID(userID)
+	if err != nil {
+		return err
+	}
+	if !user.Disabled() {
+		now := time.Now()
+		user.DisabledAt = &now
+		if err := us.Update(user); err != nil {
+			return err
+		}
+	}
+	return us.LogoutAll(user.ID)
+}
+
+func (us *userService) Enable(userID uint) error {
+	user, err := us.ByID(userID)
+	if err != nil {
+		return err
+	}
+	if !user.Disabled() {
+		return nil
+	}
+	user.DisabledAt = nil
+	return us.Update(user)
+}
+
+// ForcePasswordReset signs the user out everywhere and stops their
+// password from working until they set a new one with the returned
+// reset token. It has to be emailed to the returned user's Email,
+// the last address they verified, never a pending one.
+func (us *userService) ForcePasswordReset(userID uint) (*User, string, error) {
+	user, err := us.ByID(userID)
+	if err != nil {
+		return nil, "", err
+	}
+	user.PasswordResetRequired = true
+	if err := us.Update(user); err != nil {
+		return nil, "", err
+	}
+	if err := us.LogoutAll(user.ID); err != nil {
+		return nil, "", err
+	}
+	pwr := pwReset{
+		UserID: user.ID,
+	}
+	if err := us.pwResetDB.Create(&pwr); err != nil {
+		return nil, "", err
+	}
+	return user, pwr.Token, nil
+}
+
+// Search normalizes the query the same way email addresses are and
+// keeps the page size between 1 and MaxUsersPerPage
+func (uv *userValidator) Search(q UserQuery) ([]User, int, error) {
+	q.Search = strings.ToLower(strings.TrimSpace(q.Search))
+	if q.Offset < 0 {
+		q.Offset = 0
+	}
+	switch {
+	case q.Limit <= 0:
+		q.Limit = DefaultUsersPerPage
+	case q.Limit > MaxUsersPerPage:
+		q.Limit = MaxUsersPerPage
+	}
+	return uv.UserDB.Search(q)
+}
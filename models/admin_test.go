@@ -0,0 +1,138 @@
+package models
+
+import (
+	"testing"
+)
+
+func TestSearch(t *testing.T) {
+	us, err := testingUserService()
+	if err != nil {
+		t.Fatal(err)
+	}
+	users := []User{
+		{Name: "Michael Scott", Email: "michael@dundermifflin.com"},
+		{Name: "Dwight Schrute", Email: "dwight@dundermifflin.com"},
+		{Name: "Jan Levinson", Email: "jan@serenity-by-jan.com"},
+		{Name: "Holly Flax", Email: "holly_flax@dundermifflin.com"},
+	}
+	for i := range users {
+		users[i].Password = "dunder-mifflin"
+		if err := us.Create(&users[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := us.Delete(users[1].ID); err != nil {
+		t.Fatal(err)
+	}
+
+	cases := map[string]struct {
+		q     UserQuery
+		ids   []uint
+		total int
+	}{
+		"everyone":     {UserQuery{}, []uint{1, 3, 4}, 3},
+		"name":         {UserQuery{Search: "  SCOTT "}, []uint{1}, 1},
+		"email":        {UserQuery{Search: "serenity"}, []uint{3}, 1},
+		"deleted":      {UserQuery{Search: "dwight"}, nil, 0},
+		"page":         {UserQuery{Offset: 1, Limit: 1}, []uint{3}, 3},
+		"past the end": {UserQuery{Offset: 10}, nil, 3},
+	}
+	for name, c := range cases {
+		found, total, err := us.Search(c.q)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		var ids []uint
+		for _, u := range found {
+			ids = append(ids, u.ID)
+		}
+		if total != c.total || len(ids) != len(c.ids) {
+			t.Errorf("%s: Expected %v of %d. Received %v of %d", name, c.ids, c.total, ids, total)
+			continue
+		}
+		for i := range ids {
+			if ids[i] != c.ids[i] {
+				t.Errorf("%s: Expected %v. Received %v", name, c.ids, ids)
+				break
+			}
+		}
+	}
+}
+
+func TestDisable(t *testing.T) {
+	s, err := testingServices()
+	if err != nil {
+		t.Fatal(err)
+	}
+	us := s.User
+	user := User{Email: "creed@dundermifflin.com", Password: "quality-assurance"}
+	if err := us.Create(&user); err != nil {
+		t.Fatal(err)
+	}
+	session := Session{UserID: user.ID}
+	if err := s.Session.Create(&session); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := us.Disable(user.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := us.ByRemember(session.Token); err != ErrNotFound {
+		t.Errorf("Expected the session to end. Received %v", err)
+	}
+	if _, err := us.Authenticate(user.Email, "quality-assurance"); err != ErrAccountDisabled {
+		t.Errorf("Expected ErrAccountDisabled. Received %v", err)
+	}
+	// A wrong password doesn't give away that the account exists
+	if _, err := us.Authenticate(user.Email, "wrong-password"); err != ErrCredentialsInvalid {
+		t.Errorf("Expected ErrCredentialsInvalid. Received %v", err)
+	}
+
+	if err := us.Enable(user.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := us.Authenticate(user.Email, "quality-assurance"); err != nil {
+		t.Errorf("Expected to log in after Enable. Received %v", err)
+	}
+}
+
+func TestForcePasswordReset(t *testing.T) {
+	s, err := testingServices()
+	if err != nil {
+		t.Fatal(err)
+	}
+	us := s.User
+	user := User{Email: "ryan@dundermifflin.com", Password: "wuphf-dot-com"}
+	if err := us.Create(&user); err != nil {
+		t.Fatal(err)
+	}
+	session := Session{UserID: user.ID}
+	if err := s.Session.Create(&session); err != nil {
+		t.Fatal(err)
+	}
+
+	// A pending address never gets the link
+	if _, err := us.UpdateProfile(user.ID, "", "ryan@wuphf.com"); err != nil {
+		t.Fatal(err)
+	}
+	reset, token, err := us.ForcePasswordReset(user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reset.Email != "ryan@dundermifflin.com" {
+		t.Errorf("Expected the stored address. Received %q", reset.Email)
+	}
+	if _, err := us.ByRemember(session.Token); err != ErrNotFound {
+		t.Errorf("Expected the session to end. Received %v", err)
+	}
+	if _, err := us.Authenticate(user.Email, "wuphf-dot-com"); err != ErrPasswordResetRequired {
+		t.Errorf("Expected ErrPasswordResetRequired. Received %v", err)
+	}
+
+	if _, err := us.CompleteReset(token, "temp-to-perm"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := us.Authenticate(user.Email, "temp-to-perm"); err != nil {
+		t.Errorf("Expected the new password to work. Received %v", err)
+	}
+}
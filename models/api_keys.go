@@ -0,0 +1,387 @@
+package models
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"../hash"
+	"../rand"
+	"github.com/jinzhu/gorm"
+)
+
+var (
+	// ErrAPIKeyNameRequired is returned when an API key is created
+	// without a name to tell it apart from the others
+	ErrAPIKeyNameRequired = newPublicError("models: API key name is required",
+		"Please give the key a name.", http.StatusBadRequest)
+
+	// ErrAPIKeyScopeInvalid is returned when an API key has no
+	// scopes or one that doesn't exist
+	ErrAPIKeyScopeInvalid = newPublicError("models: API key scopes are not valid",
+		"Please pick what the key is allowed to do.", http.StatusBadRequest)
+
+	// ErrAPIKeyExpiresInvalid is returned when an API key would
+	// already be expired when it is created
+	ErrAPIKeyExpiresInvalid = newPublicError("models: API key expiry is in the past",
+		"The expiry date has to be in the future.", http.StatusBadRequest)
+
+	// ErrAPIKeyHashRequired is returned when an API key is created
+	// without a key hash
+	ErrAPIKeyHashRequired error = privateError("models: API key hash is required")
+)
+
+// The scopes an API key can have. ScopeRead allows requests that
+// don't change anything (GET and HEAD), ScopeWrite allows the rest
+// and includes ScopeRead.
+const (
+	ScopeRead  = "read"
+	ScopeWrite = "write"
+)
+
+// APIScopes lists every scope, in the order they are shown
+var APIScopes = []string{ScopeRead, ScopeWrite}
+
+const (
+	// apiKeyHintLen is how much of the key after its prefix is kept
+	// in Hint, enough to tell keys apart but not to guess them
+	apiKeyHintLen = 4
+
+	// apiKeyTouchInterval limits how often LastUsedAt is written,
+	// the same as sessionTouchInterval
+	apiKeyTouchInterval = time.Minute
+)
+
+// APIKey lets a script act as its owner without a browser session,
+// by sending the key in an "Authorization: Bearer" header. Key is
+// only set right after the key is created, after that only KeyHash
+// is stored. Scopes is a space separated list of APIScopes.
+// ExpiresAt is nil for keys that never expire.
+type APIKey struct {
+	ID         uint   `gorm:"primary_key"`
+	UserID     uint   `gorm:"not null;index"`
+	Name       string `gorm:"not null"`
+	Key        string `gorm:"-"`
+	KeyHash    string `gorm:"not null;unique_index"`
+	Hint       string `gorm:"not null"`
+	Scopes     string `gorm:"not null"`
+	LastUsedAt *time.Time
+	ExpiresAt  *time.Time
+	CreatedAt  time.Time
+}
+
+// Expired reports whether the key can no longer be used
+func (k *APIKey) Expired() bool {
+	return k.ExpiresAt != nil && !time.Now().Before(*k.ExpiresAt)
+}
+
+// ScopeList returns the key's scopes
+func (k *APIKey) ScopeList() []string {
+	return strings.Fields(k.Scopes)
+}
+
+// HasScope reports whether the key was given scope. ScopeWrite
+// includes ScopeRead.
+func (k *APIKey) HasScope(scope string) bool {
+	for _, s := range k.ScopeList() {
+		if s == scope || (s == ScopeWrite && scope == ScopeRead) {
+			return true
+		}
+	}
+	return false
+}
+
+// APIKeyDB is used to interact with the api_keys table.
+//
+// For single key queries:
+// If the key is found: key, nil
+// If the key is not found: nil, ErrNotFound
+// If there is another error: nil, OtherError
+type APIKeyDB interface {
+	// ByKey expects the raw key at the validation layer and the
+	// hashed key at the database layer
+	ByKey(key string) (*APIKey, error)
+	ByID(id uint) (*APIKey, error)
+	ByUserID(userID uint) ([]APIKey, error)
+
+	Create(key *APIKey) error
+	Update(key *APIKey) error
+	Delete(id uint) error
+	DeleteByUserID(userID uint) error
+}
+
+// APIKeyService is a set of methods used to manipulate and work
+// with the API key model
+type APIKeyService interface {
+	// Touch records that the key was just used
+	Touch(key *APIKey) error
+
+	// Revoke deletes the key with the provided ID if it belongs to
+	// userID, and returns ErrNotFound if it doesn't
+	Revoke(userID, id uint) error
+	APIKeyDB
+}
+
+func newAPIKeyService(db APIKeyDB, hmac hash.Keyring) *apiKeyService {
+	return &apiKeyService{
+		APIKeyDB: newAPIKeyValidator(db, hmac),
+	}
+}
+
+var _ APIKeyService = &apiKeyService{}
+
+type apiKeyService struct {
+	APIKeyDB
+}
+
+// Touch updates LastUsedAt, at most once per apiKeyTouchInterval
+func (aks *apiKeyService) Touch(key *APIKey) error {
+	if key.LastUsedAt != nil && time.Since(*key.LastUsedAt) < apiKeyTouchInterval {
+		return nil
+	}
+	now := time.Now()
+	key.LastUsedAt = &now
+	return aks.Update(key)
+}
+
+func (aks *apiKeyService) Revoke(userID, id uint) error {
+	key, err := aks.ByID(id)
+	if err != nil {
+		return err
+	}
+	if key.UserID != userID {
+		return ErrNotFound
+	}
+	return aks.Delete(id)
+}
+
+type apiKeyValFunc func(*APIKey) error
+
+func runAPIKeyValFuncs(key *APIKey, fns ...apiKeyValFunc) error {
+	for _, fn := range fns {
+		if err := fn(key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+var _ APIKeyDB = &apiKeyValidator{}
+
+func newAPIKeyValidator(db APIKeyDB, hmac hash.Keyring) *apiKeyValidator {
+	return &apiKeyValidator{
+		APIKeyDB: db,
+		hmac:     hmac,
+	}
+}
+
+type apiKeyValidator struct {
+	APIKeyDB
+	hmac hash.Keyring
+}
+
+// ByKey hashes the key and looks it up. Keys that are malformed
+// or expired are treated as if they don't exist, and a key found
+// under a previous HMAC key is hashed again with the current one.
+func (akv *apiKeyValidator) ByKey(key string) (*APIKey, error) {
+	if !rand.APIKey.Valid(key) {
+		return nil, ErrNotFound
+	}
+	var found *APIKey
+	stale, err := findHashed(akv.hmac, key, func(hash string) (err error) {
+		found, err = akv.APIKeyDB.ByKey(hash)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	if found.Expired() {
+		return nil, ErrNotFound
+	}
+	if stale {
+		found.Key = key
+		if err := akv.Update(found); err != nil {
+			return nil, err
+		}
+	}
+	return found, nil
+}
+
+func (akv *apiKeyValidator) ByID(id uint) (*APIKey, error) {
+	if id <= 0 {
+		return nil, ErrIDInvalid
+	}
+	return akv.APIKeyDB.ByID(id)
+}
+
+func (akv *apiKeyValidator) Create(key *APIKey) error {
+	err := runAPIKeyValFuncs(key,
+		akv.userIDRequired,
+		akv.normalizeName,
+		akv.nameRequired,
+		akv.normalizeScopes,
+		akv.expiresInFuture,
+		akv.setKeyIfUnset,
+		akv.hmacKey,
+		akv.keyHashRequired)
+	if err != nil {
+		return err
+	}
+	return akv.APIKeyDB.Create(key)
+}
+
+func (akv *apiKeyValidator) Update(key *APIKey) error {
+	err := runAPIKeyValFuncs(key,
+		akv.userIDRequired,
+		akv.normalizeName,
+		akv.nameRequired,
+		akv.normalizeScopes,
+		akv.hmacKey,
+		akv.keyHashRequired)
+	if err != nil {
+		return err
+	}
+	return akv.APIKeyDB.Update(key)
+}
+
+func (akv *apiKeyValidator) Delete(id uint) error {
+	if id <= 0 {
+		return ErrIDInvalid
+	}
+	return akv.APIKeyDB.Delete(id)
+}
+
+func (akv *apiKeyValidator) DeleteByUserID(userID uint) error {
+	if userID <= 0 {
+		return ErrIDInvalid
+	}
+	return akv.APIKeyDB.DeleteByUserID(userID)
+}
+
+func (akv *apiKeyValidator) userIDRequired(key *APIKey) error {
+	if key.UserID <= 0 {
+		return ErrUserIDRequired
+	}
+	return nil
+}
+
+func (akv *apiKeyValidator) normalizeName(key *APIKey) error {
+	key.Name = strings.TrimSpace(key.Name)
+	return nil
+}
+
+func (akv *apiKeyValidator) nameRequired(key *APIKey) error {
+	if key.Name == "" {
+		return ErrAPIKeyNameRequired
+	}
+	return nil
+}
+
+// normalizeScopes drops duplicates and puts the scopes in the
+// order of APIScopes, so the same scopes are always stored the
+// same way
+func (akv *apiKeyValidator) normalizeScopes(key *APIKey) error {
+	given := make(map[string]bool)
+	for _, s := range key.ScopeList() {
+		given[s] = true
+	}
+	var scopes []string
+	for _, s := range APIScopes {
+		if given[s] {
+			scopes = append(scopes, s)
+			delete(given, s)
+		}
+	}
+	if len(scopes) == 0 || len(given) > 0 {
+		return ErrAPIKeyScopeInvalid
+	}
+	key.Scopes = strings.Join(scopes, " ")
+	return nil
+}
+
+func (akv *apiKeyValidator) expiresInFuture(key *APIKey) error {
+	if key.Expired() {
+		return ErrAPIKeyExpiresInvalid
+	}
+	return nil
+}
+
+func (akv *apiKeyValidator) setKeyIfUnset(key *APIKey) error {
+	if key.Key != "" {
+		return nil
+	}
+	k, err := rand.APIKey.New()
+	if err != nil {
+		return err
+	}
+	key.Key = k
+	key.Hint = k[:len(rand.APIKey.Prefix)+apiKeyHintLen]
+	return nil
+}
+
+func (akv *apiKeyValidator) hmacKey(key *APIKey) error {
+	if key.Key == "" {
+		return nil
+	}
+	key.KeyHash = akv.hmac.Hash(key.Key)
+	return nil
+}
+
+func (akv *apiKeyValidator) keyHashRequired(key *APIKey) error {
+	if key.KeyHash == "" {
+		return ErrAPIKeyHashRequired
+	}
+	return nil
+}
+
+var _ APIKeyDB = &apiKeyGorm{}
+
+type apiKeyGorm struct {
+	db *gorm.DB
+}
+
+// ByKey looks up a key by its hash
+func (akg *apiKeyGorm) ByKey(keyHash string) (*APIKey, error) {
+	var key APIKey
+	if err := first(akg.db.Where("key_hash = ?", keyHash), &key); err != nil {
+		return nil, err
+	}
+	return &key, nil
+}
+
+func (akg *apiKeyGorm) ByID(id uint) (*APIKey, error) {
+	var key APIKey
+	if err := first(akg.db.Where("id = ?", id), &key); err != nil {
+		return nil, err
+	}
+	return &key, nil
+}
+
+// ByUserID returns every key the user has, newest first
+func (akg *apiKeyGorm) ByUserID(userID uint) ([]APIKey, error) {
+	var keys []APIKey
+	err := akg.db.Where("user_id = ?", userID).
+		Order("created_at desc").
+		Find(&keys).Error
+	if err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
+
+func (akg *apiKeyGorm) Create(key *APIKey) error {
+	return akg.db.Create(key).Error
+}
+
+func (akg *apiKeyGorm) Update(key *APIKey) error {
+	return akg.db.Save(key).Error
+}
+
+func (akg *apiKeyGorm) Delete(id uint) error {
+	key := APIKey{ID: id}
+	return akg.db.Delete(&key).Error
+}
+
+func (akg *apiKeyGorm) DeleteByUserID(userID uint) error {
+	return akg.db.Where("user_id = ?", userID).Delete(&APIKey{}).Error
+}
@@ -0,0 +1,138 @@
+package models
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+var _ APIKeyDB = &apiKeyMem{}
+
+func newAPIKeyMem() *apiKeyMem {
+	return &apiKeyMem{
+		keys: make(map[uint]APIKey),
+	}
+}
+
+// apiKeyMem is the in-memory APIKeyDB used alongside userMem.
+// KeyHash is unique, the same as the index on the api_keys table.
+type apiKeyMem struct {
+	mu     sync.RWMutex
+	keys   map[uint]APIKey
+	lastID uint
+}
+
+func (akm *apiKeyMem) ByKey(keyHash string) (*APIKey, error) {
+	akm.mu.RLock()
+	defer akm.mu.RUnlock()
+	for _, k := range akm.keys {
+		if k.KeyHash == keyHash {
+			found := k
+			return &found, nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+func (akm *apiKeyMem) ByID(id uint) (*APIKey, error) {
+	akm.mu.RLock()
+	defer akm.mu.RUnlock()
+	k, ok := akm.keys[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return &k, nil
+}
+
+func (akm *apiKeyMem) ByUserID(userID uint) ([]APIKey, error) {
+	akm.mu.RLock()
+	defer akm.mu.RUnlock()
+	var keys []APIKey
+	for _, k := range akm.keys {
+		if k.UserID == userID {
+			keys = append(keys, k)
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i].ID > keys[j].ID
+	})
+	return keys, nil
+}
+
+func (akm *apiKeyMem) Create(key *APIKey) error {
+	akm.mu.Lock()
+	defer akm.mu.Unlock()
+	return akm.create(key)
+}
+
+func (akm *apiKeyMem) create(key *APIKey) error {
+	if err := akm.checkUnique(key); err != nil {
+		return err
+	}
+	if key.ID == 0 {
+		akm.lastID++
+		key.ID = akm.lastID
+	} else if key.ID > akm.lastID {
+		akm.lastID = key.ID
+	}
+	if key.CreatedAt.IsZero() {
+		key.CreatedAt = time.Now()
+	}
+	akm.store(key)
+	return nil
+}
+
+// Update saves every field of the key, creating it if needed
+func (akm *apiKeyMem) Update(key *APIKey) error {
+	akm.mu.Lock()
+	defer akm.mu.Unlock()
+	if _, ok := akm.keys[key.ID]; !ok {
+		return akm.create(key)
+	}
+	if err := akm.checkUnique(key); err != nil {
+		return err
+	}
+	akm.store(key)
+	return nil
+}
+
+func (akm *apiKeyMem) Delete(id uint) error {
+	akm.mu.Lock()
+	defer akm.mu.Unlock()
+	delete(akm.keys, id)
+	return nil
+}
+
+func (akm *apiKeyMem) DeleteByUserID(userID uint) error {
+	akm.mu.Lock()
+	defer akm.mu.Unlock()
+	for id, k := range akm.keys {
+		if k.UserID == userID {
+			delete(akm.keys, id)
+		}
+	}
+	return nil
+}
+
+func (akm *apiKeyMem) checkUnique(key *APIKey) error {
+	for id, k := range akm.keys {
+		if id != key.ID && k.KeyHash == key.KeyHash {
+			return errMemDuplicate
+		}
+	}
+	return nil
+}
+
+// store saves a copy of the key without the raw key
+func (akm *apiKeyMem) store(key *APIKey) {
+	k := *key
+	k.Key = ""
+	akm.keys[k.ID] = k
+}
+
+func (akm *apiKeyMem) reset() {
+	akm.mu.Lock()
+	defer akm.mu.Unlock()
+	akm.keys = make(map[uint]APIKey)
+	akm.lastID = 0
+}
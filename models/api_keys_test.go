@@ -0,0 +1,78 @@
+package models
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAPIKeys(t *testing.T) {
+	s, err := testingServices()
+	if err != nil {
+		t.Fatal(err)
+	}
+	key := APIKey{UserID: 1, Name: " Nightly export ", Scopes: "write read write"}
+	if err := s.APIKey.Create(&key); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(key.Key, "dbk_") || !strings.HasPrefix(key.Key, key.Hint) {
+		t.Errorf("Expected a dbk_ key starting with its hint. Received %s, %s", key.Key, key.Hint)
+	}
+	if key.Name != "Nightly export" || key.Scopes != "read write" {
+		t.Errorf("Expected the name and scopes to be normalized. Received %q, %q", key.Name, key.Scopes)
+	}
+
+	found, err := s.APIKey.ByKey(key.Key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if found.ID != key.ID || found.Key != "" {
+		t.Errorf("Expected key %d without the raw key. Received %+v", key.ID, found)
+	}
+	if err := s.APIKey.Touch(found); err != nil || found.LastUsedAt == nil {
+		t.Errorf("Expected LastUsedAt to be set. Received %v, %v", found.LastUsedAt, err)
+	}
+	if _, err := s.APIKey.ByKey("dbk_notarealkey"); err != ErrNotFound {
+		t.Errorf("Expected ErrNotFound for a made up key. Received %v", err)
+	}
+
+	// Only the owner can revoke it
+	if err := s.APIKey.Revoke(2, key.ID); err != ErrNotFound {
+		t.Errorf("Expected ErrNotFound revoking someone else's key. Received %v", err)
+	}
+	if err := s.APIKey.Revoke(1, key.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.APIKey.ByKey(key.Key); err != ErrNotFound {
+		t.Errorf("Expected ErrNotFound after revoking. Received %v", err)
+	}
+}
+
+func TestAPIKeyValidation(t *testing.T) {
+	s, err := testingServices()
+	if err != nil {
+		t.Fatal(err)
+	}
+	past := time.Now().Add(-time.Hour)
+	cases := map[string]struct {
+		key APIKey
+		err error
+	}{
+		"no name":       {APIKey{UserID: 1, Scopes: ScopeRead}, ErrAPIKeyNameRequired},
+		"no scopes":     {APIKey{UserID: 1, Name: "ci"}, ErrAPIKeyScopeInvalid},
+		"unknown scope": {APIKey{UserID: 1, Name: "ci", Scopes: "read admin"}, ErrAPIKeyScopeInvalid},
+		"expired":       {APIKey{UserID: 1, Name: "ci", Scopes: ScopeRead, ExpiresAt: &past}, ErrAPIKeyExpiresInvalid},
+		"no user":       {APIKey{Name: "ci", Scopes: ScopeRead}, ErrUserIDRequired},
+	}
+	for name, c := range cases {
+		if err := s.APIKey.Create(&c.key); err != c.err {
+			t.Errorf("%s: Expected %v. Received %v", name, c.err, err)
+		}
+	}
+
+	read := APIKey{Scopes: ScopeRead}
+	write := APIKey{Scopes: ScopeWrite}
+	if read.HasScope(ScopeWrite) || !write.HasScope(ScopeRead) {
+		t.Error("Expected write to include read and not the other way around")
+	}
+}
@@ -0,0 +1,43 @@
+package models
+
+import (
+	"errors"
+
+	// Register every dialect WithGorm knows how to open
+	_ "github.com/jinzhu/gorm/dialects/mysql"
+	_ "github.com/jinzhu/gorm/dialects/postgres"
+	_ "github.com/jinzhu/gorm/dialects/sqlite"
+)
+
+// The dialects that can be passed to WithGorm. The same
+// validator and service layers run on top of each one.
+//
+// Postgres expects a connection string like
+//
+//	host=localhost port=5432 user=postgres dbname=databot_dev sslmode=disable
+//
+// MySQL needs parseTime=True so gorm can scan the timestamp columns
+//
+//	user:password@tcp(localhost:3306)/databot_dev?charset=utf8mb4&parseTime=True
+//
+// SQLite takes a file path, or "file::memory:?cache=shared" for a
+// throwaway database in CI
+const (
+	DialectPostgres = "postgres"
+	DialectMySQL    = "mysql"
+	DialectSQLite   = "sqlite3"
+)
+
+// ErrDialectUnsupported is returned when WithGorm is given a
+// dialect other than the ones above
+var ErrDialectUnsupported = errors.New("models: database dialect is not supported")
+
+// checkDialect makes sure dialect is one we register and migrate
+func checkDialect(dialect string) error {
+	switch dialect {
+	case DialectPostgres, DialectMySQL, DialectSQLite:
+		return nil
+	default:
+		return ErrDialectUnsupported
+	}
+}
@@ -0,0 +1,62 @@
+package models
+
+import (
+	"errors"
+)
+
+// PublicError is implemented by errors whose message is safe to
+// show to users. Every other error from this package, like a
+// database or bcrypt failure, is private and should be logged
+// instead of displayed.
+type PublicError interface {
+	error
+	// Public returns a message written for the user
+	Public() string
+	// Status returns the HTTP status code that fits the error
+	Status() int
+}
+
+// IsPublic returns the PublicError wrapped in err, if there is one
+func IsPublic(err error) (PublicError, bool) {
+	var pErr PublicError
+	if errors.As(err, &pErr) {
+		return pErr, true
+	}
+	return nil, false
+}
+
+// publicError is a validation error. Error keeps the "models:"
+// prefix for logs while Public is what users see.
+type publicError struct {
+	err    string
+	public string
+	status int
+}
+
+func newPublicError(err, public string, status int) error {
+	return publicError{
+		err:    err,
+		public: public,
+		status: status,
+	}
+}
+
+func (e publicError) Error() string {
+	return e.err
+}
+
+func (e publicError) Public() string {
+	return e.public
+}
+
+func (e publicError) Status() int {
+	return e.status
+}
+
+// privateError is an error that only makes sense to developers.
+// It doesn't implement PublicError so it is never displayed.
+type privateError string
+
+func (e privateError) Error() string {
+	return string(e)
+}
@@ -0,0 +1,39 @@
+package models
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+)
+
+func TestIsPublic(t *testing.T) {
+	tests := []struct {
+		err    error
+		public bool
+		status int
+	}{
+		{ErrEmailTaken, true, http.StatusConflict},
+		{ErrPasswordTooShort, true, http.StatusUnprocessableEntity},
+		{fmt.Errorf("signup: %w", ErrEmailInvalid), true, http.StatusUnprocessableEntity},
+		{ErrIDInvalid, false, 0},
+		{ErrRememberRequired, false, 0},
+		{ErrAPIKeyHashRequired, false, 0},
+		{fmt.Errorf("pq: connection refused"), false, 0},
+	}
+	for _, tc := range tests {
+		pErr, ok := IsPublic(tc.err)
+		if ok != tc.public {
+			t.Errorf("%v: Expected public to be %t", tc.err, tc.public)
+			continue
+		}
+		if !ok {
+			continue
+		}
+		if pErr.Status() != tc.status {
+			t.Errorf("%v: Expected status %d. Received %d", tc.err, tc.status, pErr.Status())
+		}
+		if pErr.Public() == pErr.Error() {
+			t.Errorf("%v: Expected a user facing message", tc.err)
+		}
+	}
+}
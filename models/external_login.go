@@ -0,0 +1,102 @@
+package models
+
+import (
+	"net/http"
+	"time"
+)
+
+var (
+	// ErrExternalEmailUnverified is returned by LoginExternal when
+	// the provider doesn't vouch for the email address, so it can't
+	// be used to find or create an account
+	ErrExternalEmailUnverified = newPublicError("models: external login email address is not verified",
+		"Your sign-in provider hasn't verified your email address.", http.StatusForbidden)
+
+	// ErrExternalAccountUnverified is returned by LoginExternal when
+	// an account with the email address exists but its owner never
+	// verified it, so it might not be the same person
+	ErrExternalAccountUnverified = newPublicError("models: external login matches an unverified account",
+		"An account with this email address already exists. Log in with your password and verify your email address first.", http.StatusConflict)
+)
+
+// ExternalLogin is who a single sign-on provider says signed in
+type ExternalLogin struct {
+	Issuer        string
+	Subject       string
+	Email         string
+	EmailVerified bool
+	Name          string
+}
+
+// LoginExternal returns the user linked to login. The first time
+// someone signs in with an account at the provider it is linked to
+// the user with the same verified email address, or a new user is
+// created for it without a password.
+func (us *userService) LoginExternal(login ExternalLogin) (*User, error) {
+	ident, err := us.identityDB.ByIssuerSubject(login.Issuer, login.Subject)
+	if err == nil {
+		user, err := us.ByID(ident.UserID)
+		if err == ErrNotFound {
+			// The user was deleted before DeleteAccount removed
+			// identities, and is waiting to be purged
+			return nil, ErrAccountDisabled
+		}
+		if err != nil {
+			return nil, err
+		}
+		if user.Disabled() {
+			return nil, ErrAccountDisabled
+		}
+		return user, nil
+	}
+	if err != ErrNotFound {
+		return nil, err
+	}
+
+	if !login.EmailVerified {
+		return nil, ErrExternalEmailUnverified
+	}
+	user, err := us.ByEmail(login.Email)
+	switch err {
+	case nil:
+		// Someone else could have signed up with the address and a
+		// password of their own, don't hand them this login too
+		if !user.Verified() {
+			return nil, ErrExternalAccountUnverified
+		}
+		if user.Disabled() {
+			return nil, ErrAccountDisabled
+		}
+	case ErrNotFound:
+		// A deleted user keeps the address until they are purged
+		_, err := us.ByEmailIncludingDeleted(login.Email)
+		if err == nil {
+			return nil, ErrAccountDisabled
+		}
+		if err != ErrNotFound {
+			return nil, err
+		}
+		now := time.Now()
+		user = &User{
+			Name:            login.Name,
+			Email:           login.Email,
+			NoPassword:      true,
+			EmailVerifiedAt: &now,
+		}
+		if err := us.Create(user); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, err
+	}
+
+	ident = &identity{
+		UserID:  user.ID,
+		Issuer:  login.Issuer,
+		Subject: login.Subject,
+	}
+	if err := us.identityDB.Create(ident); err != nil {
+		return nil, err
+	}
+	return user, nil
+}
@@ -0,0 +1,117 @@
+package models
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLoginExternal(t *testing.T) {
+	us, err := testingUserService()
+	if err != nil {
+		t.Fatal(err)
+	}
+	login := ExternalLogin{
+		Issuer:        "https://accounts.example.com",
+		Subject:       "248289761001",
+		Email:         "Jim@DunderMifflin.com",
+		EmailVerified: true,
+		Name:          "Jim Halpert",
+	}
+	user, err := us.LoginExternal(login)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.ID == 0 || user.Email != "jim@dundermifflin.com" || !user.NoPassword || !user.Verified() {
+		t.Errorf("Expected a new verified user without a password. Received %+v", user)
+	}
+	// There is no password to log in with
+	if _, err := us.Authenticate("jim@dundermifflin.com", ""); err != ErrCredentialsInvalid {
+		t.Errorf("Expected ErrCredentialsInvalid. Received %v", err)
+	}
+
+	// The identity is linked now, so a changed email doesn't matter
+	login.Email = "jim@athleap.com"
+	login.EmailVerified = false
+	again, err := us.LoginExternal(login)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again.ID != user.ID {
+		t.Errorf("Expected user %d. Received %d", user.ID, again.ID)
+	}
+}
+
+func TestLoginExternalLinksByEmail(t *testing.T) {
+	us, err := testingUserService()
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	verified := User{Name: "Pam", Email: "pam@dundermifflin.com", Password: "beesly-123", EmailVerifiedAt: &now}
+	unverified := User{Name: "Ryan", Email: "ryan@dundermifflin.com", Password: "wuphf-1234"}
+	for _, u := range []*User{&verified, &unverified} {
+		if err := us.Create(u); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	login := ExternalLogin{Issuer: "https://accounts.example.com", Subject: "pam", Email: "pam@dundermifflin.com", EmailVerified: true}
+	user, err := us.LoginExternal(login)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.ID != verified.ID {
+		t.Errorf("Expected user %d. Received %d", verified.ID, user.ID)
+	}
+	// Linking doesn't take the password away
+	if _, err := us.Authenticate("pam@dundermifflin.com", "beesly-123"); err != nil {
+		t.Errorf("Expected the password to still work. Received %v", err)
+	}
+
+	cases := map[string]struct {
+		login ExternalLogin
+		err   error
+	}{
+		"unverified email":   {ExternalLogin{Issuer: "https://accounts.example.com", Subject: "x", Email: "pam@dundermifflin.com"}, ErrExternalEmailUnverified},
+		"unverified account": {ExternalLogin{Issuer: "https://accounts.example.com", Subject: "ryan", Email: "ryan@dundermifflin.com", EmailVerified: true}, ErrExternalAccountUnverified},
+	}
+	for name, c := range cases {
+		if _, err := us.LoginExternal(c.login); err != c.err {
+			t.Errorf("%s: Expected %v. Received %v", name, c.err, err)
+		}
+	}
+}
+
+func TestLoginExternalDeleted(t *testing.T) {
+	us, err := testingUserService()
+	if err != nil {
+		t.Fatal(err)
+	}
+	login := ExternalLogin{Issuer: "https://accounts.example.com", Subject: "kelly", Email: "kelly@dundermifflin.com", EmailVerified: true}
+	user, err := us.LoginExternal(login)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := us.DeleteAccount(user.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := us.(*userService).identityDB.ByIssuerSubject(login.Issuer, login.Subject); err != ErrNotFound {
+		t.Errorf("Expected the identity to be removed. Received %v", err)
+	}
+	if _, err := us.LoginExternal(login); err != ErrAccountDisabled {
+		t.Errorf("Expected ErrAccountDisabled. Received %v", err)
+	}
+
+	// Identities left over from before DeleteAccount removed them
+	login = ExternalLogin{Issuer: "https://accounts.example.com", Subject: "ryan", Email: "ryan@dundermifflin.com", EmailVerified: true}
+	user, err = us.LoginExternal(login)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := us.Delete(user.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := us.LoginExternal(login); err != ErrAccountDisabled {
+		t.Errorf("Expected ErrAccountDisabled for a stale identity. Received %v", err)
+	}
+}
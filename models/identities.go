@@ -0,0 +1,126 @@
+package models
+
+import (
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+var (
+	// ErrIdentityRequired is returned when an identity is created
+	// without the issuer and subject that identify it
+	ErrIdentityRequired error = privateError("models: identity issuer and subject are required")
+)
+
+// identity links a user to an account at a single sign-on
+// provider. Issuer and Subject are the provider's iss and sub
+// claims, which together never change for that account, unlike its
+// email address.
+type identity struct {
+	ID        uint   `gorm:"primary_key"`
+	UserID    uint   `gorm:"not null;index"`
+	Issuer    string `gorm:"not null;unique_index:idx_identities_issuer_subject"`
+	Subject   string `gorm:"not null;unique_index:idx_identities_issuer_subject"`
+	CreatedAt time.Time
+}
+
+// identityDB is used to interact with the identities table
+type identityDB interface {
+	ByIssuerSubject(issuer, subject string) (*identity, error)
+	ByUserID(userID uint) ([]identity, error)
+	Create(ident *identity) error
+	DeleteByUserID(userID uint) error
+}
+
+type identityValFunc func(*identity) error
+
+func runIdentityValFuncs(ident *identity, fns ...identityValFunc) error {
+	for _, fn := range fns {
+		if err := fn(ident); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+var _ identityDB = &identityValidator{}
+
+func newIdentityValidator(db identityDB) *identityValidator {
+	return &identityValidator{
+		identityDB: db,
+	}
+}
+
+type identityValidator struct {
+	identityDB
+}
+
+func (iv *identityValidator) ByIssuerSubject(issuer, subject string) (*identity, error) {
+	ident := identity{Issuer: issuer, Subject: subject}
+	if err := runIdentityValFuncs(&ident, iv.issuerSubjectRequired); err != nil {
+		return nil, err
+	}
+	return iv.identityDB.ByIssuerSubject(ident.Issuer, ident.Subject)
+}
+
+func (iv *identityValidator) Create(ident *identity) error {
+	err := runIdentityValFuncs(ident,
+		iv.userIDRequired,
+		iv.issuerSubjectRequired)
+	if err != nil {
+		return err
+	}
+	return iv.identityDB.Create(ident)
+}
+
+func (iv *identityValidator) DeleteByUserID(userID uint) error {
+	if userID <= 0 {
+		return ErrIDInvalid
+	}
+	return iv.identityDB.DeleteByUserID(userID)
+}
+
+func (iv *identityValidator) userIDRequired(ident *identity) error {
+	if ident.UserID <= 0 {
+		return ErrUserIDRequired
+	}
+	return nil
+}
+
+func (iv *identityValidator) issuerSubjectRequired(ident *identity) error {
+	if ident.Issuer == "" || ident.Subject == "" {
+		return ErrIdentityRequired
+	}
+	return nil
+}
+
+var _ identityDB = &identityGorm{}
+
+type identityGorm struct {
+	db *gorm.DB
+}
+
+func (ig *identityGorm) ByIssuerSubject(issuer, subject string) (*identity, error) {
+	var ident identity
+	db := ig.db.Where("issuer = ? AND subject = ?", issuer, subject)
+	if err := first(db, &ident); err != nil {
+		return nil, err
+	}
+	return &ident, nil
+}
+
+func (ig *identityGorm) ByUserID(userID uint) ([]identity, error) {
+	var idents []identity
+	if err := ig.db.Where("user_id = ?", userID).Order("id").Find(&idents).Error; err != nil {
+		return nil, err
+	}
+	return idents, nil
+}
+
+func (ig *identityGorm) Create(ident *identity) error {
+	return ig.db.Create(ident).Error
+}
+
+func (ig *identityGorm) DeleteByUserID(userID uint) error {
+	return ig.db.Where("user_id = ?", userID).Delete(&identity{}).Error
+}
@@ -0,0 +1,82 @@
+package models
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+var _ identityDB = &identityMem{}
+
+func newIdentityMem() *identityMem {
+	return &identityMem{
+		identities: make(map[uint]identity),
+	}
+}
+
+// identityMem is the in-memory identityDB used alongside userMem
+type identityMem struct {
+	mu         sync.RWMutex
+	identities map[uint]identity
+	lastID     uint
+}
+
+func (im *identityMem) ByIssuerSubject(issuer, subject string) (*identity, error) {
+	im.mu.RLock()
+	defer im.mu.RUnlock()
+	for _, ident := range im.identities {
+		if ident.Issuer == issuer && ident.Subject == subject {
+			found := ident
+			return &found, nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+func (im *identityMem) ByUserID(userID uint) ([]identity, error) {
+	im.mu.RLock()
+	defer im.mu.RUnlock()
+	var idents []identity
+	for _, ident := range im.identities {
+		if ident.UserID == userID {
+			idents = append(idents, ident)
+		}
+	}
+	sort.Slice(idents, func(i, j int) bool {
+		return idents[i].ID < idents[j].ID
+	})
+	return idents, nil
+}
+
+func (im *identityMem) Create(ident *identity) error {
+	im.mu.Lock()
+	defer im.mu.Unlock()
+	for _, existing := range im.identities {
+		if existing.Issuer == ident.Issuer && existing.Subject == ident.Subject {
+			return errMemDuplicate
+		}
+	}
+	im.lastID++
+	ident.ID = im.lastID
+	ident.CreatedAt = time.Now()
+	im.identities[ident.ID] = *ident
+	return nil
+}
+
+func (im *identityMem) DeleteByUserID(userID uint) error {
+	im.mu.Lock()
+	defer im.mu.Unlock()
+	for id, ident := range im.identities {
+		if ident.UserID == userID {
+			delete(im.identities, id)
+		}
+	}
+	return nil
+}
+
+func (im *identityMem) reset() {
+	im.mu.Lock()
+	defer im.mu.Unlock()
+	im.identities = make(map[uint]identity)
+	im.lastID = 0
+}
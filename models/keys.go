@@ -0,0 +1,18 @@
+package models
+
+import "../hash"
+
+// findHashed looks up a value that is stored hashed, trying its
+// hash under every key in keys, the current key first. stale is true
+// when it was only found under a previous key, so the caller can
+// store the current hash instead.
+func findHashed(keys hash.Keyring, input string, find func(hash string) error) (stale bool, err error) {
+	for i, h := range keys.Hashes(input) {
+		err := find(h)
+		if err == ErrNotFound {
+			continue
+		}
+		return i > 0, err
+	}
+	return false, ErrNotFound
+}
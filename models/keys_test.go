@@ -0,0 +1,40 @@
+package models
+
+import (
+	"testing"
+
+	"../hash"
+)
+
+func TestKeyRotation(t *testing.T) {
+	oldKeys := hash.NewKeyring(hash.Key{Secret: testHMACKey})
+	newKeys := hash.NewKeyring(hash.Key{ID: "2", Secret: "new-hmac-key"}, hash.Key{Secret: testHMACKey})
+
+	// Sessions hashed with the old key still work, and are moved
+	// over to the new key the first time they are used
+	sm := newSessionMem()
+	session := Session{UserID: 1}
+	if err := newSessionValidator(sm, oldKeys).Create(&session); err != nil {
+		t.Fatal(err)
+	}
+	sv := newSessionValidator(sm, newKeys)
+	if _, err := sv.ByToken(session.Token); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := sm.ByToken(newKeys.Hash(session.Token)); err != nil {
+		t.Errorf("Expected the session to be hashed with the new key. Received %v", err)
+	}
+	if _, err := sv.ByToken(session.Token); err != nil {
+		t.Errorf("Expected the session to still work. Received %v", err)
+	}
+
+	// Dropping the old key invalidates whatever wasn't moved over
+	other := Session{UserID: 2}
+	if err := newSessionValidator(sm, oldKeys).Create(&other); err != nil {
+		t.Fatal(err)
+	}
+	onlyNew := hash.NewKeyring(hash.Key{ID: "2", Secret: "new-hmac-key"})
+	if _, err := newSessionValidator(sm, onlyNew).ByToken(other.Token); err != ErrNotFound {
+		t.Errorf("Expected ErrNotFound without the old key. Received %v", err)
+	}
+}
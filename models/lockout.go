@@ -0,0 +1,58 @@
+package models
+
+import (
+	"net/http"
+	"time"
+
+	"../throttle"
+)
+
+var (
+	// ErrCredentialsInvalid is returned by Authenticate when either
+	// the email address or the password is wrong. It doesn't say
+	// which, so it can't be used to find out who has an account.
+	ErrCredentialsInvalid = newPublicError("models: invalid email address or password",
+		"Invalid email address or password.", http.StatusUnauthorized)
+
+	// ErrTooManyAttempts is returned while an account is locked after
+	// too many wrong passwords. Authenticate returns
+	// ErrCredentialsInvalid instead, so the lock doesn't give away
+	// that the account exists.
+	ErrTooManyAttempts = newPublicError("models: too many failed login attempts",
+		"Too many failed attempts. Please wait a few minutes and try again.", http.StatusTooManyRequests)
+)
+
+// An account is locked once it has more than LockoutFree wrong
+// passwords in a row. The lock starts at LockoutBase and doubles
+// with every further failure, up to LockoutMax.
+const (
+	LockoutFree = 5
+	LockoutBase = time.Minute
+	LockoutMax  = time.Hour
+)
+
+// Locked reports whether the user is currently locked out
+func (u *User) Locked() bool {
+	return u.LockedUntil != nil && time.Now().Before(*u.LockedUntil)
+}
+
+// recordFailedLogin counts a wrong password against the user and
+// locks the account once there were too many
+func (us *userService) recordFailedLogin(user *User) error {
+	user.FailedLogins++
+	if d := throttle.Backoff(user.FailedLogins-LockoutFree, LockoutBase, LockoutMax); d > 0 {
+		until := time.Now().Add(d)
+		user.LockedUntil = &until
+	}
+	return us.Update(user)
+}
+
+// resetFailedLogins clears the failures after a correct password
+func (us *userService) resetFailedLogins(user *User) error {
+	if user.FailedLogins == 0 && user.LockedUntil == nil {
+		return nil
+	}
+	user.FailedLogins = 0
+	user.LockedUntil = nil
+	return us.Update(user)
+}
@@ -0,0 +1,59 @@
+package models
+
+import (
+	"testing"
+	"time"
+)
+
+func TestAuthenticateLockout(t *testing.T) {
+	us, err := testingUserService()
+	if err != nil {
+		t.Fatal(err)
+	}
+	user := User{Email: "stanley@dundermifflin.com", Password: "pretzelday"}
+	if err := us.Create(&user); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < LockoutFree; i++ {
+		if _, err := us.Authenticate(user.Email, "crosswords"); err != ErrCredentialsInvalid {
+			t.Fatalf("Expected ErrCredentialsInvalid. Received %v", err)
+		}
+	}
+	// Still not locked, the right password works and clears the count
+	found, err := us.Authenticate(user.Email, "pretzelday")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if found.FailedLogins != 0 {
+		t.Errorf("Expected FailedLogins to be reset. Received %d", found.FailedLogins)
+	}
+
+	for i := 0; i <= LockoutFree; i++ {
+		us.Authenticate(user.Email, "crosswords")
+	}
+	// Locked now, even the right password is rejected the same way
+	// as an unknown account
+	if _, err := us.Authenticate(user.Email, "pretzelday"); err != ErrCredentialsInvalid {
+		t.Fatalf("Expected ErrCredentialsInvalid. Received %v", err)
+	}
+	if err := us.ChangePassword(user.ID, "pretzelday", "pretzeltime"); err != ErrTooManyAttempts {
+		t.Errorf("Expected ErrTooManyAttempts changing the password. Received %v", err)
+	}
+	locked, err := us.ByID(user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if wait := time.Until(*locked.LockedUntil); wait <= 0 || wait > LockoutBase {
+		t.Errorf("Expected a lock of up to %s. Received %s", LockoutBase, wait)
+	}
+
+	past := time.Now().Add(-time.Second)
+	locked.LockedUntil = &past
+	if err := us.Update(locked); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := us.Authenticate(user.Email, "pretzelday"); err != nil {
+		t.Errorf("Expected the lock to expire. Received %v", err)
+	}
+}
@@ -0,0 +1,281 @@
+package models
+
+import (
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"../hash"
+	"../rand"
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+var (
+	// ErrPasswordHashUnknown is returned when a stored password hash
+	// wasn't made by any of the supported algorithms
+	ErrPasswordHashUnknown error = privateError("models: password hash algorithm is not supported")
+
+	// ErrBcryptCostInvalid is returned by NewBcryptHasher for costs
+	// bcrypt doesn't support
+	ErrBcryptCostInvalid = fmt.Errorf("models: bcrypt cost must be between %d and %d", bcrypt.MinCost, bcrypt.MaxCost)
+)
+
+// PasswordHasher hashes passwords with one algorithm. Every hash
+// starts with an identifier for the algorithm and includes the
+// parameters it was made with, so the same algorithm with other
+// parameters can still check it.
+type PasswordHasher interface {
+	// Hash hashes password with a new random salt
+	Hash(password string) (string, error)
+
+	// Compare reports whether password matches hash, which has to
+	// be one this algorithm Handles
+	Compare(hash, password string) (bool, error)
+
+	// Handles reports whether hash was made by this algorithm
+	Handles(hash string) bool
+
+	// NeedsRehash reports whether hash, which this algorithm
+	// Handles, was made with different parameters
+	NeedsRehash(hash string) bool
+}
+
+// BcryptHasher hashes passwords with bcrypt. Note that bcrypt only
+// uses the first 72 bytes of a password.
+type BcryptHasher struct {
+	Cost int
+}
+
+var _ PasswordHasher = &BcryptHasher{}
+
+// NewBcryptHasher returns a BcryptHasher with the provided cost
+func NewBcryptHasher(cost int) (*BcryptHasher, error) {
+	if cost < bcrypt.MinCost || cost > bcrypt.MaxCost {
+		return nil, ErrBcryptCostInvalid
+	}
+	return &BcryptHasher{Cost: cost}, nil
+}
+
+func (bh *BcryptHasher) Hash(password string) (string, error) {
+	b, err := bcrypt.GenerateFromPassword([]byte(password), bh.Cost)
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+func (bh *BcryptHasher) Compare(hash, password string) (bool, error) {
+	err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
+	if err == bcrypt.ErrMismatchedHashAndPassword {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (bh *BcryptHasher) Handles(hash string) bool {
+	return strings.HasPrefix(hash, "$2a$") ||
+		strings.HasPrefix(hash, "$2b$") ||
+		strings.HasPrefix(hash, "$2y$")
+}
+
+func (bh *BcryptHasher) NeedsRehash(hash string) bool {
+	cost, err := bcrypt.Cost([]byte(hash))
+	return err != nil || cost != bh.Cost
+}
+
+// Argon2idHasher hashes passwords with argon2id. Hashes use the
+// PHC string format:
+//
+//	$argon2id$v=19$m=65536,t=3,p=2$<salt>$<key>
+//
+// Memory is in KiB.
+type Argon2idHasher struct {
+	Time    uint32
+	Memory  uint32
+	Threads uint8
+}
+
+var _ PasswordHasher = &Argon2idHasher{}
+
+const (
+	argon2idPrefix  = "$argon2id$"
+	argon2SaltBytes = 16
+	argon2KeyBytes  = 32
+)
+
+// NewArgon2idHasher returns an Argon2idHasher with the provided
+// parameters, memory is in KiB
+func NewArgon2idHasher(time, memory uint32, threads uint8) *Argon2idHasher {
+	return &Argon2idHasher{
+		Time:    time,
+		Memory:  memory,
+		Threads: threads,
+	}
+}
+
+func (ah *Argon2idHasher) Hash(password string) (string, error) {
+	salt, err := rand.Bytes(argon2SaltBytes)
+	if err != nil {
+		return "", err
+	}
+	key := argon2.IDKey([]byte(password), salt, ah.Time, ah.Memory, ah.Threads, argon2KeyBytes)
+	return fmt.Sprintf("%sv=%d$m=%d,t=%d,p=%d$%s$%s", argon2idPrefix, argon2.Version,
+		ah.Memory, ah.Time, ah.Threads,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(key)), nil
+}
+
+func (ah *Argon2idHasher) Compare(hash, password string) (bool, error) {
+	params, salt, key, err := parseArgon2id(hash)
+	if err != nil {
+		return false, err
+	}
+	other := argon2.IDKey([]byte(password), salt, params.Time, params.Memory, params.Threads, uint32(len(key)))
+	return subtle.ConstantTimeCompare(key, other) == 1, nil
+}
+
+func (ah *Argon2idHasher) Handles(hash string) bool {
+	return strings.HasPrefix(hash, argon2idPrefix)
+}
+
+func (ah *Argon2idHasher) NeedsRehash(hash string) bool {
+	params, _, key, err := parseArgon2id(hash)
+	return err != nil || *params != *ah || len(key) != argon2KeyBytes
+}
+
+var errArgon2idHashInvalid = errors.New("models: argon2id hash is not valid")
+
+// parseArgon2id splits a hash made by Argon2idHasher.Hash
+func parseArgon2id(hash string) (*Argon2idHasher, []byte, []byte, error) {
+	parts := strings.Split(hash, "$")
+	if len(parts) != 6 || parts[1] != "argon2id" {
+		return nil, nil, nil, errArgon2idHashInvalid
+	}
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return nil, nil, nil, errArgon2idHashInvalid
+	}
+	var params Argon2idHasher
+	_, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &params.Memory, &params.Time, &params.Threads)
+	if err != nil {
+		return nil, nil, nil, errArgon2idHashInvalid
+	}
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return nil, nil, nil, errArgon2idHashInvalid
+	}
+	key, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil || len(key) == 0 {
+		return nil, nil, nil, errArgon2idHashInvalid
+	}
+	return &params, salt, key, nil
+}
+
+// passwordHashers can check every hash, whatever it was made with.
+// Their parameters don't matter for Compare.
+var passwordHashers = []PasswordHasher{
+	&BcryptHasher{Cost: bcrypt.DefaultCost},
+	&Argon2idHasher{},
+}
+
+// passwords hashes and checks user passwords with a pepper. New
+// hashes use hasher and the current pepper, old ones can still be
+// checked if they were made with any supported algorithm or one of
+// the previous peppers. A pepper with an ID puts it in front of the
+// hash, "<id>:<hash>", so only that pepper has to be tried.
+type passwords struct {
+	hasher  PasswordHasher
+	peppers hash.Keyring
+
+	dummyOnce sync.Once
+	dummy     string
+}
+
+func newPasswords(hasher PasswordHasher, peppers hash.Keyring) *passwords {
+	if hasher == nil {
+		hasher = &BcryptHasher{Cost: bcrypt.DefaultCost}
+	}
+	return &passwords{
+		hasher:  hasher,
+		peppers: peppers,
+	}
+}
+
+// hash adds the current pepper to password and hashes it
+func (p *passwords) hash(password string) (string, error) {
+	pepper := p.peppers.Current()
+	h, err := p.hasher.Hash(password + pepper.Secret)
+	if err != nil || pepper.ID == "" {
+		return h, err
+	}
+	return pepper.ID + ":" + h, nil
+}
+
+// check reports whether password matches stored, and if so whether
+// it should be replaced because it was made with another algorithm,
+// other parameters or an old pepper. A hash without a pepper ID is
+// checked with every pepper until one matches.
+func (p *passwords) check(stored, password string) (ok, rehash bool, err error) {
+	id, hash, hasID := splitPepperID(stored)
+	h := p.hasherFor(hash)
+	if h == nil {
+		return false, false, ErrPasswordHashUnknown
+	}
+	current := p.peppers.Current()
+	for i, pepper := range p.peppers.Keys() {
+		if hasID && pepper.ID != id {
+			continue
+		}
+		ok, err := h.Compare(hash, password+pepper.Secret)
+		if err != nil {
+			return false, false, err
+		}
+		if ok {
+			rehash := i > 0 || hasID != (current.ID != "") ||
+				h != p.hasher || p.hasher.NeedsRehash(hash)
+			return true, rehash, nil
+		}
+	}
+	return false, false, nil
+}
+
+// splitPepperID splits the pepper ID off a stored password hash.
+// Hashes made by the hashers themselves all start with "$".
+func splitPepperID(stored string) (id, hash string, ok bool) {
+	if strings.HasPrefix(stored, "$") {
+		return "", stored, false
+	}
+	i := strings.Index(stored, ":")
+	if i < 0 {
+		return "", stored, false
+	}
+	return stored[:i], stored[i+1:], true
+}
+
+// checkDummy takes as long as checking a real password, so an
+// unknown email address can't be told apart by the response time
+func (p *passwords) checkDummy(password string) {
+	p.dummyOnce.Do(func() {
+		p.dummy, _ = p.hash("not-a-real-password")
+	})
+	if p.dummy != "" {
+		_, hash, _ := splitPepperID(p.dummy)
+		p.hasher.Compare(hash, password+p.peppers.Current().Secret)
+	}
+}
+
+func (p *passwords) hasherFor(hash string) PasswordHasher {
+	if p.hasher.Handles(hash) {
+		return p.hasher
+	}
+	for _, h := range passwordHashers {
+		if h.Handles(hash) {
+			return h
+		}
+	}
+	return nil
+}
@@ -0,0 +1,96 @@
+package models
+
+import (
+	"strings"
+	"testing"
+
+	"../hash"
+)
+
+func TestPasswordHashers(t *testing.T) {
+	bcrypt4, err := NewBcryptHasher(4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hashers := []PasswordHasher{bcrypt4, NewArgon2idHasher(1, 1024, 1)}
+	for _, h := range hashers {
+		hash, err := h.Hash("hunter2hunter2")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !h.Handles(hash) {
+			t.Errorf("%T: Expected to handle %s", h, hash)
+		}
+		if ok, err := h.Compare(hash, "hunter2hunter2"); !ok || err != nil {
+			t.Errorf("%T: Expected the password to match. Received %v, %v", h, ok, err)
+		}
+		if ok, err := h.Compare(hash, "hunter3hunter3"); ok || err != nil {
+			t.Errorf("%T: Expected a wrong password not to match. Received %v, %v", h, ok, err)
+		}
+		if h.NeedsRehash(hash) {
+			t.Errorf("%T: Expected no rehash with the same parameters", h)
+		}
+	}
+
+	argonHash, _ := hashers[1].Hash("hunter2hunter2")
+	if !NewArgon2idHasher(2, 1024, 1).NeedsRehash(argonHash) {
+		t.Error("Expected a rehash for a different argon2id time")
+	}
+	if _, err := NewBcryptHasher(64); err != ErrBcryptCostInvalid {
+		t.Errorf("Expected ErrBcryptCostInvalid. Received %v", err)
+	}
+}
+
+func TestAuthenticateRehash(t *testing.T) {
+	bcrypt4, _ := NewBcryptHasher(4)
+	old, err := NewServices(
+		WithMemory(),
+		WithUser("old-pepper", testHMACKey),
+		WithPasswordHasher(bcrypt4),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	user := User{Email: "toby@dundermifflin.com", Password: "costarica"}
+	if err := old.User.Create(&user); err != nil {
+		t.Fatal(err)
+	}
+	stored, err := old.User.ByID(user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s, err := NewServices(
+		WithMemory(),
+		WithUser(testPepper, testHMACKey),
+		WithPeppers(hash.Key{ID: "2", Secret: testPepper}, hash.Key{Secret: "old-pepper"}),
+		WithPasswordHasher(NewArgon2idHasher(1, 1024, 1)),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Skip the validator so the old hash is stored as it is
+	udb := s.User.(*userService).UserDB.(*userValidator).UserDB
+	if err := udb.Create(stored); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := s.User.Authenticate(user.Email, "wrongpassword"); err != ErrCredentialsInvalid {
+		t.Errorf("Expected ErrCredentialsInvalid. Received %v", err)
+	}
+	if _, err := s.User.Authenticate(user.Email, "costarica"); err != nil {
+		t.Fatal(err)
+	}
+	upgraded, err := s.User.ByID(user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(upgraded.PasswordHash, "2:$argon2id$") {
+		t.Errorf("Expected an argon2id hash. Received %s", upgraded.PasswordHash)
+	}
+	// The new hash uses the current pepper and says which it is
+	ok, rehash, err := s.User.(*userService).pw.check(upgraded.PasswordHash, "costarica")
+	if !ok || rehash || err != nil {
+		t.Errorf("Expected an up to date hash. Received %v, %v, %v", ok, rehash, err)
+	}
+}
@@ -0,0 +1,191 @@
+package models
+
+import (
+	"time"
+)
+
+// UserExport is everything stored about a user, in the form they
+// download it. The password hash, the TOTP secret and the hashes of
+// tokens and recovery codes are left out, they are of no use to the
+// user and only help someone guessing them. It has no audit events
+// because DataBot doesn't keep an audit log, the sessions with
+// their last seen times are the closest thing there is.
+type UserExport struct {
+	ExportedAt  time.Time          `json:"exported_at"`
+	User        ExportedUser       `json:"user"`
+	Permissions []string           `json:"permissions"`
+	Sessions    []ExportedSession  `json:"sessions"`
+	Identities  []ExportedIdentity `json:"identities"`
+	APIKeys     []ExportedAPIKey   `json:"api_keys"`
+}
+
+// ExportedUser is every field of User but the hashes and secrets
+type ExportedUser struct {
+	ID                    uint       `json:"id"`
+	Name                  string     `json:"name"`
+	Email                 string     `json:"email"`
+	EmailVerifiedAt       *time.Time `json:"email_verified_at"`
+	PendingEmail          string     `json:"pending_email"`
+	Role                  string     `json:"role"`
+	NoPassword            bool       `json:"no_password"`
+	PasswordResetRequired bool       `json:"password_reset_required"`
+	FailedLogins          int        `json:"failed_logins"`
+	LockedUntil           *time.Time `json:"locked_until"`
+	TOTPEnabledAt         *time.Time `json:"totp_enabled_at"`
+	TOTPLastStep          int64      `json:"totp_last_step"`
+	DisabledAt            *time.Time `json:"disabled_at"`
+	CreatedAt             time.Time  `json:"created_at"`
+	UpdatedAt             time.Time  `json:"updated_at"`
+}
+
+// ExportedSession is a Session without its token hash
+type ExportedSession struct {
+	UserAgent  string    `json:"user_agent"`
+	IP         string    `json:"ip"`
+	CreatedAt  time.Time `json:"created_at"`
+	LastSeenAt time.Time `json:"last_seen_at"`
+	ExpiresAt  time.Time `json:"expires_at"`
+}
+
+// ExportedIdentity is a single sign-on account linked to the user
+type ExportedIdentity struct {
+	Issuer    string    `json:"issuer"`
+	Subject   string    `json:"subject"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// ExportedAPIKey is an APIKey without its hash
+type ExportedAPIKey struct {
+	Name       string     `json:"name"`
+	Hint       string     `json:"hint"`
+	Scopes     []string   `json:"scopes"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	ExpiresAt  *time.Time `json:"expires_at"`
+	CreatedAt  time.Time  `json:"created_at"`
+}
+
+// Export collects everything stored about the user
+func (us *userService) Export(userID uint) (*UserExport, error) {
+	user, err := us.ByID(userID)
+	if err != nil {
+		return nil, err
+	}
+	perms, err := us.Permissions(user)
+	if err != nil {
+		return nil, err
+	}
+	sessions, err := us.sessions.ByUserID(user.ID)
+	if err != nil {
+		return nil, err
+	}
+	idents, err := us.identityDB.ByUserID(user.ID)
+	if err != nil {
+		return nil, err
+	}
+	keys, err := us.apiKeyDB.ByUserID(user.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	export := UserExport{
+		ExportedAt: time.Now(),
+		User: ExportedUser{
+			ID:                    user.ID,
+			Name:                  user.Name,
+			Email:                 user.Email,
+			EmailVerifiedAt:       user.EmailVerifiedAt,
+			PendingEmail:          user.PendingEmail,
+			Role:                  user.Role,
+			NoPassword:            user.NoPassword,
+			PasswordResetRequired: user.PasswordResetRequired,
+			FailedLogins:          user.FailedLogins,
+			LockedUntil:           user.LockedUntil,
+			TOTPEnabledAt:         user.TOTPEnabledAt,
+			TOTPLastStep:          user.TOTPLastStep,
+			DisabledAt:            user.DisabledAt,
+			CreatedAt:             user.CreatedAt,
+			UpdatedAt:             user.UpdatedAt,
+		},
+		Permissions: perms,
+		Sessions:    []ExportedSession{},
+		Identities:  []ExportedIdentity{},
+		APIKeys:     []ExportedAPIKey{},
+	}
+	for _, s := range sessions {
+		export.Sessions = append(export.Sessions, ExportedSession{
+			UserAgent:  s.UserAgent,
+			IP:         s.IP,
+			CreatedAt:  s.CreatedAt,
+			LastSeenAt: s.LastSeenAt,
+			ExpiresAt:  s.ExpiresAt,
+		})
+	}
+	for _, ident := range idents {
+		export.Identities = append(export.Identities, ExportedIdentity{
+			Issuer:    ident.Issuer,
+			Subject:   ident.Subject,
+			CreatedAt: ident.CreatedAt,
+		})
+	}
+	for _, k := range keys {
+		export.APIKeys = append(export.APIKeys, ExportedAPIKey{
+			Name:       k.Name,
+			Hint:       k.Hint,
+			Scopes:     k.ScopeList(),
+			LastUsedAt: k.LastUsedAt,
+			ExpiresAt:  k.ExpiresAt,
+			CreatedAt:  k.CreatedAt,
+		})
+	}
+	return &export, nil
+}
+
+// DeleteAccount signs the user out everywhere and deletes them.
+// Delete only sets DeletedAt, so nobody can log in to the account
+// but it can still be restored by hand until PurgeDeleted removes
+// it for good.
+func (us *userService) DeleteAccount(userID uint) error {
+	if err := us.LogoutAll(userID); err != nil {
+		return err
+	}
+	if err := us.identityDB.DeleteByUserID(userID); err != nil {
+		return err
+	}
+	return us.Delete(userID)
+}
+
+// PurgeDeleted removes the users that were deleted before t for
+// good, with everything else stored for them, and returns how many
+// it removed
+func (us *userService) PurgeDeleted(before time.Time) (int, error) {
+	users, err := us.DeletedBefore(before)
+	if err != nil {
+		return 0, err
+	}
+	for i, user := range users {
+		if err := us.purge(user.ID); err != nil {
+			return i, err
+		}
+	}
+	return len(users), nil
+}
+
+// purge deletes everything that belongs to the user before the
+// user, so a failure part way is picked up again by the next run
+func (us *userService) purge(userID uint) error {
+	deletes := []func(uint) error{
+		us.sessions.DeleteByUserID,
+		us.pwResetDB.DeleteByUserID,
+		us.recoveryCodeDB.DeleteByUserID,
+		us.identityDB.DeleteByUserID,
+		us.grantDB.DeleteByUserID,
+		us.apiKeyDB.DeleteByUserID,
+		us.Purge,
+	}
+	for _, fn := range deletes {
+		if err := fn(userID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
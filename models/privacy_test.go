@@ -0,0 +1,147 @@
+package models
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestExport(t *testing.T) {
+	s, err := testingServices()
+	if err != nil {
+		t.Fatal(err)
+	}
+	user := User{Name: "Oscar", Email: "oscar@dundermifflin.com", Password: "accountant"}
+	if err := s.User.Create(&user); err != nil {
+		t.Fatal(err)
+	}
+	session := Session{UserID: user.ID, UserAgent: "curl", IP: "127.0.0.1"}
+	if err := s.Session.Create(&session); err != nil {
+		t.Fatal(err)
+	}
+	key := APIKey{UserID: user.ID, Name: "spreadsheets", Scopes: ScopeRead}
+	if err := s.APIKey.Create(&key); err != nil {
+		t.Fatal(err)
+	}
+	stored, err := s.User.ByID(user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	export, err := s.User.Export(user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if export.User.Email != user.Email || export.User.Role != RoleMember {
+		t.Errorf("Expected the user's details. Received %+v", export.User)
+	}
+	if len(export.Sessions) != 1 || export.Sessions[0].UserAgent != "curl" {
+		t.Errorf("Expected the session. Received %+v", export.Sessions)
+	}
+	if len(export.APIKeys) != 1 || export.APIKeys[0].Name != "spreadsheets" {
+		t.Errorf("Expected the API key. Received %+v", export.APIKeys)
+	}
+	if len(export.Permissions) == 0 {
+		t.Errorf("Expected the member permissions")
+	}
+
+	b, err := json.Marshal(export)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, secret := range map[string]string{
+		"password hash": stored.PasswordHash,
+		"session token": session.Token,
+		"API key":       key.Key,
+	} {
+		if strings.Contains(string(b), secret) {
+			t.Errorf("Expected the %s to be left out", name)
+		}
+	}
+}
+
+func TestDeleteAccount(t *testing.T) {
+	s, err := testingServices()
+	if err != nil {
+		t.Fatal(err)
+	}
+	us := s.User
+	user := User{Name: "Toby", Email: "toby@dundermifflin.com", Password: "costa-rica"}
+	if err := us.Create(&user); err != nil {
+		t.Fatal(err)
+	}
+	session := Session{UserID: user.ID}
+	if err := s.Session.Create(&session); err != nil {
+		t.Fatal(err)
+	}
+	key := APIKey{UserID: user.ID, Name: "hr", Scopes: ScopeRead}
+	if err := s.APIKey.Create(&key); err != nil {
+		t.Fatal(err)
+	}
+	if err := us.GrantPermission(user.ID, PermUsersRead); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := us.DeleteAccount(user.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := us.ByID(user.ID); err != ErrNotFound {
+		t.Errorf("Expected ErrNotFound. Received %v", err)
+	}
+	if _, err := us.ByRemember(session.Token); err != ErrNotFound {
+		t.Errorf("Expected the session to be ended. Received %v", err)
+	}
+
+	// Still within the grace period
+	n, err := us.PurgeDeleted(time.Now().Add(-time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 0 {
+		t.Errorf("Expected nothing to be purged. Received %d", n)
+	}
+	if deleted, _ := us.DeletedBefore(time.Now()); len(deleted) != 1 {
+		t.Fatalf("Expected the user to be kept. Received %v", deleted)
+	}
+	// The address stays taken until the user is purged
+	taken := User{Email: "Toby@DunderMifflin.com", Password: "costa-rica"}
+	if err := us.Create(&taken); err != ErrEmailTaken {
+		t.Errorf("Expected ErrEmailTaken signing up. Received %v", err)
+	}
+	other := User{Email: "holly@dundermifflin.com", Password: "nard-dog"}
+	if err := us.Create(&other); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := us.UpdateProfile(other.ID, "Holly", user.Email); err != ErrEmailTaken {
+		t.Errorf("Expected ErrEmailTaken changing the address. Received %v", err)
+	}
+	login := ExternalLogin{Issuer: "https://id.example.com", Subject: "toby", Email: user.Email, EmailVerified: true}
+	if _, err := us.LoginExternal(login); err != ErrAccountDisabled {
+		t.Errorf("Expected ErrAccountDisabled with single sign-on. Received %v", err)
+	}
+
+	n, err = us.PurgeDeleted(time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 {
+		t.Errorf("Expected 1 user to be purged. Received %d", n)
+	}
+	if deleted, _ := us.DeletedBefore(time.Now()); len(deleted) != 0 {
+		t.Errorf("Expected the user to be gone. Received %v", deleted)
+	}
+	if keys, _ := s.APIKey.ByUserID(user.ID); len(keys) != 0 {
+		t.Errorf("Expected the API keys to be gone. Received %v", keys)
+	}
+	grants, _ := us.(*userService).grantDB.ByUserID(user.ID)
+	if len(grants) != 0 {
+		t.Errorf("Expected the permission grants to be gone. Received %v", grants)
+	}
+
+	// The address can be used again
+	again := User{Name: "Toby", Email: "toby@dundermifflin.com", Password: "costa-rica"}
+	if err := us.Create(&again); err != nil {
+		t.Errorf("Expected the email address to be free. Received %v", err)
+	}
+}
@@ -0,0 +1,182 @@
+package models
+
+import (
+	"net/http"
+	"time"
+
+	"../hash"
+	"../rand"
+	"github.com/jinzhu/gorm"
+)
+
+var (
+	// ErrTokenInvalid is returned when a password reset token
+	// doesn't exist, was already used or has expired
+	ErrTokenInvalid = newPublicError("models: token provided is not valid",
+		"That link is invalid or has expired. Please request a new one.", http.StatusBadRequest)
+)
+
+// pwResetDuration is how long a password reset token can be used
+const pwResetDuration = 12 * time.Hour
+
+// pwReset is a single-use token that lets a user set a new
+// password without knowing the old one
+type pwReset struct {
+	gorm.Model
+	UserID    uint      `gorm:"not null"`
+	Token     string    `gorm:"-"`
+	TokenHash string    `gorm:"not null;unique_index"`
+	ExpiresAt time.Time `gorm:"not null"`
+}
+
+// Expired reports whether the token can no longer be used
+func (pwr *pwReset) Expired() bool {
+	return !time.Now().Before(pwr.ExpiresAt)
+}
+
+// pwResetDB is used to interact with the pw_resets table.
+//
+// ByToken expects the raw token at the validation layer and the
+// hashed token at the database layer.
+type pwResetDB interface {
+	ByToken(token string) (*pwReset, error)
+	Create(pwr *pwReset) error
+	Delete(id uint) error
+	DeleteByUserID(userID uint) error
+}
+
+type pwResetValFunc func(*pwReset) error
+
+func runPwResetValFuncs(pwr *pwReset, fns ...pwResetValFunc) error {
+	for _, fn := range fns {
+		if err := fn(pwr); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+var _ pwResetDB = &pwResetValidator{}
+
+func newPwResetValidator(db pwResetDB, hmac hash.Keyring) *pwResetValidator {
+	return &pwResetValidator{
+		pwResetDB: db,
+		hmac:      hmac,
+	}
+}
+
+type pwResetValidator struct {
+	pwResetDB
+	hmac hash.Keyring
+}
+
+// ByToken hashes the token before looking it up, with every HMAC
+// key since tokens made before a key rotation are still valid.
+// Expired tokens are treated as if they don't exist.
+func (pwrv *pwResetValidator) ByToken(token string) (*pwReset, error) {
+	if token == "" {
+		return nil, ErrNotFound
+	}
+	var found *pwReset
+	_, err := findHashed(pwrv.hmac, token, func(hash string) (err error) {
+		found, err = pwrv.pwResetDB.ByToken(hash)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	if found.Expired() {
+		return nil, ErrNotFound
+	}
+	return found, nil
+}
+
+func (pwrv *pwResetValidator) Create(pwr *pwReset) error {
+	err := runPwResetValFuncs(pwr,
+		pwrv.requireUserID,
+		pwrv.setTokenIfUnset,
+		pwrv.hmacToken,
+		pwrv.setExpiresIfUnset)
+	if err != nil {
+		return err
+	}
+	return pwrv.pwResetDB.Create(pwr)
+}
+
+func (pwrv *pwResetValidator) Delete(id uint) error {
+	if id <= 0 {
+		return ErrIDInvalid
+	}
+	return pwrv.pwResetDB.Delete(id)
+}
+
+func (pwrv *pwResetValidator) DeleteByUserID(userID uint) error {
+	if userID <= 0 {
+		return ErrIDInvalid
+	}
+	return pwrv.pwResetDB.DeleteByUserID(userID)
+}
+
+func (pwrv *pwResetValidator) requireUserID(pwr *pwReset) error {
+	if pwr.UserID <= 0 {
+		return ErrUserIDRequired
+	}
+	return nil
+}
+
+func (pwrv *pwResetValidator) setTokenIfUnset(pwr *pwReset) error {
+	if pwr.Token != "" {
+		return nil
+	}
+	token, err := rand.Reset.New()
+	if err != nil {
+		return err
+	}
+	pwr.Token = token
+	return nil
+}
+
+func (pwrv *pwResetValidator) hmacToken(pwr *pwReset) error {
+	if pwr.Token == "" {
+		return nil
+	}
+	pwr.TokenHash = pwrv.hmac.Hash(pwr.Token)
+	return nil
+}
+
+func (pwrv *pwResetValidator) setExpiresIfUnset(pwr *pwReset) error {
+	if pwr.ExpiresAt.IsZero() {
+		pwr.ExpiresAt = time.Now().Add(pwResetDuration)
+	}
+	return nil
+}
+
+var _ pwResetDB = &pwResetGorm{}
+
+type pwResetGorm struct {
+	db *gorm.DB
+}
+
+func (pwrg *pwResetGorm) ByToken(tokenHash string) (*pwReset, error) {
+	var pwr pwReset
+	err := first(pwrg.db.Where("token_hash = ?", tokenHash), &pwr)
+	if err != nil {
+		return nil, err
+	}
+	return &pwr, nil
+}
+
+func (pwrg *pwResetGorm) Create(pwr *pwReset) error {
+	return pwrg.db.Create(pwr).Error
+}
+
+// Delete removes the token for good. A soft delete would leave the
+// hash in the unique index for no reason.
+func (pwrg *pwResetGorm) Delete(id uint) error {
+	pwr := pwReset{Model: gorm.Model{ID: id}}
+	return pwrg.db.Unscoped().Delete(&pwr).Error
+}
+
+func (pwrg *pwResetGorm) DeleteByUserID(userID uint) error {
+	return pwrg.db.Unscoped().Where("user_id = ?", userID).Delete(&pwReset{}).Error
+}
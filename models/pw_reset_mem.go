@@ -0,0 +1,78 @@
+package models
+
+import (
+	"sync"
+	"time"
+)
+
+var _ pwResetDB = &pwResetMem{}
+
+func newPwResetMem() *pwResetMem {
+	return &pwResetMem{
+		resets: make(map[uint]pwReset),
+	}
+}
+
+// pwResetMem is the in-memory pwResetDB used alongside userMem
+type pwResetMem struct {
+	mu     sync.RWMutex
+	resets map[uint]pwReset
+	lastID uint
+}
+
+func (pwrm *pwResetMem) ByToken(tokenHash string) (*pwReset, error) {
+	pwrm.mu.RLock()
+	defer pwrm.mu.RUnlock()
+	for _, pwr := range pwrm.resets {
+		if pwr.TokenHash == tokenHash {
+			found := pwr
+			return &found, nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+func (pwrm *pwResetMem) Create(pwr *pwReset) error {
+	pwrm.mu.Lock()
+	defer pwrm.mu.Unlock()
+	for _, existing := range pwrm.resets {
+		if existing.TokenHash == pwr.TokenHash {
+			return errMemDuplicate
+		}
+	}
+	pwrm.lastID++
+	pwr.ID = pwrm.lastID
+	now := time.Now()
+	pwr.CreatedAt = now
+	pwr.UpdatedAt = now
+
+	stored := *pwr
+	stored.Token = ""
+	pwrm.resets[pwr.ID] = stored
+	return nil
+}
+
+func (pwrm *pwResetMem) Delete(id uint) error {
+	pwrm.mu.Lock()
+	defer pwrm.mu.Unlock()
+	delete(pwrm.resets, id)
+	return nil
+}
+
+func (pwrm *pwResetMem) DeleteByUserID(userID uint) error {
+	pwrm.mu.Lock()
+	defer pwrm.mu.Unlock()
+	for id, pwr := range pwrm.resets {
+		if pwr.UserID == userID {
+			delete(pwrm.resets, id)
+		}
+	}
+	return nil
+}
+
+func (pwrm *pwResetMem) reset() {
+	pwrm.mu.Lock()
+	defer pwrm.mu.Unlock()
+	pwrm.resets = make(map[uint]pwReset)
+	pwrm.lastID = 0
+}
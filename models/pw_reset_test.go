@@ -0,0 +1,81 @@
+package models
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPasswordReset(t *testing.T) {
+	s, err := testingServices()
+	if err != nil {
+		t.Fatal(err)
+	}
+	user := User{Email: "creed@dundermifflin.com", Password: "mungbeans"}
+	if err := s.User.Create(&user); err != nil {
+		t.Fatal(err)
+	}
+	session := Session{UserID: user.ID}
+	if err := s.Session.Create(&session); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, _, err := s.User.InitiateReset("nobody@dundermifflin.com"); err != ErrNotFound {
+		t.Errorf("Expected ErrNotFound. Received %v", err)
+	}
+	found, token, err := s.User.InitiateReset("Creed@DunderMifflin.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The link goes to the stored address, not what was typed in
+	if found.Email != user.Email {
+		t.Errorf("Expected %s. Received %s", user.Email, found.Email)
+	}
+	// Asking twice sends a second link
+	_, other, err := s.User.InitiateReset(user.Email)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.User.CompleteReset(token, "short"); err != ErrPasswordTooShort {
+		t.Errorf("Expected ErrPasswordTooShort. Received %v", err)
+	}
+	if _, err := s.User.CompleteReset(token, "quabityassurance"); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := s.User.Authenticate(user.Email, "quabityassurance"); err != nil {
+		t.Errorf("Expected the new password to work. Received %v", err)
+	}
+	if _, err := s.User.Authenticate(user.Email, "mungbeans"); err != ErrCredentialsInvalid {
+		t.Errorf("Expected the old password to be rejected. Received %v", err)
+	}
+	if _, err := s.User.ByRemember(session.Token); err != ErrNotFound {
+		t.Errorf("Expected existing sessions to be revoked. Received %v", err)
+	}
+	// Tokens are single use
+	if _, err := s.User.CompleteReset(token, "anotherpassword"); err != ErrTokenInvalid {
+		t.Errorf("Expected ErrTokenInvalid for a used token. Received %v", err)
+	}
+	// Resetting uses up every link the user was sent
+	if _, err := s.User.CompleteReset(other, "anotherpassword"); err != ErrTokenInvalid {
+		t.Errorf("Expected ErrTokenInvalid for the other token. Received %v", err)
+	}
+}
+
+func TestPasswordResetExpired(t *testing.T) {
+	s, err := testingServices()
+	if err != nil {
+		t.Fatal(err)
+	}
+	user := User{Email: "meredith@dundermifflin.com", Password: "vanparty"}
+	if err := s.User.Create(&user); err != nil {
+		t.Fatal(err)
+	}
+	us := s.User.(*userService)
+	pwr := pwReset{UserID: user.ID, ExpiresAt: time.Now().Add(-time.Minute)}
+	if err := us.pwResetDB.Create(&pwr); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.User.CompleteReset(pwr.Token, "vanpartyagain"); err != ErrTokenInvalid {
+		t.Errorf("Expected ErrTokenInvalid for an expired token. Received %v", err)
+	}
+}
@@ -0,0 +1,163 @@
+package models
+
+import (
+	"strings"
+	"time"
+
+	"../hash"
+	"github.com/jinzhu/gorm"
+)
+
+// recoveryCodeCount is how many recovery codes a user gets when
+// they turn on two-factor authentication
+const recoveryCodeCount = 10
+
+// recoveryCode lets a user through the second login step once when
+// they don't have their authenticator app
+type recoveryCode struct {
+	ID        uint
+	UserID    uint   `gorm:"not null;index"`
+	Code      string `gorm:"-"`
+	CodeHash  string `gorm:"not null;unique_index"`
+	CreatedAt time.Time
+}
+
+// recoveryCodeDB is used to interact with the recovery_codes table.
+//
+// ByCode expects the raw code at the validation layer and the
+// hashed code at the database layer.
+type recoveryCodeDB interface {
+	ByCode(userID uint, code string) (*recoveryCode, error)
+	Create(rc *recoveryCode) error
+	Delete(id uint) error
+	DeleteByUserID(userID uint) error
+}
+
+type recoveryCodeValFunc func(*recoveryCode) error
+
+func runRecoveryCodeValFuncs(rc *recoveryCode, fns ...recoveryCodeValFunc) error {
+	for _, fn := range fns {
+		if err := fn(rc); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+var _ recoveryCodeDB = &recoveryCodeValidator{}
+
+func newRecoveryCodeValidator(db recoveryCodeDB, hmac hash.Keyring) *recoveryCodeValidator {
+	return &recoveryCodeValidator{
+		recoveryCodeDB: db,
+		hmac:           hmac,
+	}
+}
+
+type recoveryCodeValidator struct {
+	recoveryCodeDB
+	hmac hash.Keyring
+}
+
+// ByCode hashes the code before looking it up, with every HMAC key
+// since codes made before a key rotation are still valid
+func (rcv *recoveryCodeValidator) ByCode(userID uint, code string) (*recoveryCode, error) {
+	rc := recoveryCode{UserID: userID, Code: code}
+	err := runRecoveryCodeValFuncs(&rc,
+		rcv.normalizeCode,
+		rcv.hmacCode,
+		rcv.codeHashRequired)
+	if err != nil {
+		return nil, err
+	}
+	var found *recoveryCode
+	_, err = findHashed(rcv.hmac, rc.Code, func(hash string) (err error) {
+		found, err = rcv.recoveryCodeDB.ByCode(rc.UserID, hash)
+		return err
+	})
+	return found, err
+}
+
+func (rcv *recoveryCodeValidator) Create(rc *recoveryCode) error {
+	err := runRecoveryCodeValFuncs(rc,
+		rcv.requireUserID,
+		rcv.normalizeCode,
+		rcv.hmacCode,
+		rcv.codeHashRequired)
+	if err != nil {
+		return err
+	}
+	return rcv.recoveryCodeDB.Create(rc)
+}
+
+func (rcv *recoveryCodeValidator) Delete(id uint) error {
+	if id <= 0 {
+		return ErrIDInvalid
+	}
+	return rcv.recoveryCodeDB.Delete(id)
+}
+
+func (rcv *recoveryCodeValidator) DeleteByUserID(userID uint) error {
+	if userID <= 0 {
+		return ErrIDInvalid
+	}
+	return rcv.recoveryCodeDB.DeleteByUserID(userID)
+}
+
+func (rcv *recoveryCodeValidator) requireUserID(rc *recoveryCode) error {
+	if rc.UserID <= 0 {
+		return ErrUserIDRequired
+	}
+	return nil
+}
+
+// normalizeCode makes codes match however they were typed in:
+// case, dashes and spaces don't matter
+func (rcv *recoveryCodeValidator) normalizeCode(rc *recoveryCode) error {
+	code := strings.ToLower(rc.Code)
+	code = strings.Replace(code, "-", "", -1)
+	rc.Code = strings.Replace(code, " ", "", -1)
+	return nil
+}
+
+func (rcv *recoveryCodeValidator) hmacCode(rc *recoveryCode) error {
+	if rc.Code == "" {
+		return nil
+	}
+	rc.CodeHash = rcv.hmac.Hash(rc.Code)
+	return nil
+}
+
+func (rcv *recoveryCodeValidator) codeHashRequired(rc *recoveryCode) error {
+	if rc.CodeHash == "" {
+		return ErrTOTPInvalid
+	}
+	return nil
+}
+
+var _ recoveryCodeDB = &recoveryCodeGorm{}
+
+type recoveryCodeGorm struct {
+	db *gorm.DB
+}
+
+func (rcg *recoveryCodeGorm) ByCode(userID uint, codeHash string) (*recoveryCode, error) {
+	var rc recoveryCode
+	db := rcg.db.Where("user_id = ? AND code_hash = ?", userID, codeHash)
+	if err := first(db, &rc); err != nil {
+		return nil, err
+	}
+	return &rc, nil
+}
+
+func (rcg *recoveryCodeGorm) Create(rc *recoveryCode) error {
+	return rcg.db.Create(rc).Error
+}
+
+func (rcg *recoveryCodeGorm) Delete(id uint) error {
+	rc := recoveryCode{ID: id}
+	return rcg.db.Delete(&rc).Error
+}
+
+func (rcg *recoveryCodeGorm) DeleteByUserID(userID uint) error {
+	return rcg.db.Where("user_id = ?", userID).Delete(&recoveryCode{}).Error
+}
@@ -0,0 +1,76 @@
+package models
+
+import (
+	"sync"
+	"time"
+)
+
+var _ recoveryCodeDB = &recoveryCodeMem{}
+
+func newRecoveryCodeMem() *recoveryCodeMem {
+	return &recoveryCodeMem{
+		codes: make(map[uint]recoveryCode),
+	}
+}
+
+// recoveryCodeMem is the in-memory recoveryCodeDB used alongside userMem
+type recoveryCodeMem struct {
+	mu     sync.RWMutex
+	codes  map[uint]recoveryCode
+	lastID uint
+}
+
+func (rcm *recoveryCodeMem) ByCode(userID uint, codeHash string) (*recoveryCode, error) {
+	rcm.mu.RLock()
+	defer rcm.mu.RUnlock()
+	for _, rc := range rcm.codes {
+		if rc.UserID == userID && rc.CodeHash == codeHash {
+			found := rc
+			return &found, nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+func (rcm *recoveryCodeMem) Create(rc *recoveryCode) error {
+	rcm.mu.Lock()
+	defer rcm.mu.Unlock()
+	for _, existing := range rcm.codes {
+		if existing.CodeHash == rc.CodeHash {
+			return errMemDuplicate
+		}
+	}
+	rcm.lastID++
+	rc.ID = rcm.lastID
+	rc.CreatedAt = time.Now()
+
+	stored := *rc
+	stored.Code = ""
+	rcm.codes[rc.ID] = stored
+	return nil
+}
+
+func (rcm *recoveryCodeMem) Delete(id uint) error {
+	rcm.mu.Lock()
+	defer rcm.mu.Unlock()
+	delete(rcm.codes, id)
+	return nil
+}
+
+func (rcm *recoveryCodeMem) DeleteByUserID(userID uint) error {
+	rcm.mu.Lock()
+	defer rcm.mu.Unlock()
+	for id, rc := range rcm.codes {
+		if rc.UserID == userID {
+			delete(rcm.codes, id)
+		}
+	}
+	return nil
+}
+
+func (rcm *recoveryCodeMem) reset() {
+	rcm.mu.Lock()
+	defer rcm.mu.Unlock()
+	rcm.codes = make(map[uint]recoveryCode)
+	rcm.lastID = 0
+}
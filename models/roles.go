@@ -0,0 +1,296 @@
+package models
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+var (
+	// ErrRoleInvalid is returned when a user is given a role that
+	// isn't one of Roles
+	ErrRoleInvalid = newPublicError("models: role is not valid",
+		"Please pick one of the roles.", http.StatusUnprocessableEntity)
+
+	// ErrPermissionInvalid is returned when a permission that isn't
+	// one of Permissions is granted or revoked
+	ErrPermissionInvalid = newPublicError("models: permission is not valid",
+		"That permission doesn't exist.", http.StatusUnprocessableEntity)
+
+	// ErrPermissionFromRole is returned when revoking a permission
+	// the user has because of their role
+	ErrPermissionFromRole = newPublicError("models: permission comes with the user's role",
+		"That permission comes with the user's role. Change their role instead.", http.StatusConflict)
+)
+
+// The roles a user can have. Every user has exactly one, new users
+// are members.
+const (
+	RoleAdmin  = "admin"
+	RoleMember = "member"
+	RoleViewer = "viewer"
+)
+
+// Roles lists every role, from the most to the least access
+var Roles = []string{RoleAdmin, RoleMember, RoleViewer}
+
+// The permissions handlers can require, named resource:action
+const (
+	PermDataRead    = "data:read"
+	PermDataWrite   = "data:write"
+	PermUsersRead   = "users:read"
+	PermUsersWrite  = "users:write"
+	PermUsersDelete = "users:delete"
+)
+
+// Permissions lists every permission, in the order they are shown
+var Permissions = []string{PermDataRead, PermDataWrite, PermUsersRead, PermUsersWrite, PermUsersDelete}
+
+// rolePermissions is what each role is allowed to do. Anything
+// else has to be granted to the user.
+var rolePermissions = map[string][]string{
+	RoleAdmin:  Permissions,
+	RoleMember: {PermDataRead, PermDataWrite},
+	RoleViewer: {PermDataRead},
+}
+
+// RolePermissions returns the permissions that come with role
+func RolePermissions(role string) []string {
+	return rolePermissions[role]
+}
+
+// validRole reports whether role is one of Roles
+func validRole(role string) bool {
+	_, ok := rolePermissions[role]
+	return ok
+}
+
+// validPermission reports whether perm is one of Permissions
+func validPermission(perm string) bool {
+	return containsString(Permissions, perm)
+}
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+// SetRole changes the user's role
+func (us *userService) SetRole(userID uint, role string) error {
+	user, err := us.ByID(userID)
+	if err != nil {
+		return err
+	}
+	user.Role = role
+	return us.Update(user)
+}
+
+// GrantPermission gives the user perm on top of their role.
+// Granting a permission they already have does nothing.
+func (us *userService) GrantPermission(userID uint, perm string) error {
+	user, err := us.ByID(userID)
+	if err != nil {
+		return err
+	}
+	has, err := us.HasPermission(user, perm)
+	if err != nil || has {
+		return err
+	}
+	return us.grantDB.Create(&permissionGrant{UserID: user.ID, Permission: perm})
+}
+
+// RevokePermission takes back a permission given with
+// GrantPermission. Permissions that come with the user's role
+// return ErrPermissionFromRole.
+func (us *userService) RevokePermission(userID uint, perm string) error {
+	user, err := us.ByID(userID)
+	if err != nil {
+		return err
+	}
+	if containsString(RolePermissions(user.Role), perm) {
+		return ErrPermissionFromRole
+	}
+	return us.grantDB.Delete(user.ID, perm)
+}
+
+// Permissions returns everything the user is allowed to do, in the
+// order of Permissions
+func (us *userService) Permissions(user *User) ([]string, error) {
+	grants, err := us.grantDB.ByUserID(user.ID)
+	if err != nil {
+		return nil, err
+	}
+	has := make(map[string]bool)
+	for _, perm := range RolePermissions(user.Role) {
+		has[perm] = true
+	}
+	for _, grant := range grants {
+		has[grant.Permission] = true
+	}
+	var perms []string
+	for _, perm := range Permissions {
+		if has[perm] {
+			perms = append(perms, perm)
+		}
+	}
+	return perms, nil
+}
+
+// HasPermission only looks up the user's grants when their role
+// doesn't include perm
+func (us *userService) HasPermission(user *User, perm string) (bool, error) {
+	if !validPermission(perm) {
+		return false, ErrPermissionInvalid
+	}
+	if containsString(RolePermissions(user.Role), perm) {
+		return true, nil
+	}
+	grants, err := us.grantDB.ByUserID(user.ID)
+	if err != nil {
+		return false, err
+	}
+	for _, grant := range grants {
+		if grant.Permission == perm {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// normalizeRole makes new users members
+func (uv *userValidator) normalizeRole(user *User) error {
+	user.Role = strings.ToLower(strings.TrimSpace(user.Role))
+	if user.Role == "" {
+		user.Role = RoleMember
+	}
+	return nil
+}
+
+func (uv *userValidator) roleValid(user *User) error {
+	if !validRole(user.Role) {
+		return ErrRoleInvalid
+	}
+	return nil
+}
+
+// permissionGrant gives one user a permission their role doesn't
+// include
+type permissionGrant struct {
+	ID         uint   `gorm:"primary_key"`
+	UserID     uint   `gorm:"not null;unique_index:idx_permission_grants_user_permission"`
+	Permission string `gorm:"not null;unique_index:idx_permission_grants_user_permission"`
+	CreatedAt  time.Time
+}
+
+// permissionGrantDB is used to interact with the permission_grants
+// table
+type permissionGrantDB interface {
+	ByUserID(userID uint) ([]permissionGrant, error)
+	Create(grant *permissionGrant) error
+	Delete(userID uint, perm string) error
+	DeleteByUserID(userID uint) error
+}
+
+type permissionGrantValFunc func(*permissionGrant) error
+
+func runPermissionGrantValFuncs(grant *permissionGrant, fns ...permissionGrantValFunc) error {
+	for _, fn := range fns {
+		if err := fn(grant); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+var _ permissionGrantDB = &permissionGrantValidator{}
+
+func newPermissionGrantValidator(db permissionGrantDB) *permissionGrantValidator {
+	return &permissionGrantValidator{
+		permissionGrantDB: db,
+	}
+}
+
+type permissionGrantValidator struct {
+	permissionGrantDB
+}
+
+func (pgv *permissionGrantValidator) ByUserID(userID uint) ([]permissionGrant, error) {
+	if userID <= 0 {
+		return nil, ErrIDInvalid
+	}
+	return pgv.permissionGrantDB.ByUserID(userID)
+}
+
+func (pgv *permissionGrantValidator) Create(grant *permissionGrant) error {
+	err := runPermissionGrantValFuncs(grant,
+		pgv.userIDRequired,
+		pgv.permissionValid)
+	if err != nil {
+		return err
+	}
+	return pgv.permissionGrantDB.Create(grant)
+}
+
+func (pgv *permissionGrantValidator) Delete(userID uint, perm string) error {
+	grant := permissionGrant{UserID: userID, Permission: perm}
+	err := runPermissionGrantValFuncs(&grant,
+		pgv.userIDRequired,
+		pgv.permissionValid)
+	if err != nil {
+		return err
+	}
+	return pgv.permissionGrantDB.Delete(userID, perm)
+}
+
+func (pgv *permissionGrantValidator) DeleteByUserID(userID uint) error {
+	if userID <= 0 {
+		return ErrIDInvalid
+	}
+	return pgv.permissionGrantDB.DeleteByUserID(userID)
+}
+
+func (pgv *permissionGrantValidator) userIDRequired(grant *permissionGrant) error {
+	if grant.UserID <= 0 {
+		return ErrUserIDRequired
+	}
+	return nil
+}
+
+func (pgv *permissionGrantValidator) permissionValid(grant *permissionGrant) error {
+	if !validPermission(grant.Permission) {
+		return ErrPermissionInvalid
+	}
+	return nil
+}
+
+var _ permissionGrantDB = &permissionGrantGorm{}
+
+type permissionGrantGorm struct {
+	db *gorm.DB
+}
+
+func (pgg *permissionGrantGorm) ByUserID(userID uint) ([]permissionGrant, error) {
+	var grants []permissionGrant
+	if err := pgg.db.Where("user_id = ?", userID).Find(&grants).Error; err != nil {
+		return nil, err
+	}
+	return grants, nil
+}
+
+func (pgg *permissionGrantGorm) Create(grant *permissionGrant) error {
+	return pgg.db.Create(grant).Error
+}
+
+func (pgg *permissionGrantGorm) Delete(userID uint, perm string) error {
+	return pgg.db.Where("user_id = ? AND permission = ?", userID, perm).Delete(&permissionGrant{}).Error
+}
+
+func (pgg *permissionGrantGorm) DeleteByUserID(userID uint) error {
+	return pgg.db.Where("user_id = ?", userID).Delete(&permissionGrant{}).Error
+}
@@ -0,0 +1,79 @@
+package models
+
+import (
+	"sync"
+	"time"
+)
+
+var _ permissionGrantDB = &permissionGrantMem{}
+
+func newPermissionGrantMem() *permissionGrantMem {
+	return &permissionGrantMem{
+		grants: make(map[uint]permissionGrant),
+	}
+}
+
+// permissionGrantMem is the in-memory permissionGrantDB used
+// alongside userMem. A user can only be granted a permission once,
+// the same as the index on the permission_grants table.
+type permissionGrantMem struct {
+	mu     sync.RWMutex
+	grants map[uint]permissionGrant
+	lastID uint
+}
+
+func (pgm *permissionGrantMem) ByUserID(userID uint) ([]permissionGrant, error) {
+	pgm.mu.RLock()
+	defer pgm.mu.RUnlock()
+	var grants []permissionGrant
+	for _, grant := range pgm.grants {
+		if grant.UserID == userID {
+			grants = append(grants, grant)
+		}
+	}
+	return grants, nil
+}
+
+func (pgm *permissionGrantMem) Create(grant *permissionGrant) error {
+	pgm.mu.Lock()
+	defer pgm.mu.Unlock()
+	for _, existing := range pgm.grants {
+		if existing.UserID == grant.UserID && existing.Permission == grant.Permission {
+			return errMemDuplicate
+		}
+	}
+	pgm.lastID++
+	grant.ID = pgm.lastID
+	grant.CreatedAt = time.Now()
+	pgm.grants[grant.ID] = *grant
+	return nil
+}
+
+func (pgm *permissionGrantMem) Delete(userID uint, perm string) error {
+	pgm.mu.Lock()
+	defer pgm.mu.Unlock()
+	for id, grant := range pgm.grants {
+		if grant.UserID == userID && grant.Permission == perm {
+			delete(pgm.grants, id)
+		}
+	}
+	return nil
+}
+
+func (pgm *permissionGrantMem) DeleteByUserID(userID uint) error {
+	pgm.mu.Lock()
+	defer pgm.mu.Unlock()
+	for id, grant := range pgm.grants {
+		if grant.UserID == userID {
+			delete(pgm.grants, id)
+		}
+	}
+	return nil
+}
+
+func (pgm *permissionGrantMem) reset() {
+	pgm.mu.Lock()
+	defer pgm.mu.Unlock()
+	pgm.grants = make(map[uint]permissionGrant)
+	pgm.lastID = 0
+}
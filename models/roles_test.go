@@ -0,0 +1,108 @@
+package models
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestRoles(t *testing.T) {
+	us, err := testingUserService()
+	if err != nil {
+		t.Fatal(err)
+	}
+	user := User{Email: "dwight@dundermifflin.com", Password: "beets-bears"}
+	if err := us.Create(&user); err != nil {
+		t.Fatal(err)
+	}
+	if user.Role != RoleMember {
+		t.Errorf("Expected new users to be members. Received %q", user.Role)
+	}
+	if ok, err := us.HasPermission(&user, PermUsersDelete); ok || err != nil {
+		t.Errorf("Expected members not to delete users. Received %v, %v", ok, err)
+	}
+
+	if err := us.SetRole(user.ID, "regional manager"); err != ErrRoleInvalid {
+		t.Errorf("Expected ErrRoleInvalid. Received %v", err)
+	}
+	if err := us.SetRole(user.ID, RoleViewer); err != nil {
+		t.Fatal(err)
+	}
+	viewer, err := us.ByID(user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	perms, err := us.Permissions(viewer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{PermDataRead}; !reflect.DeepEqual(perms, want) {
+		t.Errorf("Expected %v. Received %v", want, perms)
+	}
+	if ok, _ := us.HasPermission(viewer, PermDataWrite); ok {
+		t.Error("Expected viewers not to write")
+	}
+
+	if err := us.SetRole(user.ID, RoleAdmin); err != nil {
+		t.Fatal(err)
+	}
+	admin, err := us.ByID(user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, perm := range Permissions {
+		if ok, err := us.HasPermission(admin, perm); !ok || err != nil {
+			t.Errorf("Expected admins to have %s. Received %v, %v", perm, ok, err)
+		}
+	}
+	if _, err := us.HasPermission(admin, "users:fire"); err != ErrPermissionInvalid {
+		t.Errorf("Expected ErrPermissionInvalid. Received %v", err)
+	}
+}
+
+func TestGrantPermission(t *testing.T) {
+	us, err := testingUserService()
+	if err != nil {
+		t.Fatal(err)
+	}
+	user := User{Email: "oscar@dundermifflin.com", Password: "accounting", Role: RoleViewer}
+	if err := us.Create(&user); err != nil {
+		t.Fatal(err)
+	}
+
+	// Granting twice is fine
+	for i := 0; i < 2; i++ {
+		if err := us.GrantPermission(user.ID, PermUsersRead); err != nil {
+			t.Fatal(err)
+		}
+	}
+	perms, err := us.Permissions(&user)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{PermDataRead, PermUsersRead}; !reflect.DeepEqual(perms, want) {
+		t.Errorf("Expected %v. Received %v", want, perms)
+	}
+
+	cases := map[string]struct {
+		fn  func() error
+		err error
+	}{
+		"grant unknown":      {func() error { return us.GrantPermission(user.ID, "users:fire") }, ErrPermissionInvalid},
+		"grant no user":      {func() error { return us.GrantPermission(999, PermUsersRead) }, ErrNotFound},
+		"revoke from role":   {func() error { return us.RevokePermission(user.ID, PermDataRead) }, ErrPermissionFromRole},
+		"revoke unknown":     {func() error { return us.RevokePermission(user.ID, "users:fire") }, ErrPermissionInvalid},
+		"revoke not granted": {func() error { return us.RevokePermission(user.ID, PermUsersDelete) }, nil},
+	}
+	for name, c := range cases {
+		if err := c.fn(); err != c.err {
+			t.Errorf("%s: Expected %v. Received %v", name, c.err, err)
+		}
+	}
+
+	if err := us.RevokePermission(user.ID, PermUsersRead); err != nil {
+		t.Fatal(err)
+	}
+	if ok, _ := us.HasPermission(&user, PermUsersRead); ok {
+		t.Error("Expected the permission to be revoked")
+	}
+}
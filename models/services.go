@@ -0,0 +1,313 @@
+package models
+
+import (
+	"errors"
+	"strings"
+
+	"../encrypt"
+	"../hash"
+	"github.com/jinzhu/gorm"
+)
+
+var (
+	// ErrStorageRequired is returned by NewServices when neither
+	// WithGorm nor WithMemory was provided
+	ErrStorageRequired = errors.New("models: WithGorm or WithMemory is required")
+)
+
+// ServicesConfig is a functional option for NewServices
+type ServicesConfig func(*servicesConfig) error
+
+type servicesConfig struct {
+	db            *gorm.DB
+	mem           []resetter
+	user          UserDB
+	session       SessionDB
+	pwReset       pwResetDB
+	recovery      recoveryCodeDB
+	apiKey        APIKeyDB
+	identity      identityDB
+	grant         permissionGrantDB
+	pepper        hash.Key
+	oldPeppers    []hash.Key
+	hasher        PasswordHasher
+	hmacKey       hash.Key
+	oldHMACKeys   []hash.Key
+	encryptionKey string
+
+	requireVerified bool
+}
+
+// resetter is implemented by the in-memory stores so
+// DestructiveReset can clear them
+type resetter interface {
+	reset()
+}
+
+// WithGorm opens a database with the provided gorm dialect
+// (DialectPostgres, DialectMySQL or DialectSQLite) and connection
+// info and stores every model in it
+func WithGorm(dialect, connectionInfo string) ServicesConfig {
+	return func(cfg *servicesConfig) error {
+		if err := checkDialect(dialect); err != nil {
+			return err
+		}
+		db, err := gorm.Open(dialect, connectionInfo)
+		if err != nil {
+			return err
+		}
+		if dialect == DialectSQLite {
+			// Every new SQLite connection to an in-memory database
+			// gets its own empty database, so stick to one.
+			// SQLite only allows a single writer anyway.
+			db.DB().SetMaxOpenConns(1)
+		}
+		db.LogMode(true)
+		cfg.db = db
+		cfg.user = &userGorm{db: db}
+		cfg.session = &sessionGorm{db: db}
+		cfg.pwReset = &pwResetGorm{db: db}
+		cfg.recovery = &recoveryCodeGorm{db: db}
+		cfg.apiKey = &apiKeyGorm{db: db}
+		cfg.identity = &identityGorm{db: db}
+		cfg.grant = &permissionGrantGorm{db: db}
+		return nil
+	}
+}
+
+// WithMemory keeps every model in memory instead of a database.
+// Nothing is persisted, so this is only meant for tests and
+// local development.
+func WithMemory() ServicesConfig {
+	return func(cfg *servicesConfig) error {
+		um, sm, pwrm, rcm := newUserMem(), newSessionMem(), newPwResetMem(), newRecoveryCodeMem()
+		akm, im, pgm := newAPIKeyMem(), newIdentityMem(), newPermissionGrantMem()
+		cfg.user = um
+		cfg.session = sm
+		cfg.pwReset = pwrm
+		cfg.recovery = rcm
+		cfg.apiKey = akm
+		cfg.identity = im
+		cfg.grant = pgm
+		cfg.mem = []resetter{um, sm, pwrm, rcm, akm, im, pgm}
+		return nil
+	}
+}
+
+// WithLogMode turns gorm's SQL logging on or off. It has to come
+// after WithGorm.
+func WithLogMode(mode bool) ServicesConfig {
+	return func(cfg *servicesConfig) error {
+		if cfg.db != nil {
+			cfg.db.LogMode(mode)
+		}
+		return nil
+	}
+}
+
+// WithUser sets the pepper added to every password and the key
+// remember tokens are hashed with. Neither has a key ID, use
+// WithPeppers and WithHMACKeys to rotate them.
+func WithUser(pepper, hmacKey string) ServicesConfig {
+	return func(cfg *servicesConfig) error {
+		cfg.pepper = hash.Key{Secret: pepper}
+		cfg.hmacKey = hash.Key{Secret: hmacKey}
+		return nil
+	}
+}
+
+// WithHMACKeys sets the key tokens are hashed and signed with, and
+// the keys used before it. Tokens that only match a previous key
+// still work and are hashed again with the current key when used.
+func WithHMACKeys(current hash.Key, previous ...hash.Key) ServicesConfig {
+	return func(cfg *servicesConfig) error {
+		cfg.hmacKey = current
+		cfg.oldHMACKeys = previous
+		return nil
+	}
+}
+
+// WithPeppers sets the pepper added to every password, and the
+// peppers used before it. Users whose hash was made with a previous
+// pepper can still log in, and it is redone with the current one
+// when they do.
+func WithPeppers(current hash.Key, previous ...hash.Key) ServicesConfig {
+	return func(cfg *servicesConfig) error {
+		cfg.pepper = current
+		cfg.oldPeppers = previous
+		return nil
+	}
+}
+
+// WithPasswordHasher sets how new passwords are hashed. Existing
+// hashes made by another hasher, or with other parameters, are
+// replaced the next time the user logs in. Defaults to bcrypt
+// with bcrypt.DefaultCost.
+func WithPasswordHasher(hasher PasswordHasher) ServicesConfig {
+	return func(cfg *servicesConfig) error {
+		cfg.hasher = hasher
+		return nil
+	}
+}
+
+// WithEncryptionKey sets the key secrets that have to be read back,
+// like TOTP secrets, are encrypted with
+func WithEncryptionKey(key string) ServicesConfig {
+	return func(cfg *servicesConfig) error {
+		cfg.encryptionKey = key
+		return nil
+	}
+}
+
+// WithRequireVerified makes UserService.Authenticate reject users
+// until they verify their email address
+func WithRequireVerified(required bool) ServicesConfig {
+	return func(cfg *servicesConfig) error {
+		cfg.requireVerified = required
+		return nil
+	}
+}
+
+// NewServices applies the provided options and builds every service
+// on top of the same storage. It replaces NewUserService and
+// NewMemoryUserService: pass WithGorm or WithMemory along with
+// WithUser, and use Services.User.
+func NewServices(cfgs ...ServicesConfig) (*Services, error) {
+	var cfg servicesConfig
+	for _, fn := range cfgs {
+		if err := fn(&cfg); err != nil {
+			return nil, err
+		}
+	}
+	if cfg.user == nil {
+		return nil, ErrStorageRequired
+	}
+
+	hmac := hash.NewKeyring(cfg.hmacKey, cfg.oldHMACKeys...)
+	ss := newSessionService(cfg.session, hmac)
+	aks := newAPIKeyService(cfg.apiKey, hmac)
+	us := newUserService(cfg.user, ss,
+		newPwResetValidator(cfg.pwReset, hmac),
+		newRecoveryCodeValidator(cfg.recovery, hmac),
+		newIdentityValidator(cfg.identity),
+		newPermissionGrantValidator(cfg.grant),
+		aks, hmac, encrypt.NewAESGCM(cfg.encryptionKey),
+		newPasswords(cfg.hasher, hash.NewKeyring(cfg.pepper, cfg.oldPeppers...)))
+	us.requireVerified = cfg.requireVerified
+	return &Services{
+		User:    us,
+		Session: ss,
+		APIKey:  aks,
+		db:      cfg.db,
+		mem:     cfg.mem,
+	}, nil
+}
+
+// Services holds every service the controllers need
+type Services struct {
+	User    UserService
+	Session SessionService
+	APIKey  APIKeyService
+	db      *gorm.DB
+	mem     []resetter
+}
+
+// Close closes the database connection
+func (s *Services) Close() error {
+	if s.db == nil {
+		return nil
+	}
+	return s.db.Close()
+}
+
+// DestructiveReset drops all tables and rebuilds them
+func (s *Services) DestructiveReset() error {
+	if s.db == nil {
+		for _, m := range s.mem {
+			m.reset()
+		}
+		return nil
+	}
+	err := s.db.DropTableIfExists(&User{}, &Session{}, &pwReset{}, &recoveryCode{}, &APIKey{}, &identity{}, &permissionGrant{}).Error
+	if err != nil {
+		return err
+	}
+	return s.AutoMigrate()
+}
+
+// AutoMigrate will attempt to automatically migrate all tables
+func (s *Services) AutoMigrate() error {
+	if s.db == nil {
+		return nil
+	}
+	err := s.db.AutoMigrate(&User{}, &Session{}, &pwReset{}, &recoveryCode{}, &APIKey{}, &identity{}, &permissionGrant{}).Error
+	if err != nil {
+		return err
+	}
+	return s.dropRememberHash()
+}
+
+// dropRememberHash removes the remember_hash column users had
+// before sessions replaced it. AutoMigrate never drops columns, and
+// this one is NOT NULL, so new users couldn't be created with it.
+func (s *Services) dropRememberHash() error {
+	if !s.db.Dialect().HasColumn("users", "remember_hash") {
+		return nil
+	}
+	if s.db.Dialect().GetName() == DialectSQLite {
+		return s.rebuildUsers()
+	}
+	users := s.db.Model(&User{})
+	if s.db.Dialect().HasIndex("users", "uix_users_remember_hash") {
+		if err := users.RemoveIndex("uix_users_remember_hash").Error; err != nil {
+			return err
+		}
+	}
+	return users.DropColumn("remember_hash").Error
+}
+
+// rebuildUsers copies the users into a new table with only the
+// columns User has, since older versions of SQLite can't drop a
+// column. It expects AutoMigrate to have added any new columns.
+func (s *Services) rebuildUsers() error {
+	tx := s.db.Begin()
+	if err := tx.Error; err != nil {
+		return err
+	}
+	err := func() error {
+		if err := tx.Exec("ALTER TABLE users RENAME TO users_old").Error; err != nil {
+			return err
+		}
+		// The indexes keep their names and move along with the table
+		var indexes []string
+		err := tx.Raw("SELECT name FROM sqlite_master WHERE type = 'index' AND tbl_name = 'users_old' AND sql IS NOT NULL").
+			Pluck("name", &indexes).Error
+		if err != nil {
+			return err
+		}
+		for _, idx := range indexes {
+			if err := tx.Exec("DROP INDEX " + tx.Dialect().Quote(idx)).Error; err != nil {
+				return err
+			}
+		}
+		if err := tx.AutoMigrate(&User{}).Error; err != nil {
+			return err
+		}
+		var columns []string
+		for _, field := range tx.NewScope(&User{}).Fields() {
+			if field.IsNormal && !field.IsIgnored {
+				columns = append(columns, tx.Dialect().Quote(field.DBName))
+			}
+		}
+		cols := strings.Join(columns, ", ")
+		if err := tx.Exec("INSERT INTO users (" + cols + ") SELECT " + cols + " FROM users_old").Error; err != nil {
+			return err
+		}
+		return tx.DropTable("users_old").Error
+	}()
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit().Error
+}
@@ -0,0 +1,289 @@
+package models
+
+import (
+	"time"
+
+	"../hash"
+	"../rand"
+	"github.com/jinzhu/gorm"
+)
+
+var (
+	// ErrUserIDRequired is returned when a session is created
+	// without the user it belongs to
+	ErrUserIDRequired error = privateError("models: user ID is required")
+)
+
+const (
+	// SessionDuration is how long a session stays valid after the
+	// user signs in
+	SessionDuration = 30 * 24 * time.Hour
+
+	// sessionTouchInterval limits how often LastSeenAt is written
+	// so every request doesn't turn into a database write
+	sessionTouchInterval = time.Minute
+)
+
+// Session is a single signed in device. Each login gets its own
+// token, so one device can be signed out without affecting the others.
+type Session struct {
+	ID         uint   `gorm:"primary_key"`
+	UserID     uint   `gorm:"not null;index"`
+	Token      string `gorm:"-"`
+	TokenHash  string `gorm:"not null;unique_index"`
+	UserAgent  string
+	IP         string
+	CreatedAt  time.Time
+	LastSeenAt time.Time
+	ExpiresAt  time.Time `gorm:"not null"`
+}
+
+// Expired reports whether the session can no longer be used
+func (s *Session) Expired() bool {
+	return !time.Now().Before(s.ExpiresAt)
+}
+
+// SessionDB is used to interact with the sessions database.
+//
+// For single session queries:
+// If the session is found: session, nil
+// If the session is not found: nil, ErrNotFound
+// If there is another error: nil, OtherError
+type SessionDB interface {
+	// ByToken expects the raw token at the validation layer and the
+	// hashed token at the database layer
+	ByToken(token string) (*Session, error)
+	ByUserID(userID uint) ([]Session, error)
+
+	Create(session *Session) error
+	Update(session *Session) error
+	Delete(id uint) error
+	DeleteByUserID(userID uint) error
+}
+
+// SessionService is a set of methods used to manipulate and work
+// with the session model
+type SessionService interface {
+	// Touch records that the session was just used
+	Touch(session *Session) error
+	SessionDB
+}
+
+func newSessionService(sdb SessionDB, hmac hash.Keyring) *sessionService {
+	return &sessionService{
+		SessionDB: newSessionValidator(sdb, hmac),
+	}
+}
+
+var _ SessionService = &sessionService{}
+
+type sessionService struct {
+	SessionDB
+}
+
+// Touch updates LastSeenAt, at most once per sessionTouchInterval
+func (ss *sessionService) Touch(session *Session) error {
+	if time.Since(session.LastSeenAt) < sessionTouchInterval {
+		return nil
+	}
+	session.LastSeenAt = time.Now()
+	return ss.Update(session)
+}
+
+type sessionValFunc func(*Session) error
+
+func runSessionValFuncs(session *Session, fns ...sessionValFunc) error {
+	for _, fn := range fns {
+		if err := fn(session); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+var _ SessionDB = &sessionValidator{}
+
+func newSessionValidator(sdb SessionDB, hmac hash.Keyring) *sessionValidator {
+	return &sessionValidator{
+		SessionDB: sdb,
+		hmac:      hmac,
+	}
+}
+
+type sessionValidator struct {
+	SessionDB
+	hmac hash.Keyring
+}
+
+// ByToken hashes the token and looks up the session. Expired
+// sessions are treated as if they don't exist. A session found
+// under a previous HMAC key is hashed again with the current one.
+func (sv *sessionValidator) ByToken(token string) (*Session, error) {
+	if token == "" {
+		return nil, ErrNotFound
+	}
+	var found *Session
+	stale, err := findHashed(sv.hmac, token, func(hash string) (err error) {
+		found, err = sv.SessionDB.ByToken(hash)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	if found.Expired() {
+		return nil, ErrNotFound
+	}
+	if stale {
+		found.Token = token
+		if err := sv.Update(found); err != nil {
+			return nil, err
+		}
+	}
+	return found, nil
+}
+
+func (sv *sessionValidator) Create(session *Session) error {
+	err := runSessionValFuncs(session,
+		sv.userIDRequired,
+		sv.setTokenIfUnset,
+		sv.tokenMinBytes,
+		sv.hmacToken,
+		sv.tokenHashRequired,
+		sv.setExpiresIfUnset)
+	if err != nil {
+		return err
+	}
+	return sv.SessionDB.Create(session)
+}
+
+func (sv *sessionValidator) Update(session *Session) error {
+	err := runSessionValFuncs(session,
+		sv.userIDRequired,
+		sv.tokenMinBytes,
+		sv.hmacToken,
+		sv.tokenHashRequired)
+	if err != nil {
+		return err
+	}
+	return sv.SessionDB.Update(session)
+}
+
+// Delete the session with the provided ID
+func (sv *sessionValidator) Delete(id uint) error {
+	if id <= 0 {
+		return ErrIDInvalid
+	}
+	return sv.SessionDB.Delete(id)
+}
+
+// DeleteByUserID deletes every session the user has
+func (sv *sessionValidator) DeleteByUserID(userID uint) error {
+	if userID <= 0 {
+		return ErrIDInvalid
+	}
+	return sv.SessionDB.DeleteByUserID(userID)
+}
+
+func (sv *sessionValidator) userIDRequired(session *Session) error {
+	if session.UserID <= 0 {
+		return ErrUserIDRequired
+	}
+	return nil
+}
+
+func (sv *sessionValidator) setTokenIfUnset(session *Session) error {
+	if session.Token != "" {
+		return nil
+	}
+	token, err := rand.RememberToken()
+	if err != nil {
+		return err
+	}
+	session.Token = token
+	return nil
+}
+
+func (sv *sessionValidator) tokenMinBytes(session *Session) error {
+	if session.Token == "" {
+		return nil
+	}
+	n, err := rand.NBytes(session.Token)
+	if err != nil {
+		return err
+	}
+	if n < rand.RememberTokenBytes {
+		return ErrRememberTooShort
+	}
+	return nil
+}
+
+func (sv *sessionValidator) hmacToken(session *Session) error {
+	if session.Token == "" {
+		return nil
+	}
+	session.TokenHash = sv.hmac.Hash(session.Token)
+	return nil
+}
+
+func (sv *sessionValidator) tokenHashRequired(session *Session) error {
+	if session.TokenHash == "" {
+		return ErrRememberRequired
+	}
+	return nil
+}
+
+func (sv *sessionValidator) setExpiresIfUnset(session *Session) error {
+	now := time.Now()
+	if session.LastSeenAt.IsZero() {
+		session.LastSeenAt = now
+	}
+	if session.ExpiresAt.IsZero() {
+		session.ExpiresAt = now.Add(SessionDuration)
+	}
+	return nil
+}
+
+var _ SessionDB = &sessionGorm{}
+
+type sessionGorm struct {
+	db *gorm.DB
+}
+
+// ByToken looks up a session by the hashed token
+func (sg *sessionGorm) ByToken(tokenHash string) (*Session, error) {
+	var session Session
+	err := first(sg.db.Where("token_hash = ?", tokenHash), &session)
+	if err != nil {
+		return nil, err
+	}
+	return &session, nil
+}
+
+// ByUserID returns every session for the user, most recently used first
+func (sg *sessionGorm) ByUserID(userID uint) ([]Session, error) {
+	var sessions []Session
+	err := sg.db.Where("user_id = ?", userID).
+		Order("last_seen_at desc").
+		Find(&sessions).Error
+	if err != nil {
+		return nil, err
+	}
+	return sessions, nil
+}
+
+func (sg *sessionGorm) Create(session *Session) error {
+	return sg.db.Create(session).Error
+}
+
+func (sg *sessionGorm) Update(session *Session) error {
+	return sg.db.Save(session).Error
+}
+
+func (sg *sessionGorm) Delete(id uint) error {
+	session := Session{ID: id}
+	return sg.db.Delete(&session).Error
+}
+
+func (sg *sessionGorm) DeleteByUserID(userID uint) error {
+	return sg.db.Where("user_id = ?", userID).Delete(&Session{}).Error
+}
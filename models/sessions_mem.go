@@ -0,0 +1,128 @@
+package models
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+var _ SessionDB = &sessionMem{}
+
+func newSessionMem() *sessionMem {
+	return &sessionMem{
+		sessions: make(map[uint]Session),
+	}
+}
+
+// sessionMem is the in-memory SessionDB used alongside userMem.
+// TokenHash is unique, the same as the index on the sessions table.
+type sessionMem struct {
+	mu       sync.RWMutex
+	sessions map[uint]Session
+	lastID   uint
+}
+
+func (sm *sessionMem) ByToken(tokenHash string) (*Session, error) {
+	sm.mu.RLock()
+	defer sm.mu.RUnlock()
+	for _, s := range sm.sessions {
+		if s.TokenHash == tokenHash {
+			found := s
+			return &found, nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+func (sm *sessionMem) ByUserID(userID uint) ([]Session, error) {
+	sm.mu.RLock()
+	defer sm.mu.RUnlock()
+	var sessions []Session
+	for _, s := range sm.sessions {
+		if s.UserID == userID {
+			sessions = append(sessions, s)
+		}
+	}
+	sort.Slice(sessions, func(i, j int) bool {
+		return sessions[i].LastSeenAt.After(sessions[j].LastSeenAt)
+	})
+	return sessions, nil
+}
+
+func (sm *sessionMem) Create(session *Session) error {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+	return sm.create(session)
+}
+
+func (sm *sessionMem) create(session *Session) error {
+	if err := sm.checkUnique(session); err != nil {
+		return err
+	}
+	if session.ID == 0 {
+		sm.lastID++
+		session.ID = sm.lastID
+	} else if session.ID > sm.lastID {
+		sm.lastID = session.ID
+	}
+	if session.CreatedAt.IsZero() {
+		session.CreatedAt = time.Now()
+	}
+	sm.store(session)
+	return nil
+}
+
+// Update saves every field of the session, creating it if needed
+func (sm *sessionMem) Update(session *Session) error {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+	if _, ok := sm.sessions[session.ID]; !ok {
+		return sm.create(session)
+	}
+	if err := sm.checkUnique(session); err != nil {
+		return err
+	}
+	sm.store(session)
+	return nil
+}
+
+func (sm *sessionMem) Delete(id uint) error {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+	delete(sm.sessions, id)
+	return nil
+}
+
+func (sm *sessionMem) DeleteByUserID(userID uint) error {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+	for id, s := range sm.sessions {
+		if s.UserID == userID {
+			delete(sm.sessions, id)
+		}
+	}
+	return nil
+}
+
+func (sm *sessionMem) checkUnique(session *Session) error {
+	for id, s := range sm.sessions {
+		if id != session.ID && s.TokenHash == session.TokenHash {
+			return errMemDuplicate
+		}
+	}
+	return nil
+}
+
+// store saves a copy of the session without the raw token
+func (sm *sessionMem) store(session *Session) {
+	s := *session
+	s.Token = ""
+	sm.sessions[s.ID] = s
+}
+
+func (sm *sessionMem) reset() {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+	sm.sessions = make(map[uint]Session)
+	sm.lastID = 0
+}
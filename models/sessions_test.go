@@ -0,0 +1,116 @@
+package models
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSessionsPerDevice(t *testing.T) {
+	s, err := testingServices()
+	if err != nil {
+		t.Fatal(err)
+	}
+	user := User{Email: "kevin@dundermifflin.com", Password: "famouschili"}
+	if err := s.User.Create(&user); err != nil {
+		t.Fatal(err)
+	}
+
+	laptop := Session{UserID: user.ID, UserAgent: "laptop", IP: "10.0.0.1"}
+	phone := Session{UserID: user.ID, UserAgent: "phone", IP: "10.0.0.2"}
+	for _, session := range []*Session{&laptop, &phone} {
+		if err := s.Session.Create(session); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if laptop.Token == phone.Token {
+		t.Fatal("Expected every session to get its own token")
+	}
+	if laptop.TokenHash == laptop.Token {
+		t.Error("Expected the stored token to be hashed")
+	}
+	if time.Until(laptop.ExpiresAt) < SessionDuration-time.Minute {
+		t.Errorf("Expected ExpiresAt to default to SessionDuration. Received %s", laptop.ExpiresAt)
+	}
+
+	// Revoking the laptop leaves the phone signed in
+	if err := s.Session.Delete(laptop.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.User.ByRemember(laptop.Token); err != ErrNotFound {
+		t.Errorf("Expected ErrNotFound for a revoked session. Received %v", err)
+	}
+	found, err := s.User.ByRemember(phone.Token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if found.ID != user.ID {
+		t.Errorf("Expected user %d. Received %d", user.ID, found.ID)
+	}
+
+	sessions, err := s.Session.ByUserID(user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sessions) != 1 || sessions[0].UserAgent != "phone" {
+		t.Errorf("Expected only the phone session to be left. Received %+v", sessions)
+	}
+}
+
+func TestSessionExpired(t *testing.T) {
+	s, err := testingServices()
+	if err != nil {
+		t.Fatal(err)
+	}
+	session := Session{UserID: 1, ExpiresAt: time.Now().Add(-time.Second)}
+	if err := s.Session.Create(&session); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Session.ByToken(session.Token); err != ErrNotFound {
+		t.Errorf("Expected ErrNotFound for an expired session. Received %v", err)
+	}
+	if err := s.Session.Create(&Session{}); err != ErrUserIDRequired {
+		t.Errorf("Expected ErrUserIDRequired. Received %v", err)
+	}
+}
+
+func TestLogout(t *testing.T) {
+	s, err := testingServices()
+	if err != nil {
+		t.Fatal(err)
+	}
+	user := User{Email: "oscar@dundermifflin.com", Password: "actually123"}
+	if err := s.User.Create(&user); err != nil {
+		t.Fatal(err)
+	}
+
+	var sessions [3]Session
+	for i := range sessions {
+		sessions[i].UserID = user.ID
+		if err := s.Session.Create(&sessions[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := s.User.Logout(sessions[0].Token); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.User.ByRemember(sessions[0].Token); err != ErrNotFound {
+		t.Errorf("Expected ErrNotFound after logout. Received %v", err)
+	}
+	if _, err := s.User.ByRemember(sessions[1].Token); err != nil {
+		t.Errorf("Expected other devices to stay signed in. Received %v", err)
+	}
+	// Logging out twice is fine
+	if err := s.User.Logout(sessions[0].Token); err != nil {
+		t.Errorf("Expected no error logging out again. Received %v", err)
+	}
+
+	if err := s.User.LogoutAll(user.ID); err != nil {
+		t.Fatal(err)
+	}
+	for _, session := range sessions[1:] {
+		if _, err := s.User.ByRemember(session.Token); err != ErrNotFound {
+			t.Errorf("Expected ErrNotFound after logging out everywhere. Received %v", err)
+		}
+	}
+}
@@ -0,0 +1,37 @@
+package models
+
+import (
+	"strconv"
+	"time"
+)
+
+// signToken returns a token that carries fields and expires after
+// ttl. Nothing is stored, the token is signed with the current HMAC
+// key along with purpose so a token for one purpose can't be used for
+// another.
+func (us *userService) signToken(purpose string, ttl time.Duration, fields ...string) (string, error) {
+	return us.hmac.Sign(purpose, fields, ttl)
+}
+
+// parseToken checks the signature and expiry of a token created by
+// signToken for purpose and returns its n fields. Anything wrong
+// with the token is ErrTokenInvalid.
+func (us *userService) parseToken(purpose, token string, n int) ([]string, error) {
+	var fields []string
+	if err := us.hmac.Parse(purpose, token, &fields); err != nil {
+		return nil, ErrTokenInvalid
+	}
+	if len(fields) != n {
+		return nil, ErrTokenInvalid
+	}
+	return fields, nil
+}
+
+// tokenUserID parses a user ID field from a signed token
+func tokenUserID(field string) (uint, error) {
+	id, err := strconv.ParseUint(field, 10, 64)
+	if err != nil {
+		return 0, ErrTokenInvalid
+	}
+	return uint(id), nil
+}
@@ -0,0 +1,237 @@
+package models
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"../rand"
+	"../totp"
+)
+
+var (
+	// ErrTOTPInvalid is returned when a two-factor code is wrong,
+	// expired, already used, or isn't one of the user's recovery codes
+	ErrTOTPInvalid = newPublicError("models: two-factor code is not valid",
+		"That code isn't valid. Please try again.", http.StatusUnauthorized)
+
+	// ErrTOTPAlreadyEnabled is returned when setting up two-factor
+	// authentication for a user that already has it turned on
+	ErrTOTPAlreadyEnabled = newPublicError("models: two-factor authentication is already enabled",
+		"Two-factor authentication is already turned on.", http.StatusConflict)
+
+	// ErrTOTPNotEnabled is returned when turning off two-factor
+	// authentication for a user that doesn't have it
+	ErrTOTPNotEnabled = newPublicError("models: two-factor authentication is not enabled",
+		"Two-factor authentication is not turned on.", http.StatusConflict)
+)
+
+// TOTPIssuer is the name authenticator apps show next to the code
+const TOTPIssuer = "DataBot"
+
+// secondFactorDuration is how long a user has to enter their code
+// after entering the right password
+const secondFactorDuration = 5 * time.Minute
+
+// secondFactorPurpose is what second login step tokens are signed for
+const secondFactorPurpose = "login"
+
+// TOTPEnabled reports whether the user needs a code to log in
+func (u *User) TOTPEnabled() bool {
+	return u.TOTPEnabledAt != nil
+}
+
+// InitiateTOTP gives the user a new TOTP secret and returns it along
+// with the otpauth:// URI for the QR code. Nothing changes for the
+// user until EnableTOTP confirms they can generate codes with it.
+func (us *userService) InitiateTOTP(userID uint) (string, string, error) {
+	user, err := us.ByID(userID)
+	if err != nil {
+		return "", "", err
+	}
+	if user.TOTPEnabled() {
+		return "", "", ErrTOTPAlreadyEnabled
+	}
+	secret, err := totp.NewSecret()
+	if err != nil {
+		return "", "", err
+	}
+	// Update encrypts the secret
+	user.TOTPSecret = secret
+	if err := us.Update(user); err != nil {
+		return "", "", err
+	}
+	return secret, totp.URI(TOTPIssuer, user.Email, secret), nil
+}
+
+// EnableTOTP turns on two-factor authentication once code shows the
+// user set up their app with the secret from InitiateTOTP. It
+// returns a fresh set of recovery codes, which are only stored hashed.
+func (us *userService) EnableTOTP(userID uint, code string) ([]string, error) {
+	user, err := us.ByID(userID)
+	if err != nil {
+		return nil, err
+	}
+	if user.TOTPEnabled() {
+		return nil, ErrTOTPAlreadyEnabled
+	}
+	ok, err := us.checkTOTP(user, code)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, ErrTOTPInvalid
+	}
+	codes, err := us.newRecoveryCodes(user.ID)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	user.TOTPEnabledAt = &now
+	if err := us.Update(user); err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// DisableTOTP turns off two-factor authentication. It takes a code,
+// or a recovery code, so a stolen session alone can't turn it off.
+func (us *userService) DisableTOTP(userID uint, code string) error {
+	user, err := us.ByID(userID)
+	if err != nil {
+		return err
+	}
+	if !user.TOTPEnabled() {
+		return ErrTOTPNotEnabled
+	}
+	ok, err := us.checkCode(user, code)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return ErrTOTPInvalid
+	}
+	if err := us.recoveryCodeDB.DeleteByUserID(user.ID); err != nil {
+		return err
+	}
+	user.TOTPSecretEncrypted = ""
+	user.TOTPEnabledAt = nil
+	user.TOTPLastStep = 0
+	return us.Update(user)
+}
+
+// InitiateSecondFactor returns a token for the second login step.
+// Only call it once Authenticate accepted the user's password.
+func (us *userService) InitiateSecondFactor(userID uint) (string, error) {
+	user, err := us.ByID(userID)
+	if err != nil {
+		return "", err
+	}
+	if !user.TOTPEnabled() {
+		return "", ErrTOTPNotEnabled
+	}
+	return us.signToken(secondFactorPurpose, secondFactorDuration, fmt.Sprint(user.ID))
+}
+
+// CompleteSecondFactor returns the user the token was issued to if
+// code is their current TOTP code or one of their recovery codes.
+// Wrong codes count towards the same lockout as wrong passwords.
+func (us *userService) CompleteSecondFactor(token, code string) (*User, error) {
+	fields, err := us.parseToken(secondFactorPurpose, token, 1)
+	if err != nil {
+		return nil, err
+	}
+	userID, err := tokenUserID(fields[0])
+	if err != nil {
+		return nil, err
+	}
+	user, err := us.ByID(userID)
+	if err == ErrNotFound {
+		return nil, ErrTokenInvalid
+	}
+	if err != nil {
+		return nil, err
+	}
+	if user.Locked() {
+		return nil, ErrTooManyAttempts
+	}
+	if user.Disabled() {
+		return nil, ErrAccountDisabled
+	}
+	ok, err := us.checkCode(user, code)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		if err := us.recordFailedLogin(user); err != nil {
+			return nil, err
+		}
+		return nil, ErrTOTPInvalid
+	}
+	if err := us.resetFailedLogins(user); err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+// checkCode accepts either a TOTP code or a recovery code, which
+// is used up
+func (us *userService) checkCode(user *User, code string) (bool, error) {
+	ok, err := us.checkTOTP(user, code)
+	if ok || err != nil {
+		return ok, err
+	}
+	rc, err := us.recoveryCodeDB.ByCode(user.ID, code)
+	if err == ErrNotFound || err == ErrTOTPInvalid {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if err := us.recoveryCodeDB.Delete(rc.ID); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// checkTOTP reports whether code is valid for the user's secret.
+// Each code only works once, the time step it matched is saved on
+// the user and codes from that step or earlier are rejected.
+func (us *userService) checkTOTP(user *User, code string) (bool, error) {
+	if user.TOTPSecretEncrypted == "" {
+		return false, nil
+	}
+	secret, err := us.aead.Decrypt(user.TOTPSecretEncrypted)
+	if err != nil {
+		return false, err
+	}
+	step, ok := totp.Validate(secret, code, time.Now())
+	if !ok || step <= user.TOTPLastStep {
+		return false, nil
+	}
+	user.TOTPLastStep = step
+	if err := us.Update(user); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// newRecoveryCodes replaces the user's recovery codes and returns
+// the new ones
+func (us *userService) newRecoveryCodes(userID uint) ([]string, error) {
+	if err := us.recoveryCodeDB.DeleteByUserID(userID); err != nil {
+		return nil, err
+	}
+	codes := make([]string, recoveryCodeCount)
+	for i := range codes {
+		code, err := rand.RecoveryCode()
+		if err != nil {
+			return nil, err
+		}
+		if err := us.recoveryCodeDB.Create(&recoveryCode{UserID: userID, Code: code}); err != nil {
+			return nil, err
+		}
+		codes[i] = code
+	}
+	return codes, nil
+}
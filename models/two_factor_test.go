@@ -0,0 +1,84 @@
+package models
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"../totp"
+)
+
+func TestTwoFactor(t *testing.T) {
+	s, err := testingServices()
+	if err != nil {
+		t.Fatal(err)
+	}
+	user := User{Email: "pam@dundermifflin.com", Password: "watercolors"}
+	if err := s.User.Create(&user); err != nil {
+		t.Fatal(err)
+	}
+
+	secret, uri, err := s.User.InitiateTOTP(user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(uri, "secret="+secret) {
+		t.Errorf("Expected the secret in %s", uri)
+	}
+	stored, err := s.User.ByID(user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.TOTPSecretEncrypted == "" || strings.Contains(stored.TOTPSecretEncrypted, secret) {
+		t.Errorf("Expected the secret to be stored encrypted. Received %q", stored.TOTPSecretEncrypted)
+	}
+
+	if _, err := s.User.EnableTOTP(user.ID, "000000"); err != ErrTOTPInvalid {
+		t.Errorf("Expected ErrTOTPInvalid. Received %v", err)
+	}
+	// Use the previous step so the login below can use the current one
+	code, _ := totp.Code(secret, totp.Step(time.Now())-1)
+	codes, err := s.User.EnableTOTP(user.ID, code)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(codes) != recoveryCodeCount {
+		t.Errorf("Expected %d recovery codes. Received %d", recoveryCodeCount, len(codes))
+	}
+
+	found, err := s.User.Authenticate(user.Email, "watercolors")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !found.TOTPEnabled() {
+		t.Fatal("Expected two-factor authentication to be enabled")
+	}
+	token, err := s.User.InitiateSecondFactor(found.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Codes can't be replayed
+	if _, err := s.User.CompleteSecondFactor(token, code); err != ErrTOTPInvalid {
+		t.Errorf("Expected ErrTOTPInvalid for a used code. Received %v", err)
+	}
+	code, _ = totp.Code(secret, totp.Step(time.Now()))
+	if _, err := s.User.CompleteSecondFactor(token, code); err != nil {
+		t.Errorf("Expected the current code to work. Received %v", err)
+	}
+
+	// Recovery codes work once, however they are typed
+	recovery := strings.ToUpper(codes[0])
+	if _, err := s.User.CompleteSecondFactor(token, recovery); err != nil {
+		t.Errorf("Expected the recovery code to work. Received %v", err)
+	}
+	if _, err := s.User.CompleteSecondFactor(token, recovery); err != ErrTOTPInvalid {
+		t.Errorf("Expected ErrTOTPInvalid for a used recovery code. Received %v", err)
+	}
+
+	if err := s.User.DisableTOTP(user.ID, codes[1]); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.User.InitiateSecondFactor(user.ID); err != ErrTOTPNotEnabled {
+		t.Errorf("Expected ErrTOTPNotEnabled. Received %v", err)
+	}
+}
@@ -1,60 +1,58 @@
 package models
 
 import (
-	"errors"
-	"golang.org/x/crypto/bcrypt"
+	"net/http"
 	"strings"
 	"regexp"
+	"time"
 
 	"github.com/jinzhu/gorm"
-	_ "github.com/jinzhu/gorm/dialects/postgres"
+	"../encrypt"
 	"../hash"
-	"../rand"
 )
 
 var (
 	// ErrNotFound is returned when a resource can't be found inthe database
-	ErrNotFound = errors.New("models: resource not found")
+	ErrNotFound = newPublicError("models: resource not found",
+		"We couldn't find what you were looking for.", http.StatusNotFound)
 
 	// ErrInvalidID is returned when an invalid ID passed to a method like delete
-	ErrIDInvalid = errors.New("models: ID Provided is invalid")
-
-	// ErrPasswordIncorrect is returned when a user attempts to log in
-	// with the wrong password
-	ErrPasswordIncorrect = errors.New("models: incorrect password provided")
+	ErrIDInvalid error = privateError("models: ID Provided is invalid")
 
 	// ErrEmailRequired is returned when an email address is not provided when creating a user
-	ErrEmailRequired = errors.New("models: Email Address is Required")
+	ErrEmailRequired = newPublicError("models: Email Address is Required",
+		"Please enter an email address.", http.StatusUnprocessableEntity)
 	
 	// ErrEmailInvalid is returned when an email address provided does not match our requirements
-	ErrEmailInvalid = errors.New("models: Email Address is not valid.")
+	ErrEmailInvalid = newPublicError("models: Email Address is not valid.",
+		"That doesn't look like a valid email address.", http.StatusUnprocessableEntity)
 
 	// ErrEmailTaken is returned when the email is already in use
-	ErrEmailTaken = errors.New("models: Email address is already taken")
+	ErrEmailTaken = newPublicError("models: Email address is already taken",
+		"That email address is already taken. Try logging in instead.", http.StatusConflict)
 	
 	// ErrPasswordTooShort is returned when an update or create is 
 	// attempted with a user password that is less than 8 characters
-	ErrPasswordTooShort = errors.New("models: Password must be at least 8 characters long")
+	ErrPasswordTooShort = newPublicError("models: Password must be at least 8 characters long",
+		"Your password must be at least 8 characters long.", http.StatusUnprocessableEntity)
 
 	// ErrPasswordRequired is returned when a create is attempted without
 	// a user password
-	ErrPasswordRequired = errors.New("models: Password is required")
+	ErrPasswordRequired = newPublicError("models: Password is required",
+		"Please enter a password.", http.StatusUnprocessableEntity)
 
 	// ErrRememberTooShort is returned when a remember token is not at least 32 bytes
-	ErrRememberTooShort = errors.New("models: remember token must be at least 32 bytes.")
+	ErrRememberTooShort error = privateError("models: remember token must be at least 32 bytes.")
 
 	//ErrRememberRequired is returned when a create or update is
 	// attempted without a user remember token hash.
-	ErrRememberRequired = errors.New("models: Remember token is required")
+	ErrRememberRequired error = privateError("models: Remember token is required")
 
 
 	// match email addresses. not perfect but good enough
 	emailRegex = regexp.MustCompile(`^[a-z0-9._%+\-]+@` + `[a-z0-9.\-]+\.[a-z]{2,16}$`)
 )
 
-const userPwPepper = "peter-picked-a-peck-of-pickled-peppers"
-const hmacSecretKey = "secret-hmac-key"
-
 // Represents the user model stored in our database
 type User struct {
 	gorm.Model 
@@ -62,8 +60,41 @@ type User struct {
 	Email string `gorm:"not null;unique_index"`
 	Password string `gorm:"-"`
 	PasswordHash string `gorm:"not null"`
-	Remember string `gorm:"-"`
-	RememberHash string `gorm:"not null;unique_index"`
+	EmailVerifiedAt *time.Time
+	// PendingEmail is a new address the user asked for. Email keeps
+	// the old one until the new one is verified.
+	PendingEmail string
+	FailedLogins int `gorm:"not null;default:0"`
+	LockedUntil *time.Time
+	TOTPSecret string `gorm:"-"`
+	TOTPSecretEncrypted string
+	TOTPEnabledAt *time.Time
+	TOTPLastStep int64 `gorm:"not null;default:0"`
+	// NoPassword is set for users created by single sign-on who
+	// never picked a password. Setting one clears it.
+	NoPassword bool `gorm:"not null;default:false"`
+	// Role is one of Roles, it decides what the user is allowed to
+	// do along with any permissions granted to them
+	Role string `gorm:"not null;default:'member'"`
+	// DisabledAt is set while an admin has disabled the account
+	DisabledAt *time.Time
+	// PasswordResetRequired stops the password from working until
+	// the user sets a new one. Setting one clears it.
+	PasswordResetRequired bool `gorm:"not null;default:false"`
+}
+
+// Verified reports whether the user has verified their email address
+func (u *User) Verified() bool {
+	return u.EmailVerifiedAt != nil
+}
+
+// VerifyAddress is where a verification link has to be sent: the
+// pending address while there is one, Email otherwise
+func (u *User) VerifyAddress() string {
+	if u.PendingEmail != "" {
+		return u.PendingEmail
+	}
+	return u.Email
 }
 
 // This will be the database layer
@@ -78,69 +109,370 @@ type UserDB interface {
 	// Methods for querying for single users
 	ByID(id uint) (*User, error)
 	ByEmail(email string) (*User, error)
-	ByRemember(token string) (*User, error)
+
+	// Search returns a page of the users matching the query, in
+	// the order they signed up, and how many match in total
+	Search(q UserQuery) ([]User, int, error)
+
+	// DeletedBefore returns the users that were deleted before t,
+	// which no other method finds any more
+	DeletedBefore(t time.Time) ([]User, error)
+
+	// ByEmailIncludingDeleted is ByEmail but also finds users that
+	// were deleted and not purged yet, who still hold their address
+	ByEmailIncludingDeleted(email string) (*User, error)
 	
 	// Methods for altering users
 	Create(user *User) error 
 	Update(user *User) error
 	Delete(id uint) error 
 
-	// Close a DB connection
-	Close() error
-
-	// Migration helpers
-	// We don't have to put these in there. It's easier for development
-	AutoMigrate() error
-	DestructiveReset() error
+	// Purge removes a deleted user for good, Delete only marks
+	// them as deleted
+	Purge(id uint) error
 }
 
 // UserService is a set of methods used to manipulate and work with the user model
 type UserService interface {
 	// Authenticate will verity the provided email address and password are correct.
 	// If they are correct, the user corresponding to that email will be returned
-	// otherwise you will recieve ErrNotFound,	ErrPasswordIncorrect or other error if something goes wrong
+	// otherwise you will recieve ErrCredentialsInvalid for either one or
+	// while the account is locked, or other error if something goes wrong
 	Authenticate(email, password string) (*User, error)
+
+	// Logout ends the session for the provided remember token
+	Logout(token string) error
+
+	// LogoutAll ends every session the user has, signing them out
+	// on all devices
+	LogoutAll(userID uint) error
+
+	// InitiateReset creates a password reset token for the user with
+	// the provided email address and returns the user and the token.
+	// The token has to be sent to the user's stored address, it is
+	// only stored hashed.
+	InitiateReset(email string) (*User, string, error)
+
+	// CompleteReset sets a new password for the user the token was
+	// issued to, uses up every reset token they have and signs them
+	// out everywhere.
+	// Unknown, used or expired tokens return ErrTokenInvalid.
+	CompleteReset(token, newPw string) (*User, error)
+
+	// InitiateVerify returns a token that verifies the user's
+	// pending or current email address. It has to be sent to
+	// User.VerifyAddress.
+	InitiateVerify(userID uint) (string, error)
+
+	// CompleteVerify marks the user the token was issued to as
+	// verified. When it verifies a pending address, that becomes
+	// the user's email and the old one is returned so they can be
+	// told about the change. Unknown, used or expired tokens return
+	// ErrTokenInvalid.
+	CompleteVerify(token string) (*User, string, error)
+
+	// InitiateTOTP starts setting up two-factor authentication and
+	// returns the new secret and its otpauth:// provisioning URI
+	InitiateTOTP(userID uint) (secret, uri string, err error)
+
+	// EnableTOTP turns on two-factor authentication once the code
+	// matches the secret from InitiateTOTP and returns the user's
+	// recovery codes. They can't be looked up again later.
+	EnableTOTP(userID uint, code string) ([]string, error)
+
+	// DisableTOTP turns off two-factor authentication, it needs a
+	// current code or a recovery code
+	DisableTOTP(userID uint, code string) error
+
+	// InitiateSecondFactor returns the token for the second login
+	// step of a user with two-factor authentication. Only call it
+	// after Authenticate succeeded.
+	InitiateSecondFactor(userID uint) (string, error)
+
+	// CompleteSecondFactor returns the user the token was issued to
+	// when code is their current code or an unused recovery code.
+	// Otherwise it returns ErrTOTPInvalid or ErrTokenInvalid.
+	CompleteSecondFactor(token, code string) (*User, error)
+
+	// LoginExternal returns the user a single sign-on login belongs
+	// to, linking it by verified email address or creating a user
+	// without a password the first time. It returns
+	// ErrExternalEmailUnverified or ErrExternalAccountUnverified
+	// when the login can't be trusted with an account.
+	LoginExternal(login ExternalLogin) (*User, error)
+
+	// SetRole changes the user's role, one of Roles
+	SetRole(userID uint, role string) error
+
+	// GrantPermission gives the user a permission on top of what
+	// their role allows, and RevokePermission takes it back again.
+	// A permission that comes with the role can only be taken away
+	// by changing the role.
+	GrantPermission(userID uint, perm string) error
+	RevokePermission(userID uint, perm string) error
+
+	// Permissions returns everything the user is allowed to do,
+	// from their role and their grants
+	Permissions(user *User) ([]string, error)
+
+	// HasPermission reports whether the user's role or grants
+	// include perm, one of Permissions
+	HasPermission(user *User, perm string) (bool, error)
+
+	// Disable stops the user from logging in and ends all of their
+	// sessions, Enable lets them log in again
+	Disable(userID uint) error
+	Enable(userID uint) error
+
+	// ForcePasswordReset makes the user pick a new password before
+	// they can log in again and signs them out everywhere. It
+	// returns the user and a password reset token that has to be
+	// sent to their Email.
+	ForcePasswordReset(userID uint) (*User, string, error)
+
+	// UpdateProfile changes the user's name and email address and
+	// returns the updated user. A new email address is kept in
+	// PendingEmail until it is verified.
+	UpdateProfile(userID uint, name, email string) (*User, error)
+
+	// CheckPassword returns nil when current is the user's password.
+	// Wrong guesses count towards the lockout the same as a login.
+	// It returns ErrPasswordIncorrect otherwise, or ErrTooManyAttempts
+	// while the account is locked.
+	CheckPassword(userID uint, current string) error
+
+	// ChangePassword sets a new password when current is right and
+	// signs the user out everywhere. It returns ErrPasswordIncorrect
+	// otherwise, or ErrTooManyAttempts while the account is locked.
+	// Users without a password can leave current empty.
+	ChangePassword(userID uint, current, newPw string) error
+
+	// Export returns everything stored about the user, apart from
+	// hashes and secrets, for them to download
+	Export(userID uint) (*UserExport, error)
+
+	// DeleteAccount signs the user out everywhere, unlinks their
+	// single sign-on logins and soft deletes them. PurgeDeleted hard deletes the users deleted before t,
+	// with everything else stored for them, once the grace period
+	// is over. It returns how many users it removed.
+	DeleteAccount(userID uint) error
+	PurgeDeleted(before time.Time) (int, error)
+
+	// VerificationRequired reports whether Authenticate rejects
+	// users that haven't verified their email address yet
+	VerificationRequired() bool
+
+	// ByRemember takes the token from a session cookie and returns
+	// the user it belongs to, or ErrNotFound once that session
+	// expires or is revoked
+	ByRemember(token string) (*User, error)
+
 	UserDB
 }
 
-func NewUserService(connectionInfo string) (UserService, error) {
-	ug, err := newUserGorm(connectionInfo)
-	if err != nil {
-	  return nil, err
-	}
-	// this old line was in newUserGorm
-	hmac := hash.NewHMAC(hmacSecretKey)
-	uv := newUserValidator(ug, hmac)
+// newUserService wraps the provided UserDB with the validation
+// layer and returns the UserService built on top of it. Remember
+// tokens are looked up through the provided sessions.
+func newUserService(udb UserDB, sessions SessionService, pwResetDB pwResetDB, recoveryCodeDB recoveryCodeDB, identityDB identityDB, grantDB permissionGrantDB, apiKeyDB APIKeyDB, hmac hash.Keyring, aead encrypt.AESGCM, pw *passwords) *userService {
+	uv := newUserValidator(udb, aead, pw)
 	return &userService{
 	  UserDB: uv,
-	}, nil
-  }
+	  sessions: sessions,
+	  pwResetDB: pwResetDB,
+	  recoveryCodeDB: recoveryCodeDB,
+	  identityDB: identityDB,
+	  grantDB: grantDB,
+	  apiKeyDB: apiKeyDB,
+	  hmac: hmac,
+	  aead: aead,
+	  pw: pw,
+	}
+}
 
 var _ UserService = &userService{}
 
 type userService struct{
 	UserDB
+	sessions SessionService
+	pwResetDB pwResetDB
+	recoveryCodeDB recoveryCodeDB
+	identityDB identityDB
+	grantDB permissionGrantDB
+	// apiKeyDB is only used to export and purge a user's keys
+	apiKeyDB APIKeyDB
+	hmac hash.Keyring
+	// aead decrypts TOTP secrets
+	aead encrypt.AESGCM
+	pw *passwords
+	// requireVerified makes Authenticate return ErrEmailNotVerified
+	// for users that haven't verified their email address
+	requireVerified bool
+}
+
+// ByRemember looks up the session for the provided remember token,
+// marks it as seen and returns the user it belongs to
+func (us *userService) ByRemember(token string) (*User, error) {
+	session, err := us.sessions.ByToken(token)
+	if err != nil {
+		return nil, err
+	}
+	user, err := us.ByID(session.UserID)
+	if err != nil {
+		return nil, err
+	}
+	if user.Disabled() {
+		return nil, ErrNotFound
+	}
+	if err := us.sessions.Touch(session); err != nil {
+		return nil, err
+	}
+	return user, nil
 }
 
 // Autheticate the user with an email and password
 func (us *userService) Authenticate(email, password string) (*User, error){
 	foundUser, err := us.ByEmail(email)
+	if err == ErrNotFound {
+		// Take as long as a real check so the response time doesn't
+		// give away which addresses have an account
+		us.pw.checkDummy(password)
+		return nil, ErrCredentialsInvalid
+	}
 	if err != nil {
 		return nil, err
 	}
+	// A locked account looks the same as an unknown one, saying
+	// it's locked would give away that it exists
+	if foundUser.Locked() {
+		us.pw.checkDummy(password)
+		return nil, ErrCredentialsInvalid
+	}
+	if foundUser.NoPassword {
+		// Only single sign-on works until they set a password
+		us.pw.checkDummy(password)
+		return nil, ErrCredentialsInvalid
+	}
 	
-	err = bcrypt.CompareHashAndPassword([]byte(foundUser.PasswordHash), []byte(password + userPwPepper))
-	if err != nil {	
-		switch err{
-		case bcrypt.ErrMismatchedHashAndPassword:
-			return nil, ErrPasswordIncorrect
-		default:
+	ok, rehash, err := us.pw.check(foundUser.PasswordHash, password)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		if err := us.recordFailedLogin(foundUser); err != nil {
 			return nil, err
 		}
+		return nil, ErrCredentialsInvalid
+	}
+	// Only say so once the password was right, so these don't give
+	// away who has an account
+	if foundUser.Disabled() {
+		return nil, ErrAccountDisabled
+	}
+	if foundUser.PasswordResetRequired {
+		return nil, ErrPasswordResetRequired
+	}
+	// This is the only time we have the password, so upgrade hashes
+	// made with an old algorithm, cost or pepper now
+	if rehash {
+		hash, err := us.pw.hash(password)
+		if err != nil {
+			return nil, err
+		}
+		foundUser.PasswordHash = hash
+		if err := us.Update(foundUser); err != nil {
+			return nil, err
+		}
+	}
+	// With two-factor authentication the count is only reset once
+	// the code is right too, otherwise logging in again would give
+	// unlimited guesses at the code
+	if !foundUser.TOTPEnabled() {
+		if err := us.resetFailedLogins(foundUser); err != nil {
+			return nil, err
+		}
+	}
+	if us.requireVerified && !foundUser.Verified() {
+		return nil, ErrEmailNotVerified
 	}
 	return foundUser, nil
 }
 
+func (us *userService) VerificationRequired() bool {
+	return us.requireVerified
+}
+
+// Logout deletes the session the token belongs to. Tokens that
+// are already expired or revoked are not an error.
+func (us *userService) Logout(token string) error {
+	session, err := us.sessions.ByToken(token)
+	if err == ErrNotFound {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return us.sessions.Delete(session.ID)
+}
+
+// LogoutAll deletes every session for the user
+func (us *userService) LogoutAll(userID uint) error {
+	return us.sessions.DeleteByUserID(userID)
+}
+
+func (us *userService) InitiateReset(email string) (*User, string, error) {
+	user, err := us.ByEmail(email)
+	if err != nil {
+		return nil, "", err
+	}
+	pwr := pwReset{
+		UserID: user.ID,
+	}
+	if err := us.pwResetDB.Create(&pwr); err != nil {
+		return nil, "", err
+	}
+	return user, pwr.Token, nil
+}
+
+func (us *userService) CompleteReset(token, newPw string) (*User, error) {
+	if newPw == "" {
+		return nil, ErrPasswordRequired
+	}
+	pwr, err := us.pwResetDB.ByToken(token)
+	if err != nil {
+		if err == ErrNotFound {
+			return nil, ErrTokenInvalid
+		}
+		return nil, err
+	}
+	user, err := us.ByID(pwr.UserID)
+	if err != nil {
+		return nil, err
+	}
+	// A password that's too short shouldn't cost them the link
+	if err := checkPasswordLength(newPw); err != nil {
+		return nil, err
+	}
+	// These writes aren't atomic, so the tokens are used up first.
+	// If a later step fails the user asks for a new link, but no
+	// link ever works twice.
+	if err := us.pwResetDB.DeleteByUserID(user.ID); err != nil {
+		return nil, err
+	}
+	// Update runs passwordMinLength and hashPassword
+	user.Password = newPw
+	// Proving they own the email address lifts any lockout
+	user.FailedLogins = 0
+	user.LockedUntil = nil
+	if err := us.Update(user); err != nil {
+		return nil, err
+	}
+	// Whoever knew the old password shouldn't stay signed in
+	if err := us.LogoutAll(user.ID); err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
 func runUserValFuncs(user *User, fns ...userValFunc) error {
 	for _, fn := range fns {
 		if err := fn(user); err != nil {
@@ -152,18 +484,20 @@ func runUserValFuncs(user *User, fns ...userValFunc) error {
 
 var _ UserDB = &userValidator{}
 
-func newUserValidator(udb UserDB, hmac hash.HMAC) *userValidator {
+func newUserValidator(udb UserDB, aead encrypt.AESGCM, pw *passwords) *userValidator {
 	return &userValidator{
 		UserDB: udb,
-		hmac: 	hmac, 
+		aead: aead,
+		pw: pw,
 		emailRegex: regexp.MustCompile(`^[a-z0-9._%+\-]+@` + `[a-z0-9.\-]+\.[a-z]{2,16}$`),
 	}
 }
 
 type userValidator struct {
 	UserDB
-	hmac hash.HMAC
+	aead encrypt.AESGCM
 	emailRegex *regexp.Regexp
+	pw *passwords
 }
 
 // ByEmail will normalize the email address before calling ByEmail on the UserDB field
@@ -177,33 +511,31 @@ func (uv *userValidator) ByEmail(email string) (*User, error) {
 	return uv.UserDB.ByEmail(user.Email)
 }
 
-// By remember will hash the remember token and call 
-// ByRemember on the UserDB layer
-func (uv *userValidator) ByRemember(token string) (*User, error) {
-	user := User {
-		Remember: token,
+// ByEmailIncludingDeleted normalizes the email address the same way
+// as ByEmail
+func (uv *userValidator) ByEmailIncludingDeleted(email string) (*User, error) {
+	user := User{
+		Email: email,
 	}
-
-	if err := runUserValFuncs(&user, uv.hmacRemember); err != nil {
+	if err := runUserValFuncs(&user, uv.normalizeEmail); err != nil{
 		return nil, err
 	}
-	return uv.UserDB.ByRemember(user.RememberHash)
+	return uv.UserDB.ByEmailIncludingDeleted(user.Email)
 }
 
 func (uv *userValidator) Create(user *User) error {
 	err := runUserValFuncs(user, 
 		uv.passwordRequired,
 		uv.passwordMinLength,
-		uv.bcryptPassword, 
-		uv.setRememberIfUnset,
-		uv.rememberMinBytes,
+		uv.hashPassword,
 		uv.passwordHashRequired,
-		uv.hmacRemember,
-		uv.rememberHashRequired,
 		uv.normalizeEmail,
 		uv.requireEmail,
 		uv.emailFormat,
-		uv.emailIsAvail)
+		uv.emailIsAvail,
+		uv.normalizeRole,
+		uv.roleValid,
+		uv.encryptTOTPSecret)
 	if err != nil {
 		return err
 	}
@@ -215,14 +547,15 @@ func (uv *userValidator) Create(user *User) error {
 func (uv *userValidator) Update(user *User) error {
 	err := runUserValFuncs(user,
 		uv.passwordMinLength,
-		uv.bcryptPassword,
+		uv.hashPassword,
 		uv.passwordHashRequired,
-		uv.rememberMinBytes,
-		uv.hmacRemember,
-		uv.rememberHashRequired,
 		uv.normalizeEmail,
 		uv.emailFormat,
-		uv.emailIsAvail)
+		uv.emailIsAvail,
+		uv.pendingEmail,
+		uv.normalizeRole,
+		uv.roleValid,
+		uv.encryptTOTPSecret)
 	if err != nil {
 		return err
 	}
@@ -231,15 +564,6 @@ func (uv *userValidator) Update(user *User) error {
 
 type userValFunc func(*User) error
 
-
-func (uv *userValidator) hmacRemember(user *User) error {
-	if user.Remember == "" {
-		return nil
-	}
-	user.RememberHash = uv.hmac.Hash(user.Remember)
-	return nil
-}
-
 // Delete the user with the provided ID
 func (uv *userValidator) Delete(id uint) error {
 	// if you pass a 0 id, gorm will delete all users
@@ -254,83 +578,79 @@ func (uv *userValidator) Delete(id uint) error {
 	return uv.UserDB.Delete(id)
 }
 
-// bcryptPassword will has a users password with a predifined Pepper (userPwPepper)
-// if the password field is not the empty string.
-func (uv *userValidator) bcryptPassword(user *User) error {
+// Purge the user with the provided ID, with the same check as Delete
+func (uv *userValidator) Purge(id uint) error {
+	var user User
+	user.ID = id
+	if err := runUserValFuncs(&user, uv.idGreaterThanZero); err != nil {
+		return err
+	}
+	return uv.UserDB.Purge(id)
+}
+
+// hashPassword will hash a users password with the configured
+// hasher and pepper if the password field is not the empty string.
+func (uv *userValidator) hashPassword(user *User) error {
 	// only hash a password if it exists
 	if user.Password == "" {
 		return nil
 	}
-	// Add the password pepper
-	pwBytes := []byte(user.Password + userPwPepper) 
-	hashedBytes, err := bcrypt.GenerateFromPassword(pwBytes, bcrypt.DefaultCost)
+	hash, err := uv.pw.hash(user.Password)
 	if err != nil {
 		return err
 	}
-	user.PasswordHash = string(hashedBytes)
+	user.PasswordHash = hash
+	user.NoPassword = false
+	user.PasswordResetRequired = false
 	user.Password = "" // This isn't required, it's to prevent accidentally writing passwords to logs
 	return nil
 }
 
-func (uv *userValidator) passwordMinLength(user *User) error {
-	if user.Password == "" {
+// encryptTOTPSecret encrypts the TOTP secret if the TOTPSecret
+// field is not the empty string
+func (uv *userValidator) encryptTOTPSecret(user *User) error {
+	if user.TOTPSecret == "" {
 		return nil
 	}
-	if len(user.Password) < 8 {
-		return ErrPasswordTooShort
+	encrypted, err := uv.aead.Encrypt(user.TOTPSecret)
+	if err != nil {
+		return err
 	}
+	user.TOTPSecretEncrypted = encrypted
+	user.TOTPSecret = ""
 	return nil
 }
 
-func (uv *userValidator) passwordRequired(user *User) error {
+func (uv *userValidator) passwordMinLength(user *User) error {
 	if user.Password == "" {
-		return ErrPasswordRequired
+		return nil
 	}
-	return nil
+	return checkPasswordLength(user.Password)
 }
 
-func (uv *userValidator) rememberHashRequired(user *User) error {
-	if user.RememberHash == "" {
-		return ErrRememberRequired
+// checkPasswordLength returns ErrPasswordTooShort unless password
+// is at least 8 characters long
+func checkPasswordLength(password string) error {
+	if len(password) < 8 {
+		return ErrPasswordTooShort
 	}
 	return nil
 }
 
-func (uv *userValidator) passwordHashRequired(user *User) error {
-	if user.PasswordHash == "" {
+func (uv *userValidator) passwordRequired(user *User) error {
+	if user.Password == "" && !user.NoPassword {
 		return ErrPasswordRequired
 	}
 	return nil
 }
 
-func (uv *userValidator) setRememberIfUnset(user *User) error {
-	if user.Remember != ""{
-		return nil
-	}
-	token, err := rand.RememberToken()
-	if err != nil {
-		return err
-	}
-	user.Remember = token
-	return nil
-}
-
-
-func (uv *userValidator) rememberMinBytes(user *User) error {
-	if user.Remember == ""{
-		return nil
-	}
-	n, err := rand.NBytes(user.Remember)
-	if err != nil {
-		return err
-	}
-	if n < 32 {
-		return ErrRememberTooShort
+func (uv *userValidator) passwordHashRequired(user *User) error {
+	if user.PasswordHash == "" && !user.NoPassword {
+		return ErrPasswordRequired
 	}
 	return nil
 }
 
-
 func (uv *userValidator) idGreaterThanZero(user *User) error {
 	// if you pass a 0 id, gorm will delete all users
 	// we must check that the user exists 
@@ -363,7 +683,9 @@ func (uv *userValidator) emailFormat(user *User) error {
 }
 
 func (uv *userValidator) emailIsAvail(user *User) error {
-	existing, err := uv.ByEmail(user.Email)
+	// Deleted users keep their address until they are purged, the
+	// unique index doesn't care about deleted_at
+	existing, err := uv.ByEmailIncludingDeleted(user.Email)
 	if err == ErrNotFound {
 		// EMail adderss is not taken
 		return nil
@@ -380,20 +702,32 @@ func (uv *userValidator) emailIsAvail(user *User) error {
 	return nil
 }
 
-func newUserGorm(connectionInfo string) (*userGorm, error){
-	db, err := gorm.Open("postgres", connectionInfo)
+// pendingEmail checks a new address the same way as Email. Asking
+// for the current address again cancels the change.
+func (uv *userValidator) pendingEmail(user *User) error {
+	if user.PendingEmail == "" {
+		return nil
+	}
+	pending := User{Email: user.PendingEmail}
+	pending.ID = user.ID
+	err := runUserValFuncs(&pending,
+		uv.normalizeEmail,
+		uv.emailFormat,
+		uv.emailIsAvail)
 	if err != nil {
-		return nil, err
+		return err
+	}
+	if pending.Email == user.Email {
+		pending.Email = ""
 	}
-	db.LogMode(true)
-	return &userGorm{
-		db: db, 
-	}, nil
+	user.PendingEmail = pending.Email
+	return nil
 }
 
+var _ UserDB = &userGorm{}
+
 type userGorm struct {
 	db *gorm.DB
-	hmac hash.HMAC
 }
 
 
@@ -416,17 +750,6 @@ func (ug *userGorm) ByEmail(email string) (*User, error) {
 	return &user, err
 }
 
-// ByRemember looks up a user with the given remember token
-// and returns that user. This method expects the remember
-// token to already be hashed.
-func (ug *userGorm) ByRemember(rememberHash string) (*User, error) {
-	var user User
-	err := first(ug.db.Where("remember_hash = ?", rememberHash), &user)
-	if err != nil {
-	  return nil, err
-	}
-	return &user, nil
-  }
 
 
 // Private method
@@ -449,36 +772,58 @@ func (ug *userGorm) Create(user *User) error{
 }
 
 
-// Delete the user with the provided ID
-func (ug *userGorm) Delete(id uint) error{
-	user := User{Model: gorm.Model{ID: id}}
-	return ug.db.Delete(user.ID).Error
+// Search matches the query against the lower cased name and the
+// email address, which is always stored lower case
+func (ug *userGorm) Search(q UserQuery) ([]User, int, error) {
+	db := ug.db.Model(&User{})
+	if q.Search != "" {
+		pattern := "%" + likeEscaper.Replace(q.Search) + "%"
+		db = db.Where("LOWER(name) LIKE ? ESCAPE '!' OR email LIKE ? ESCAPE '!'", pattern, pattern)
+	}
+	var total int
+	if err := db.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+	var users []User
+	err := db.Order("id").Offset(q.Offset).Limit(q.Limit).Find(&users).Error
+	if err != nil {
+		return nil, 0, err
+	}
+	return users, total, nil
 }
 
-func (ug *userGorm) Update(user *User) error {
-	return ug.db.Save(user).Error
-  }
-
-// Closes the user service database connection
-func (ug *userGorm) Close() error{
-	return ug.db.Close()
+// ByEmailIncludingDeleted looks up a user by email whether or not
+// they were soft deleted
+func (ug *userGorm) ByEmailIncludingDeleted(email string) (*User, error) {
+	var user User
+	db := ug.db.Unscoped().Where("email = ?", email)
+	err := first(db, &user)
+	return &user, err
 }
 
-// Drops the user table and rebuilds it
-func (ug *userGorm) DestructiveReset() error {
-	if err := ug.db.DropTableIfExists(&User{}).Error; err != nil {
-		return err
+// DeletedBefore finds the soft deleted users, which gorm leaves
+// out of every other query
+func (ug *userGorm) DeletedBefore(t time.Time) ([]User, error) {
+	var users []User
+	db := ug.db.Unscoped().Where("deleted_at IS NOT NULL AND deleted_at < ?", t)
+	if err := db.Order("id").Find(&users).Error; err != nil {
+		return nil, err
 	}
-	// Uses the automigrate we wrote in case we ever need to change the
-	// AutoMigrate function
-	return ug.AutoMigrate()
+	return users, nil
 }
 
-// AutoMigrate will attempt to automatically migrate the users table
-func (ug *userGorm) AutoMigrate() error {
-	if err := ug.db.AutoMigrate(&User{}).Error; err != nil {
-		return err
-	}
-	return nil
+// Delete the user with the provided ID
+func (ug *userGorm) Delete(id uint) error{
+	user := User{Model: gorm.Model{ID: id}}
+	return ug.db.Delete(&user).Error
+}
+
+// Purge deletes the row instead of setting deleted_at
+func (ug *userGorm) Purge(id uint) error {
+	user := User{Model: gorm.Model{ID: id}}
+	return ug.db.Unscoped().Delete(&user).Error
 }
 
+func (ug *userGorm) Update(user *User) error {
+	return ug.db.Save(user).Error
+  }
@@ -0,0 +1,229 @@
+package models
+
+import (
+	"errors"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	// errMemDuplicate is returned by the in-memory stores when a write
+	// would break one of the unique indexes declared on a model, the
+	// same way Postgres rejects the insert for the gorm layer.
+	errMemDuplicate = errors.New("models: duplicate key value violates unique constraint")
+)
+
+var _ UserDB = &userMem{}
+
+// newUserMem returns an empty in-memory UserDB.
+func newUserMem() *userMem {
+	return &userMem{
+		users: make(map[uint]User),
+	}
+}
+
+// userMem is a UserDB that keeps every user in a map instead of a
+// database. It is safe for concurrent use and mirrors what userGorm
+// does with gorm.Model: IDs are assigned on create, CreatedAt and
+// UpdatedAt are filled in, Email is unique and
+// Delete only sets DeletedAt.
+//
+// Users are stored by value so callers can't change what is stored
+// by holding on to a pointer.
+type userMem struct {
+	mu     sync.RWMutex
+	users  map[uint]User
+	lastID uint
+}
+
+// ByID will look up a user by the id provided
+func (um *userMem) ByID(id uint) (*User, error) {
+	return um.find(func(u *User) bool { return u.ID == id })
+}
+
+// ByEmail will look up a user by the email provided
+func (um *userMem) ByEmail(email string) (*User, error) {
+	return um.find(func(u *User) bool { return u.Email == email })
+}
+
+// Search returns the users that aren't deleted and whose name or
+// email contains the query, by ID
+func (um *userMem) Search(q UserQuery) ([]User, int, error) {
+	um.mu.RLock()
+	defer um.mu.RUnlock()
+	var matches []User
+	for _, u := range um.users {
+		if u.DeletedAt != nil {
+			continue
+		}
+		if strings.Contains(strings.ToLower(u.Name), q.Search) || strings.Contains(u.Email, q.Search) {
+			matches = append(matches, u)
+		}
+	}
+	sort.Slice(matches, func(i, j int) bool {
+		return matches[i].ID < matches[j].ID
+	})
+	total := len(matches)
+	if q.Offset >= total {
+		return nil, total, nil
+	}
+	matches = matches[q.Offset:]
+	if len(matches) > q.Limit {
+		matches = matches[:q.Limit]
+	}
+	return matches, total, nil
+}
+
+// ByEmailIncludingDeleted will look up a user by the email
+// provided, even if they were deleted
+func (um *userMem) ByEmailIncludingDeleted(email string) (*User, error) {
+	um.mu.RLock()
+	defer um.mu.RUnlock()
+	for _, u := range um.users {
+		if u.Email == email {
+			return &u, nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+// DeletedBefore returns the deleted users whose DeletedAt is
+// before t, by ID
+func (um *userMem) DeletedBefore(t time.Time) ([]User, error) {
+	um.mu.RLock()
+	defer um.mu.RUnlock()
+	var deleted []User
+	for _, u := range um.users {
+		if u.DeletedAt != nil && u.DeletedAt.Before(t) {
+			deleted = append(deleted, u)
+		}
+	}
+	sort.Slice(deleted, func(i, j int) bool {
+		return deleted[i].ID < deleted[j].ID
+	})
+	return deleted, nil
+}
+
+// find returns a copy of the first user that isn't deleted and
+// matches fn, or ErrNotFound.
+func (um *userMem) find(fn func(*User) bool) (*User, error) {
+	um.mu.RLock()
+	defer um.mu.RUnlock()
+	for _, u := range um.users {
+		if u.DeletedAt != nil || !fn(&u) {
+			continue
+		}
+		found := u
+		return &found, nil
+	}
+	return nil, ErrNotFound
+}
+
+// Create stores the provided user and backfills the ID,
+// CreatedAt and UpdatedAt fields
+func (um *userMem) Create(user *User) error {
+	um.mu.Lock()
+	defer um.mu.Unlock()
+	return um.create(user)
+}
+
+func (um *userMem) create(user *User) error {
+	if user.ID != 0 {
+		if _, ok := um.users[user.ID]; ok {
+			return errMemDuplicate
+		}
+	}
+	if err := um.checkUnique(user); err != nil {
+		return err
+	}
+	now := time.Now()
+	if user.ID == 0 {
+		um.lastID++
+		user.ID = um.lastID
+	} else if user.ID > um.lastID {
+		um.lastID = user.ID
+	}
+	if user.CreatedAt.IsZero() {
+		user.CreatedAt = now
+	}
+	user.UpdatedAt = now
+	um.store(user)
+	return nil
+}
+
+// Update saves every field of the provided user. Like gorm's Save
+// a user that doesn't exist yet is created.
+func (um *userMem) Update(user *User) error {
+	um.mu.Lock()
+	defer um.mu.Unlock()
+	existing, ok := um.users[user.ID]
+	if !ok {
+		return um.create(user)
+	}
+	if existing.DeletedAt != nil {
+		return errMemDuplicate
+	}
+	if err := um.checkUnique(user); err != nil {
+		return err
+	}
+	user.UpdatedAt = time.Now()
+	um.store(user)
+	return nil
+}
+
+// Delete soft deletes the user with the provided ID. Deleting a
+// user that doesn't exist is not an error, same as with gorm.
+func (um *userMem) Delete(id uint) error {
+	um.mu.Lock()
+	defer um.mu.Unlock()
+	user, ok := um.users[id]
+	if !ok || user.DeletedAt != nil {
+		return nil
+	}
+	now := time.Now()
+	user.DeletedAt = &now
+	um.users[id] = user
+	return nil
+}
+
+// Purge removes the user with the provided ID from the map
+func (um *userMem) Purge(id uint) error {
+	um.mu.Lock()
+	defer um.mu.Unlock()
+	delete(um.users, id)
+	return nil
+}
+
+// checkUnique makes sure no other user, deleted or not, already
+// has the same Email. The unique index in the database doesn't
+// care about deleted_at either.
+func (um *userMem) checkUnique(user *User) error {
+	for id, u := range um.users {
+		if id == user.ID {
+			continue
+		}
+		if u.Email == user.Email {
+			return errMemDuplicate
+		}
+	}
+	return nil
+}
+
+// store saves a copy of user without the fields that gorm
+// would never write to the database.
+func (um *userMem) store(user *User) {
+	u := *user
+	u.Password = ""
+	u.TOTPSecret = ""
+	um.users[u.ID] = u
+}
+
+// reset removes every user
+func (um *userMem) reset() {
+	um.mu.Lock()
+	defer um.mu.Unlock()
+	um.users = make(map[uint]User)
+	um.lastID = 0
+}
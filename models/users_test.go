@@ -1,33 +1,36 @@
 package models
 
 import (
-	"fmt"
+	"sync"
 	"testing"
 	"time"
 )
 
-func testingUserService() (*UserService, error){
-	const (
-		host = "localhost"
-		port = 5432
-		user = "postgres"
-		password = "alexnoah"
-		dbname = "databot_test"
-	)
+const (
+	testPepper = "test-pepper"
+	testHMACKey = "test-hmac-key"
+	testEncryptionKey = "test-encryption-key"
+)
 
-	psqlInfo := fmt.Sprintf("host=%s port=%d user=%s "+
-	"password=%s dbname=%s sslmode=disable",
-	host, port, user, password, dbname)
+func testingServices() (*Services, error){
+	return NewServices(
+		WithMemory(),
+		WithUser(testPepper, testHMACKey),
+		WithEncryptionKey(testEncryptionKey),
+	)
+}
 
-	us, err := NewUserService(psqlInfo)
-	if err != nil{
+func testingUserService() (UserService, error){
+	s, err := testingServices()
+	if err != nil {
 		return nil, err
 	}
-	us.db.LogMode(false)
 
 	//Clear the users table between tests
-	us.DestructiveReset()
-	return us, nil
+	if err := s.DestructiveReset(); err != nil {
+		return nil, err
+	}
+	return s.User, nil
 }
 
 func TestCreateUser(t *testing.T) {
@@ -35,11 +38,12 @@ func TestCreateUser(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
-	
+
 	user := User{
 		Name: "Michael Scott",
 		Email: "michael@dundermifflin.com",
-	}	
+		Password: "thatswhatshesaid",
+	}
 
 	err = us.Create(&user)
 	if err != nil {
@@ -63,21 +67,21 @@ func TestCreateUser(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	// Get a new user object from the DB 
+	// Get a new user object from the DB
 	userByID, err := us.ByID(user.ID)
 	if err != nil{
-		panic(err)
+		t.Fatal(err)
 	}
-	
+
 
 	if userByID.Email != "michael@michaelscottpaperco.com"{
 		t.Errorf("Expected Email to be michael@michaelscottpaperco.com. Received %s", userByID.Email)
 	}
 
-	// Get a new user object from the DB 
+	// Get a new user object from the DB
 	userByEmail, err := us.ByEmail("michael@michaelscottpaperco.com")
 	if err != nil{
-		panic(err)
+		t.Fatal(err)
 	}
 
 	if userByEmail == nil {
@@ -85,4 +89,197 @@ func TestCreateUser(t *testing.T) {
 	}
 
 
-}
\ No newline at end of file
+}
+
+func TestUserServiceAuthenticate(t *testing.T) {
+	us, err := testingUserService()
+	if err != nil {
+		t.Fatal(err)
+	}
+	user := User{
+		Name: "Dwight Schrute",
+		Email: " Dwight@DunderMifflin.com",
+		Password: "bearsbeetsbattlestar",
+	}
+	if err := us.Create(&user); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := us.Authenticate("dwight@dundermifflin.com", "wrongpassword"); err != ErrCredentialsInvalid {
+		t.Errorf("Expected ErrCredentialsInvalid. Received %v", err)
+	}
+	// Unknown addresses get the same error as wrong passwords
+	if _, err := us.Authenticate("jim@dundermifflin.com", "bearsbeetsbattlestar"); err != ErrCredentialsInvalid {
+		t.Errorf("Expected ErrCredentialsInvalid. Received %v", err)
+	}
+	found, err := us.Authenticate("dwight@dundermifflin.com", "bearsbeetsbattlestar")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if found.ID != user.ID {
+		t.Errorf("Expected user %d. Received %d", user.ID, found.ID)
+	}
+	if found.Password != "" {
+		t.Errorf("Expected Password to not be stored")
+	}
+}
+
+func TestUserServiceEmailTaken(t *testing.T) {
+	us, err := testingUserService()
+	if err != nil {
+		t.Fatal(err)
+	}
+	jim := User{Email: "jim@dundermifflin.com", Password: "beesly4ever"}
+	if err := us.Create(&jim); err != nil {
+		t.Fatal(err)
+	}
+	pam := User{Email: "JIM@dundermifflin.com", Password: "beesly4ever"}
+	if err := us.Create(&pam); err != ErrEmailTaken {
+		t.Errorf("Expected ErrEmailTaken. Received %v", err)
+	}
+}
+
+func TestUserMemDelete(t *testing.T) {
+	us, err := testingUserService()
+	if err != nil {
+		t.Fatal(err)
+	}
+	user := User{Email: "toby@dundermifflin.com", Password: "costarica123"}
+	if err := us.Create(&user); err != nil {
+		t.Fatal(err)
+	}
+	if err := us.Delete(0); err != ErrIDInvalid {
+		t.Errorf("Expected ErrIDInvalid. Received %v", err)
+	}
+	if err := us.Delete(user.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := us.ByID(user.ID); err != ErrNotFound {
+		t.Errorf("Expected ErrNotFound after delete. Received %v", err)
+	}
+
+	// The unique index still covers soft deleted rows, so the
+	// validator checks them too
+	again := User{Email: "toby@dundermifflin.com", Password: "costarica123"}
+	if err := us.Create(&again); err != ErrEmailTaken {
+		t.Errorf("Expected ErrEmailTaken. Received %v", err)
+	}
+}
+
+// Run with -race to catch unsynchronized access to the user map
+func TestUserMemConcurrent(t *testing.T) {
+	um := newUserMem()
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			user := User{
+				Email: string(rune('a'+i)) + "@dundermifflin.com",
+			}
+			if err := um.Create(&user); err != nil {
+				t.Error(err)
+				return
+			}
+			if _, err := um.ByEmail(user.Email); err != nil {
+				t.Error(err)
+			}
+		}(i)
+	}
+	wg.Wait()
+	if len(um.users) != 20 {
+		t.Errorf("Expected 20 users. Received %d", len(um.users))
+	}
+}
+
+// The gorm layer is exercised against SQLite so it runs in CI
+// without a database server
+func TestUserGormSQLite(t *testing.T) {
+	s, err := NewServices(
+		WithGorm(DialectSQLite, "file::memory:?cache=shared"),
+		WithLogMode(false),
+		WithUser(testPepper, testHMACKey),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	if err := s.DestructiveReset(); err != nil {
+		t.Fatal(err)
+	}
+	indexes := map[string]string{
+		"uix_users_email": "users",
+		"uix_sessions_token_hash": "sessions",
+	}
+	for idx, table := range indexes {
+		if !s.db.Dialect().HasIndex(table, idx) {
+			t.Errorf("Expected index %s to be created", idx)
+		}
+	}
+
+	// Databases from before sessions still have remember_hash,
+	// existing users have to survive dropping it
+	old := User{Email: "phyllis@dundermifflin.com", PasswordHash: "x"}
+	if err := (&userGorm{db: s.db}).Create(&old); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.db.Exec("ALTER TABLE users ADD COLUMN remember_hash varchar(255) NOT NULL DEFAULT ''").Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := s.db.Model(&User{}).AddUniqueIndex("uix_users_remember_hash", "remember_hash").Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := s.AutoMigrate(); err != nil {
+		t.Fatal(err)
+	}
+	if s.db.Dialect().HasColumn("users", "remember_hash") {
+		t.Errorf("Expected AutoMigrate to drop remember_hash")
+	}
+	if _, err := s.User.ByEmail(old.Email); err != nil {
+		t.Errorf("Expected existing users to be kept. Received %v", err)
+	}
+	if !s.db.Dialect().HasIndex("users", "uix_users_email") {
+		t.Errorf("Expected the email index to be recreated")
+	}
+
+	us := s.User
+	user := User{Email: "stanley@dundermifflin.com", Password: "pretzelday"}
+	if err := us.Create(&user); err != nil {
+		t.Fatal(err)
+	}
+	session := Session{UserID: user.ID}
+	if err := s.Session.Create(&session); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := us.ByRemember(session.Token); err != nil {
+		t.Fatal(err)
+	}
+
+	// Skip the validator to make sure the database enforces the index
+	dup := User{Email: user.Email, PasswordHash: "x"}
+	ug := &userGorm{db: s.db}
+	if err := ug.Create(&dup); err == nil {
+		t.Errorf("Expected duplicate email to be rejected by the unique index")
+	}
+
+	if err := us.Delete(user.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := us.ByID(user.ID); err != ErrNotFound {
+		t.Errorf("Expected ErrNotFound after delete. Received %v", err)
+	}
+	// The deleted row still holds the address in the unique index
+	again := User{Email: user.Email, Password: "pretzelday"}
+	if err := us.Create(&again); err != ErrEmailTaken {
+		t.Errorf("Expected ErrEmailTaken for a deleted user's address. Received %v", err)
+	}
+}
+
+func TestNewServicesDialect(t *testing.T) {
+	if _, err := NewServices(WithGorm("mssql", "")); err != ErrDialectUnsupported {
+		t.Errorf("Expected ErrDialectUnsupported. Received %v", err)
+	}
+	if _, err := NewServices(WithUser(testPepper, testHMACKey)); err != ErrStorageRequired {
+		t.Errorf("Expected ErrStorageRequired. Received %v", err)
+	}
+}
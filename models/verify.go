@@ -0,0 +1,81 @@
+package models
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+)
+
+var (
+	// ErrEmailNotVerified is returned by Authenticate when email
+	// verification is required and the user hasn't clicked the
+	// link we sent them yet
+	ErrEmailNotVerified = newPublicError("models: email address is not verified",
+		"Please verify your email address first. We just sent you a new link.", http.StatusForbidden)
+
+	// ErrEmailAlreadyVerified is returned when a verification link
+	// is requested for a user that is already verified
+	ErrEmailAlreadyVerified = newPublicError("models: email address is already verified",
+		"Your email address is already verified.", http.StatusConflict)
+)
+
+// verifyDuration is how long an email verification link can be used
+const verifyDuration = 72 * time.Hour
+
+// verifyPurpose is what verification tokens are signed for
+const verifyPurpose = "verify"
+
+// InitiateVerify returns a token that verifies the user's pending
+// email address, or their current one if they haven't verified it
+// yet. The token isn't stored anywhere, it carries the user ID and
+// the email address.
+func (us *userService) InitiateVerify(userID uint) (string, error) {
+	user, err := us.ByID(userID)
+	if err != nil {
+		return "", err
+	}
+	if user.PendingEmail == "" && user.Verified() {
+		return "", ErrEmailAlreadyVerified
+	}
+	return us.signToken(verifyPurpose, verifyDuration, fmt.Sprint(user.ID), user.VerifyAddress())
+}
+
+// CompleteVerify marks the email address in the token as verified.
+// For a pending address that means swapping it in as the user's
+// email, and the address it replaced is returned. A token can only
+// be used once: it is rejected once its address is verified, and
+// after the user asks for another one.
+func (us *userService) CompleteVerify(token string) (*User, string, error) {
+	fields, err := us.parseToken(verifyPurpose, token, 2)
+	if err != nil {
+		return nil, "", err
+	}
+	userID, err := tokenUserID(fields[0])
+	if err != nil {
+		return nil, "", err
+	}
+	user, err := us.ByID(userID)
+	if err == ErrNotFound {
+		return nil, "", ErrTokenInvalid
+	}
+	if err != nil {
+		return nil, "", err
+	}
+	var previous string
+	switch {
+	case user.PendingEmail != "" && user.PendingEmail == fields[1]:
+		previous = user.Email
+		user.Email = user.PendingEmail
+		user.PendingEmail = ""
+	case user.Verified() || user.Email != fields[1]:
+		return nil, "", ErrTokenInvalid
+	}
+	now := time.Now()
+	user.EmailVerifiedAt = &now
+	// emailIsAvail runs again, someone else may have taken the
+	// pending address since
+	if err := us.Update(user); err != nil {
+		return nil, "", err
+	}
+	return user, previous, nil
+}
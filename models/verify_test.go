@@ -0,0 +1,107 @@
+package models
+
+import (
+	"testing"
+)
+
+func TestEmailVerification(t *testing.T) {
+	s, err := NewServices(
+		WithMemory(),
+		WithUser(testPepper, testHMACKey),
+		WithRequireVerified(true),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	user := User{Email: "oscar@dundermifflin.com", Password: "actually"}
+	if err := s.User.Create(&user); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.User.Authenticate(user.Email, "actually"); err != ErrEmailNotVerified {
+		t.Errorf("Expected ErrEmailNotVerified. Received %v", err)
+	}
+
+	token, err := s.User.InitiateVerify(user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := s.User.CompleteVerify(token + "x"); err != ErrTokenInvalid {
+		t.Errorf("Expected ErrTokenInvalid for a tampered token. Received %v", err)
+	}
+	verified, previous, err := s.User.CompleteVerify(token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !verified.Verified() || previous != "" {
+		t.Errorf("Expected the user to be verified without a previous address. Received %v %q", verified.Verified(), previous)
+	}
+	if _, err := s.User.Authenticate(user.Email, "actually"); err != nil {
+		t.Errorf("Expected verified users to authenticate. Received %v", err)
+	}
+	// Tokens are single use
+	if _, _, err := s.User.CompleteVerify(token); err != ErrTokenInvalid {
+		t.Errorf("Expected ErrTokenInvalid for a used token. Received %v", err)
+	}
+	if _, err := s.User.InitiateVerify(user.ID); err != ErrEmailAlreadyVerified {
+		t.Errorf("Expected ErrEmailAlreadyVerified. Received %v", err)
+	}
+}
+
+func TestEmailVerificationChangedEmail(t *testing.T) {
+	s, err := testingServices()
+	if err != nil {
+		t.Fatal(err)
+	}
+	user := User{Email: "angela@dundermifflin.com", Password: "sprinkles"}
+	if err := s.User.Create(&user); err != nil {
+		t.Fatal(err)
+	}
+	// Verification isn't required by default
+	if _, err := s.User.Authenticate(user.Email, "sprinkles"); err != nil {
+		t.Errorf("Expected unverified users to authenticate. Received %v", err)
+	}
+	token, err := s.User.InitiateVerify(user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := s.User.CompleteVerify(token); err != nil {
+		t.Fatal(err)
+	}
+
+	// The new address waits until it is verified
+	if _, err := s.User.UpdateProfile(user.ID, "Angela", "angela.lipton@dundermifflin.com"); err != nil {
+		t.Fatal(err)
+	}
+	first, err := s.User.InitiateVerify(user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.User.Authenticate(user.Email, "sprinkles"); err != nil {
+		t.Errorf("Expected the old address to keep working. Received %v", err)
+	}
+
+	// Asking for another address voids the link for the first one
+	if _, err := s.User.UpdateProfile(user.ID, "Angela", "angela@schrute-farms.com"); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := s.User.CompleteVerify(first); err != ErrTokenInvalid {
+		t.Errorf("Expected ErrTokenInvalid after the email changed. Received %v", err)
+	}
+	token, err = s.User.InitiateVerify(user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	verified, previous, err := s.User.CompleteVerify(token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if verified.Email != "angela@schrute-farms.com" || verified.PendingEmail != "" || !verified.Verified() {
+		t.Errorf("Expected the new address to be swapped in. Received %+v", verified)
+	}
+	if previous != "angela@dundermifflin.com" {
+		t.Errorf("Expected the old address back. Received %q", previous)
+	}
+	if _, _, err := s.User.CompleteVerify(token); err != ErrTokenInvalid {
+		t.Errorf("Expected ErrTokenInvalid for a used token. Received %v", err)
+	}
+}
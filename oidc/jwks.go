@@ -0,0 +1,152 @@
+package oidc
+
+import (
+	"context"
+	"crypto"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// jwksRefetchInterval limits how often an unknown key ID makes us
+// fetch the provider's keys again, so made up tokens can't be used
+// to hammer the provider
+const jwksRefetchInterval = time.Minute
+
+func newKeySet(client *http.Client) *keySet {
+	return &keySet{client: client}
+}
+
+// keySet caches the provider's signing keys by key ID. Providers
+// rotate their keys, so a token signed with a key we haven't seen
+// makes us fetch them again.
+type keySet struct {
+	client *http.Client
+
+	mu      sync.Mutex
+	keys    map[string]*rsa.PublicKey
+	fetched time.Time
+}
+
+// jwk is one RSA key from a JWKS document
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+}
+
+// jwtHeader is the part of a JWT header we look at
+type jwtHeader struct {
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+}
+
+// verify checks the signature of a JWT and returns its payload
+func (ks *keySet) verify(ctx context.Context, jwksURL, token string) ([]byte, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, ErrIDTokenInvalid
+	}
+	var header jwtHeader
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, ErrIDTokenInvalid
+	}
+	// Never let the token pick a weaker algorithm, like none
+	if header.Alg != "RS256" {
+		return nil, ErrIDTokenInvalid
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, ErrIDTokenInvalid
+	}
+	key, err := ks.key(ctx, jwksURL, header.Kid)
+	if err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	if err := rsa.VerifyPKCS1v15(key, crypto.SHA256, sum[:], sig); err != nil {
+		return nil, ErrIDTokenInvalid
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, ErrIDTokenInvalid
+	}
+	return payload, nil
+}
+
+// key returns the key with the provided ID, fetching the keys again
+// if it's unknown. A token without a key ID can only be checked
+// when the provider has just one key.
+func (ks *keySet) key(ctx context.Context, jwksURL, kid string) (*rsa.PublicKey, error) {
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+	if key := ks.lookup(kid); key != nil {
+		return key, nil
+	}
+	if time.Since(ks.fetched) < jwksRefetchInterval {
+		return nil, ErrIDTokenInvalid
+	}
+	if err := ks.fetch(ctx, jwksURL); err != nil {
+		return nil, err
+	}
+	if key := ks.lookup(kid); key != nil {
+		return key, nil
+	}
+	return nil, ErrIDTokenInvalid
+}
+
+func (ks *keySet) lookup(kid string) *rsa.PublicKey {
+	if kid == "" && len(ks.keys) == 1 {
+		for _, key := range ks.keys {
+			return key
+		}
+	}
+	return ks.keys[kid]
+}
+
+// fetch replaces the cached keys with the provider's current ones
+func (ks *keySet) fetch(ctx context.Context, jwksURL string) error {
+	var doc struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := getJSON(ctx, ks.client, jwksURL, &doc); err != nil {
+		return err
+	}
+	keys := make(map[string]*rsa.PublicKey)
+	for _, k := range doc.Keys {
+		if k.Kty != "RSA" || (k.Use != "" && k.Use != "sig") {
+			continue
+		}
+		n, err := base64.RawURLEncoding.DecodeString(k.N)
+		if err != nil {
+			continue
+		}
+		e, err := base64.RawURLEncoding.DecodeString(k.E)
+		if err != nil || len(e) == 0 || len(e) > 4 {
+			continue
+		}
+		keys[k.Kid] = &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: int(new(big.Int).SetBytes(e).Int64()),
+		}
+	}
+	ks.keys = keys
+	ks.fetched = time.Now()
+	return nil
+}
+
+func decodeSegment(seg string, dst interface{}) error {
+	b, err := base64.RawURLEncoding.DecodeString(seg)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, dst)
+}
@@ -0,0 +1,344 @@
+// Package oidc signs users in with an OpenID Connect provider using
+// the authorization code flow with PKCE.
+//
+// A login starts with NewAuthRequest, whose values have to be kept
+// (in a cookie) until the provider redirects back. AuthURL is where
+// to send the user, and Exchange turns the code the provider sends
+// back into the verified claims of its ID token.
+//
+// Only RS256 signed ID tokens are supported, which every provider
+// has to offer.
+package oidc
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"../hash"
+	"../rand"
+)
+
+var (
+	// ErrIDTokenInvalid is returned when an ID token is malformed,
+	// its signature doesn't match or one of its claims is wrong
+	ErrIDTokenInvalid = errors.New("oidc: ID token is invalid")
+
+	// ErrNonceMismatch is returned when an ID token wasn't issued
+	// for the login it came back to
+	ErrNonceMismatch = errors.New("oidc: ID token nonce doesn't match")
+)
+
+const (
+	// verifierBytes is the randomness in state, nonce and the PKCE
+	// verifier. 32 bytes encode to 43 characters, the shortest
+	// verifier RFC 7636 allows.
+	verifierBytes = 32
+
+	// leeway is how far apart our clock and the provider's may be
+	leeway = time.Minute
+
+	// maxResponseBytes limits what is read from the provider
+	maxResponseBytes = 1 << 20
+)
+
+// Config is what the provider needs to know about us. RedirectURL
+// has to be registered with the provider, it is where the provider
+// sends the user back to. Scopes defaults to openid, email and
+// profile.
+type Config struct {
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	RedirectURL  string
+	Scopes       []string
+	HTTPClient   *http.Client
+}
+
+// NewClient returns a Client for the provider at cfg.Issuer. The
+// provider's metadata is fetched on first use and then kept.
+func NewClient(cfg Config) *Client {
+	if len(cfg.Scopes) == 0 {
+		cfg.Scopes = []string{"openid", "email", "profile"}
+	}
+	if cfg.HTTPClient == nil {
+		cfg.HTTPClient = &http.Client{Timeout: 10 * time.Second}
+	}
+	return &Client{
+		cfg:  cfg,
+		keys: newKeySet(cfg.HTTPClient),
+	}
+}
+
+// Client talks to one OpenID Connect provider. It is safe for
+// concurrent use.
+type Client struct {
+	cfg  Config
+	keys *keySet
+
+	mu   sync.Mutex
+	meta *metadata
+}
+
+// metadata is the part of the provider's discovery document we use
+type metadata struct {
+	Issuer   string `json:"issuer"`
+	AuthURL  string `json:"authorization_endpoint"`
+	TokenURL string `json:"token_endpoint"`
+	JWKSURL  string `json:"jwks_uri"`
+}
+
+// discover fetches the provider's metadata, once
+func (c *Client) discover(ctx context.Context) (*metadata, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.meta != nil {
+		return c.meta, nil
+	}
+	u := strings.TrimSuffix(c.cfg.Issuer, "/") + "/.well-known/openid-configuration"
+	var meta metadata
+	if err := getJSON(ctx, c.cfg.HTTPClient, u, &meta); err != nil {
+		return nil, err
+	}
+	if meta.Issuer != c.cfg.Issuer {
+		return nil, fmt.Errorf("oidc: provider says its issuer is %q, not %q", meta.Issuer, c.cfg.Issuer)
+	}
+	if meta.AuthURL == "" || meta.TokenURL == "" || meta.JWKSURL == "" {
+		return nil, fmt.Errorf("oidc: provider metadata is missing an endpoint")
+	}
+	c.meta = &meta
+	return c.meta, nil
+}
+
+// AuthRequest holds the secrets of one login attempt. State ties
+// the callback to the browser that started the login, Nonce ties
+// the ID token to it and Verifier proves to the provider that we
+// are the ones who started it (PKCE).
+type AuthRequest struct {
+	State    string
+	Nonce    string
+	Verifier string
+}
+
+// NewAuthRequest generates the values for a new login attempt
+func NewAuthRequest() (*AuthRequest, error) {
+	var values [3]string
+	for i := range values {
+		b, err := rand.Bytes(verifierBytes)
+		if err != nil {
+			return nil, err
+		}
+		values[i] = base64.RawURLEncoding.EncodeToString(b)
+	}
+	return &AuthRequest{
+		State:    values[0],
+		Nonce:    values[1],
+		Verifier: values[2],
+	}, nil
+}
+
+// challenge is the S256 PKCE code challenge for the verifier
+func (ar *AuthRequest) challenge() string {
+	sum := sha256.Sum256([]byte(ar.Verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// AuthURL returns the provider's login page for ar
+func (c *Client) AuthURL(ctx context.Context, ar *AuthRequest) (string, error) {
+	meta, err := c.discover(ctx)
+	if err != nil {
+		return "", err
+	}
+	params := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {c.cfg.ClientID},
+		"redirect_uri":          {c.cfg.RedirectURL},
+		"scope":                 {strings.Join(c.cfg.Scopes, " ")},
+		"state":                 {ar.State},
+		"nonce":                 {ar.Nonce},
+		"code_challenge":        {ar.challenge()},
+		"code_challenge_method": {"S256"},
+	}
+	sep := "?"
+	if strings.Contains(meta.AuthURL, "?") {
+		sep = "&"
+	}
+	return meta.AuthURL + sep + params.Encode(), nil
+}
+
+// tokenResponse is the token endpoint's answer, or its error
+type tokenResponse struct {
+	IDToken          string `json:"id_token"`
+	Error            string `json:"error"`
+	ErrorDescription string `json:"error_description"`
+}
+
+// Exchange trades the code from the provider's redirect for an ID
+// token and returns its claims once they are verified. The caller
+// has to check the state from the redirect matches ar.State first.
+func (c *Client) Exchange(ctx context.Context, code string, ar *AuthRequest) (*Claims, error) {
+	meta, err := c.discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {c.cfg.RedirectURL},
+		"code_verifier": {ar.Verifier},
+	}
+	req, err := http.NewRequest("POST", meta.TokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	// RFC 6749 wants the client ID and secret form encoded before
+	// they go in the basic auth header
+	req.SetBasicAuth(url.QueryEscape(c.cfg.ClientID), url.QueryEscape(c.cfg.ClientSecret))
+	res, err := c.cfg.HTTPClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	var tr tokenResponse
+	body, err := ioutil.ReadAll(io.LimitReader(res.Body, maxResponseBytes))
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(body, &tr); err != nil {
+		return nil, fmt.Errorf("oidc: token endpoint returned %s", res.Status)
+	}
+	if tr.Error != "" {
+		return nil, fmt.Errorf("oidc: token endpoint returned %s: %s", tr.Error, tr.ErrorDescription)
+	}
+	if res.StatusCode != http.StatusOK || tr.IDToken == "" {
+		return nil, fmt.Errorf("oidc: token endpoint returned %s without an ID token", res.Status)
+	}
+	return c.Verify(ctx, tr.IDToken, ar.Nonce)
+}
+
+// Claims are the claims of an ID token that we use
+type Claims struct {
+	Issuer          string   `json:"iss"`
+	Subject         string   `json:"sub"`
+	Audience        audience `json:"aud"`
+	AuthorizedParty string   `json:"azp"`
+	Expires         int64    `json:"exp"`
+	IssuedAt        int64    `json:"iat"`
+	Nonce           string   `json:"nonce"`
+	Email           string   `json:"email"`
+	EmailVerified   boolish  `json:"email_verified"`
+	Name            string   `json:"name"`
+}
+
+// Verify checks the signature of an ID token against the provider's
+// keys and that it was issued by the provider, for us, for the
+// login with nonce, and hasn't expired
+func (c *Client) Verify(ctx context.Context, rawIDToken, nonce string) (*Claims, error) {
+	meta, err := c.discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+	payload, err := c.keys.verify(ctx, meta.JWKSURL, rawIDToken)
+	if err != nil {
+		return nil, err
+	}
+	var claims Claims
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return nil, ErrIDTokenInvalid
+	}
+
+	now := time.Now()
+	switch {
+	case claims.Issuer != meta.Issuer:
+		return nil, ErrIDTokenInvalid
+	case claims.Subject == "":
+		return nil, ErrIDTokenInvalid
+	case !claims.Audience.contains(c.cfg.ClientID):
+		return nil, ErrIDTokenInvalid
+	case len(claims.Audience) > 1 && claims.AuthorizedParty != c.cfg.ClientID:
+		return nil, ErrIDTokenInvalid
+	case !now.Before(time.Unix(claims.Expires, 0).Add(leeway)):
+		return nil, ErrIDTokenInvalid
+	case time.Unix(claims.IssuedAt, 0).After(now.Add(leeway)):
+		return nil, ErrIDTokenInvalid
+	}
+	if nonce == "" || !hash.Equal(claims.Nonce, nonce) {
+		return nil, ErrNonceMismatch
+	}
+	return &claims, nil
+}
+
+// audience is the aud claim, which can be a string or a list
+type audience []string
+
+func (a *audience) UnmarshalJSON(b []byte) error {
+	var s string
+	if json.Unmarshal(b, &s) == nil {
+		*a = audience{s}
+		return nil
+	}
+	var list []string
+	if err := json.Unmarshal(b, &list); err != nil {
+		return err
+	}
+	*a = list
+	return nil
+}
+
+func (a audience) contains(clientID string) bool {
+	for _, aud := range a {
+		if aud == clientID {
+			return true
+		}
+	}
+	return false
+}
+
+// boolish is a bool claim that some providers send as a string
+type boolish bool
+
+func (b *boolish) UnmarshalJSON(data []byte) error {
+	var s string
+	if json.Unmarshal(data, &s) == nil {
+		*b = s == "true"
+		return nil
+	}
+	var v bool
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	*b = boolish(v)
+	return nil
+}
+
+func getJSON(ctx context.Context, client *http.Client, u string, dst interface{}) error {
+	req, err := http.NewRequest("GET", u, nil)
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Accept", "application/json")
+	res, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("oidc: GET %s returned %s", u, res.Status)
+	}
+	return json.NewDecoder(io.LimitReader(res.Body, maxResponseBytes)).Decode(dst)
+}
@@ -0,0 +1,199 @@
+package oidc
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"./oidctest"
+)
+
+const testRedirectURL = "http://databot.test/login/oidc/callback"
+
+func testingClient(t *testing.T) (*oidctest.Provider, *Client) {
+	p := oidctest.NewProvider("databot", "s3cr3t&more")
+	t.Cleanup(p.Close)
+	p.SetUser(oidctest.User{
+		Subject:       "248289761001",
+		Email:         "jim@dundermifflin.com",
+		EmailVerified: true,
+		Name:          "Jim Halpert",
+	})
+	c := NewClient(Config{
+		Issuer:       p.Issuer,
+		ClientID:     p.ClientID,
+		ClientSecret: p.ClientSecret,
+		RedirectURL:  testRedirectURL,
+	})
+	return p, c
+}
+
+// authorize follows AuthURL to the provider and returns the query
+// of the redirect back to us
+func authorize(t *testing.T, c *Client, ar *AuthRequest) url.Values {
+	authURL, err := c.AuthURL(context.Background(), ar)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := &http.Client{
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	res, err := client.Get(authURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	loc, err := res.Location()
+	if err != nil {
+		t.Fatalf("Expected a redirect. Received %s", res.Status)
+	}
+	if !strings.HasPrefix(loc.String(), testRedirectURL+"?") {
+		t.Fatalf("Expected a redirect to %s. Received %s", testRedirectURL, loc)
+	}
+	return loc.Query()
+}
+
+func TestLogin(t *testing.T) {
+	p, c := testingClient(t)
+	ar, err := NewAuthRequest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	q := authorize(t, c, ar)
+	if q.Get("state") != ar.State {
+		t.Errorf("Expected state %s. Received %s", ar.State, q.Get("state"))
+	}
+
+	claims, err := c.Exchange(context.Background(), q.Get("code"), ar)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if claims.Issuer != p.Issuer || claims.Subject != "248289761001" ||
+		claims.Email != "jim@dundermifflin.com" || !bool(claims.EmailVerified) {
+		t.Errorf("Expected the provider's user. Received %+v", claims)
+	}
+
+	// Codes can only be used once
+	if _, err := c.Exchange(context.Background(), q.Get("code"), ar); err == nil {
+		t.Error("Expected the code to be rejected the second time")
+	}
+}
+
+func TestExchangeVerifier(t *testing.T) {
+	_, c := testingClient(t)
+	ar, err := NewAuthRequest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	q := authorize(t, c, ar)
+
+	// Someone who steals the code doesn't have the verifier
+	other, err := NewAuthRequest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	other.Nonce = ar.Nonce
+	if _, err := c.Exchange(context.Background(), q.Get("code"), other); err == nil {
+		t.Error("Expected the code to be rejected without the verifier")
+	}
+}
+
+func TestVerify(t *testing.T) {
+	p, c := testingClient(t)
+	ctx := context.Background()
+	const nonce = "n-0S6_WzA2Mj"
+	valid := p.Claims(nonce)
+	if _, err := c.Verify(ctx, p.Sign(valid), nonce); err != nil {
+		t.Fatal(err)
+	}
+
+	with := func(key string, value interface{}) map[string]interface{} {
+		claims := make(map[string]interface{})
+		for k, v := range valid {
+			claims[k] = v
+		}
+		if value == nil {
+			delete(claims, key)
+		} else {
+			claims[key] = value
+		}
+		return claims
+	}
+	hour := time.Hour
+	cases := map[string]struct {
+		token string
+		nonce string
+		err   error
+	}{
+		"other nonce":    {p.Sign(valid), "other", ErrNonceMismatch},
+		"no nonce":       {p.Sign(valid), "", ErrNonceMismatch},
+		"other issuer":   {p.Sign(with("iss", "https://evil.test")), nonce, ErrIDTokenInvalid},
+		"no subject":     {p.Sign(with("sub", nil)), nonce, ErrIDTokenInvalid},
+		"other audience": {p.Sign(with("aud", "someone-else")), nonce, ErrIDTokenInvalid},
+		"many audiences": {p.Sign(with("aud", []string{"databot", "someone-else"})), nonce, ErrIDTokenInvalid},
+		"expired":        {p.Sign(with("exp", time.Now().Add(-hour).Unix())), nonce, ErrIDTokenInvalid},
+		"issued later":   {p.Sign(with("iat", time.Now().Add(hour).Unix())), nonce, ErrIDTokenInvalid},
+		"tampered":       {tamper(p.Sign(valid)), nonce, ErrIDTokenInvalid},
+		"alg none":       {algNone(p.Sign(valid)), nonce, ErrIDTokenInvalid},
+		"malformed":      {"not.a-token", nonce, ErrIDTokenInvalid},
+	}
+	for name, tc := range cases {
+		if _, err := c.Verify(ctx, tc.token, tc.nonce); err != tc.err {
+			t.Errorf("%s: Expected %v. Received %v", name, tc.err, err)
+		}
+	}
+
+	// Several audiences are fine when we are the authorized party
+	claims := with("aud", []string{"databot", "someone-else"})
+	claims["azp"] = "databot"
+	if _, err := c.Verify(ctx, p.Sign(claims), nonce); err != nil {
+		t.Errorf("Expected azp to allow several audiences. Received %v", err)
+	}
+}
+
+func TestVerifyKeyRotation(t *testing.T) {
+	p, c := testingClient(t)
+	ctx := context.Background()
+	if _, err := c.Verify(ctx, p.Sign(p.Claims("n")), "n"); err != nil {
+		t.Fatal(err)
+	}
+
+	p.RotateKey()
+	token := p.Sign(p.Claims("n"))
+	// The keys were just fetched, so an unknown key ID can't make us
+	// fetch them again straight away
+	if _, err := c.Verify(ctx, token, "n"); err != ErrIDTokenInvalid {
+		t.Errorf("Expected ErrIDTokenInvalid before the refetch interval. Received %v", err)
+	}
+	c.keys.fetched = time.Now().Add(-jwksRefetchInterval)
+	if _, err := c.Verify(ctx, token, "n"); err != nil {
+		t.Errorf("Expected the new key to be fetched. Received %v", err)
+	}
+}
+
+func TestDiscoverIssuerMismatch(t *testing.T) {
+	p, _ := testingClient(t)
+	c := NewClient(Config{Issuer: p.Issuer + "/", ClientID: p.ClientID})
+	if _, err := c.AuthURL(context.Background(), &AuthRequest{}); err == nil {
+		t.Error("Expected an error when the provider's issuer doesn't match")
+	}
+}
+
+// tamper changes the payload of a token but keeps its signature
+func tamper(token string) string {
+	parts := strings.Split(token, ".")
+	parts[1] = "eyJzdWIiOiJtaWNoYWVsIn0"
+	return strings.Join(parts, ".")
+}
+
+// algNone replaces the header of a token with an unsigned one
+func algNone(token string) string {
+	parts := strings.Split(token, ".")
+	parts[0] = "eyJhbGciOiJub25lIn0"
+	return strings.Join(parts, ".")
+}
@@ -7,6 +7,10 @@ import (
 
 const RememberTokenBytes = 32
 
+// RecoveryCodeBytes is the amount of randomness in a recovery code,
+// it encodes to exactly 16 base32 characters
+const RecoveryCodeBytes = 10
+
 // Bytes will help generate n random bytes
 // or will return an error
 // This uses crypt/rand package
@@ -44,5 +48,17 @@ func String(nBytes int) (string, error){
 // RemeberToken generates remember tokens of 
 // a predetermined length
 func RememberToken() (string, error) {
-	return String(RememberTokenBytes)
-}
\ No newline at end of file
+	return Session.New()
+}
+
+// RecoveryCode generates a code that is easy to type in by hand,
+// like abcd-efgh-ijkl-mnop. It is base32, which leaves out the
+// digits that look like letters, like 0 and 1.
+func RecoveryCode() (string, error) {
+	b, err := Bytes(RecoveryCodeBytes)
+	if err != nil {
+		return "", err
+	}
+	s := lowerBase32.EncodeToString(b)
+	return s[0:4] + "-" + s[4:8] + "-" + s[8:12] + "-" + s[12:16], nil
+}
@@ -0,0 +1,171 @@
+package rand
+
+import (
+	"encoding/base32"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"strings"
+)
+
+// ErrLengthInvalid is returned by Kind.New when Length isn't positive
+var ErrLengthInvalid = errors.New("rand: token length must be at least 1")
+
+// Alphabet is how the random part of a token is written out
+type Alphabet int
+
+const (
+	// Base64URL is URL-safe base64 with padding, what RememberToken
+	// has always returned
+	Base64URL Alphabet = iota
+
+	// Base32 is lower-case base32 without padding. It leaves out the
+	// digits that look like letters and is easier to read out.
+	Base32
+
+	// Numeric is decimal digits, for codes typed in from an email
+	// or text message
+	Numeric
+)
+
+var lowerBase32 = base32.NewEncoding("abcdefghijklmnopqrstuvwxyz234567").WithPadding(base32.NoPadding)
+
+type encoding interface {
+	EncodeToString(src []byte) string
+	DecodeString(s string) ([]byte, error)
+}
+
+func (a Alphabet) encoding() encoding {
+	if a == Base32 {
+		return lowerBase32
+	}
+	return base64.URLEncoding
+}
+
+// checksumBytes is the size of the CRC32 a token with Checksum ends in
+const checksumBytes = 4
+
+// Kind describes one type of token. Length is the number of random
+// bytes, or of digits for Numeric. Prefix is put in front of every
+// token, so a token found somewhere says what it is. Checksum adds
+// a CRC32 of the prefix and random part (a Luhn digit for Numeric),
+// so secret scanners can tell a real token from something that
+// only looks like one without asking us.
+type Kind struct {
+	Name     string
+	Length   int
+	Alphabet Alphabet
+	Prefix   string
+	Checksum bool
+}
+
+// The kinds of tokens DataBot issues
+var (
+	Session = Kind{Name: "session", Length: RememberTokenBytes, Alphabet: Base64URL}
+	Reset   = Kind{Name: "password reset", Length: 32, Alphabet: Base64URL}
+	APIKey  = Kind{Name: "API key", Length: 20, Alphabet: Base32, Prefix: "dbk_", Checksum: true}
+)
+
+// New generates a token of this kind
+func (k Kind) New() (string, error) {
+	if k.Length < 1 {
+		return "", ErrLengthInvalid
+	}
+	if k.Alphabet == Numeric {
+		digits, err := Digits(k.Length)
+		if err != nil {
+			return "", err
+		}
+		if k.Checksum {
+			digits += string(luhnDigit(digits))
+		}
+		return k.Prefix + digits, nil
+	}
+	b, err := Bytes(k.Length)
+	if err != nil {
+		return "", err
+	}
+	if k.Checksum {
+		b = k.appendChecksum(b)
+	}
+	return k.Prefix + k.Alphabet.encoding().EncodeToString(b), nil
+}
+
+// Valid reports whether token looks like one New made: the prefix,
+// alphabet and length are right and so is the checksum if there is
+// one. It doesn't say whether the token was ever issued.
+func (k Kind) Valid(token string) bool {
+	if k.Length < 1 || !strings.HasPrefix(token, k.Prefix) {
+		return false
+	}
+	body := token[len(k.Prefix):]
+	if k.Alphabet == Numeric {
+		n := k.Length
+		if k.Checksum {
+			n++
+		}
+		if len(body) != n || strings.Trim(body, "0123456789") != "" {
+			return false
+		}
+		return !k.Checksum || luhnDigit(body[:k.Length]) == body[k.Length]
+	}
+	enc := k.Alphabet.encoding()
+	b, err := enc.DecodeString(body)
+	// Decoding ignores the unused bits of the last character, so
+	// check it encodes back to the same token
+	if err != nil || enc.EncodeToString(b) != body {
+		return false
+	}
+	n := k.Length
+	if k.Checksum {
+		n += checksumBytes
+	}
+	if len(b) != n {
+		return false
+	}
+	return !k.Checksum || string(k.appendChecksum(b[:k.Length])) == string(b)
+}
+
+func (k Kind) appendChecksum(random []byte) []byte {
+	sum := crc32.ChecksumIEEE(append([]byte(k.Prefix), random...))
+	b := make([]byte, len(random)+checksumBytes)
+	copy(b, random)
+	binary.BigEndian.PutUint32(b[len(random):], sum)
+	return b
+}
+
+// Digits returns n random decimal digits, each equally likely
+func Digits(n int) (string, error) {
+	digits := make([]byte, 0, n)
+	for len(digits) < n {
+		b, err := Bytes(n - len(digits))
+		if err != nil {
+			return "", err
+		}
+		for _, c := range b {
+			// Bytes from 250 up are skipped, they would make 0-5
+			// more likely than 6-9
+			if c < 250 {
+				digits = append(digits, '0'+c%10)
+			}
+		}
+	}
+	return string(digits), nil
+}
+
+// luhnDigit returns the Luhn check digit for a string of digits
+func luhnDigit(digits string) byte {
+	sum := 0
+	for i := len(digits) - 1; i >= 0; i-- {
+		d := int(digits[i] - '0')
+		if (len(digits)-i)%2 == 1 {
+			d *= 2
+			if d > 9 {
+				d -= 9
+			}
+		}
+		sum += d
+	}
+	return byte('0' + (10-sum%10)%10)
+}
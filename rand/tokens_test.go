@@ -0,0 +1,78 @@
+package rand
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestKinds(t *testing.T) {
+	kinds := []Kind{
+		Session,
+		Reset,
+		APIKey,
+		{Name: "code", Length: 6, Alphabet: Numeric},
+		{Name: "checked code", Length: 8, Alphabet: Numeric, Checksum: true},
+		{Name: "base64 with checksum", Length: 16, Alphabet: Base64URL, Prefix: "x_", Checksum: true},
+	}
+	for _, k := range kinds {
+		token, err := k.New()
+		if err != nil {
+			t.Fatalf("%s: %v", k.Name, err)
+		}
+		if !strings.HasPrefix(token, k.Prefix) {
+			t.Errorf("%s: Expected the prefix %q. Received %s", k.Name, k.Prefix, token)
+		}
+		if !k.Valid(token) {
+			t.Errorf("%s: Expected %s to be valid", k.Name, token)
+		}
+		other, _ := k.New()
+		if other == token {
+			t.Errorf("%s: Expected a different token every time", k.Name)
+		}
+	}
+
+	if n, err := NBytes(mustNew(t, Session)); err != nil || n != RememberTokenBytes {
+		t.Errorf("Expected session tokens to stay %d bytes. Received %d, %v", RememberTokenBytes, n, err)
+	}
+	if token := mustNew(t, Kind{Length: 6, Alphabet: Numeric}); len(token) != 6 || strings.Trim(token, "0123456789") != "" {
+		t.Errorf("Expected 6 digits. Received %s", token)
+	}
+	if _, err := (Kind{Name: "empty"}).New(); err != ErrLengthInvalid {
+		t.Errorf("Expected ErrLengthInvalid. Received %v", err)
+	}
+}
+
+func TestKindChecksum(t *testing.T) {
+	key := mustNew(t, APIKey)
+	// Changing any one character breaks the checksum
+	for i := len(APIKey.Prefix); i < len(key); i++ {
+		c := byte('a')
+		if key[i] == 'a' {
+			c = 'b'
+		}
+		changed := key[:i] + string(c) + key[i+1:]
+		if APIKey.Valid(changed) {
+			t.Errorf("Expected %s to be invalid", changed)
+		}
+	}
+	if APIKey.Valid("xyz_" + key[len(APIKey.Prefix):]) {
+		t.Error("Expected another prefix to be invalid")
+	}
+	if APIKey.Valid(key[:len(key)-1]) {
+		t.Error("Expected a short key to be invalid")
+	}
+
+	// 7992739871 is the usual Luhn example, its check digit is 3
+	code := Kind{Length: 10, Alphabet: Numeric, Checksum: true}
+	if !code.Valid("79927398713") || code.Valid("79927398710") {
+		t.Error("Expected the Luhn check digit to be checked")
+	}
+}
+
+func mustNew(t *testing.T, k Kind) string {
+	token, err := k.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
@@ -0,0 +1,126 @@
+// Package throttle slows down repeated failures, like wrong
+// passwords, with an exponential backoff per key.
+package throttle
+
+import (
+	"sync"
+	"time"
+)
+
+// Limiter tracks failures per key, for example an IP address or an
+// email address. The first Free failures are not throttled, after
+// that each failure doubles how long the key has to wait, starting
+// at Base and never more than Max.
+//
+// A key is forgotten once it has waited out its delay and then
+// gone Max without failing again. Forgotten keys are swept out at
+// most once per Base, so a flood of failures doesn't scan every key
+// each time. Limiter is safe for concurrent use.
+type Limiter struct {
+	Free int
+	Base time.Duration
+	Max  time.Duration
+
+	mu        sync.Mutex
+	entries   map[string]*entry
+	lastPrune time.Time
+	now       func() time.Time
+}
+
+type entry struct {
+	failures int
+	until    time.Time
+	last     time.Time
+}
+
+// stale reports whether the entry has waited out its delay and then
+// gone max without another failure
+func (e *entry) stale(now time.Time, max time.Duration) bool {
+	return now.After(e.until) && now.Sub(e.last) > max
+}
+
+// NewLimiter returns a Limiter with the provided settings
+func NewLimiter(free int, base, max time.Duration) *Limiter {
+	return &Limiter{
+		Free:    free,
+		Base:    base,
+		Max:     max,
+		entries: make(map[string]*entry),
+		now:     time.Now,
+	}
+}
+
+// Wait returns how long key has to wait before it can try again,
+// 0 if it can try now
+func (l *Limiter) Wait(key string) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	e, ok := l.entries[key]
+	if !ok {
+		return 0
+	}
+	wait := e.until.Sub(l.now())
+	if wait < 0 {
+		return 0
+	}
+	return wait
+}
+
+// Fail records a failure for key and returns how long it now has
+// to wait
+func (l *Limiter) Fail(key string) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := l.now()
+	if now.Sub(l.lastPrune) >= l.Base {
+		l.prune(now)
+		l.lastPrune = now
+	}
+	e, ok := l.entries[key]
+	// A stale key that wasn't swept out yet starts over
+	if !ok || e.stale(now, l.Max) {
+		e = &entry{}
+		l.entries[key] = e
+	}
+	e.failures++
+	e.last = now
+	delay := Backoff(e.failures-l.Free, l.Base, l.Max)
+	e.until = now.Add(delay)
+	return delay
+}
+
+// Reset forgets every failure for key
+func (l *Limiter) Reset(key string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	delete(l.entries, key)
+}
+
+// prune drops the keys that are done waiting and haven't failed in
+// Max, so the map doesn't grow with every address that ever failed
+func (l *Limiter) prune(now time.Time) {
+	for key, e := range l.entries {
+		if e.stale(now, l.Max) {
+			delete(l.entries, key)
+		}
+	}
+}
+
+// Backoff returns base doubled n-1 times, capped at max. It is 0
+// when n is less than 1.
+func Backoff(n int, base, max time.Duration) time.Duration {
+	if n < 1 {
+		return 0
+	}
+	d := base
+	for i := 1; i < n; i++ {
+		d *= 2
+		if d >= max {
+			return max
+		}
+	}
+	if d > max {
+		return max
+	}
+	return d
+}
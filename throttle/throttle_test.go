@@ -0,0 +1,90 @@
+package throttle
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBackoff(t *testing.T) {
+	cases := []struct {
+		n    int
+		want time.Duration
+	}{
+		{0, 0},
+		{1, time.Second},
+		{2, 2 * time.Second},
+		{4, 8 * time.Second},
+		{10, time.Minute},
+	}
+	for _, c := range cases {
+		if got := Backoff(c.n, time.Second, time.Minute); got != c.want {
+			t.Errorf("Backoff(%d): Expected %s. Received %s", c.n, c.want, got)
+		}
+	}
+}
+
+func TestLimiter(t *testing.T) {
+	now := time.Now()
+	l := NewLimiter(2, time.Second, time.Minute)
+	l.now = func() time.Time { return now }
+
+	for i := 0; i < 2; i++ {
+		if d := l.Fail("1.2.3.4"); d != 0 {
+			t.Fatalf("Expected free failure %d not to wait. Received %s", i+1, d)
+		}
+	}
+	if d := l.Fail("1.2.3.4"); d != time.Second {
+		t.Errorf("Expected 1s. Received %s", d)
+	}
+	if d := l.Fail("1.2.3.4"); d != 2*time.Second {
+		t.Errorf("Expected 2s. Received %s", d)
+	}
+	if d := l.Wait("1.2.3.4"); d != 2*time.Second {
+		t.Errorf("Expected to wait 2s. Received %s", d)
+	}
+	if d := l.Wait("5.6.7.8"); d != 0 {
+		t.Errorf("Expected other keys not to wait. Received %s", d)
+	}
+
+	now = now.Add(3 * time.Second)
+	if d := l.Wait("1.2.3.4"); d != 0 {
+		t.Errorf("Expected the wait to be over. Received %s", d)
+	}
+
+	l.Reset("1.2.3.4")
+	if d := l.Fail("1.2.3.4"); d != 0 {
+		t.Errorf("Expected Reset to forget failures. Received %s", d)
+	}
+
+	// Quiet keys are pruned on the next failure
+	now = now.Add(2 * time.Minute)
+	l.Fail("5.6.7.8")
+	if _, ok := l.entries["1.2.3.4"]; ok {
+		t.Error("Expected 1.2.3.4 to be pruned")
+	}
+}
+
+func TestLimiterPrunesOncePerBase(t *testing.T) {
+	now := time.Now()
+	l := NewLimiter(0, time.Minute, time.Hour)
+	l.now = func() time.Time { return now }
+	l.Fail("1.2.3.4")
+
+	now = now.Add(2 * time.Hour)
+	l.Fail("5.6.7.8")
+	if _, ok := l.entries["1.2.3.4"]; ok {
+		t.Fatal("Expected 1.2.3.4 to be pruned")
+	}
+	l.Fail("1.2.3.4")
+
+	// Not swept again within Base, but a stale key still starts over
+	now = now.Add(2 * time.Hour)
+	l.lastPrune = now
+	l.Fail("9.9.9.9")
+	if _, ok := l.entries["1.2.3.4"]; !ok {
+		t.Error("Expected 1.2.3.4 to be kept until the next sweep")
+	}
+	if d := l.Fail("1.2.3.4"); d != time.Minute {
+		t.Errorf("Expected a stale key to start over at 1m. Received %s", d)
+	}
+}
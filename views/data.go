@@ -0,0 +1,64 @@
+package views
+
+import (
+	"log"
+	"net/http"
+
+	"../models"
+)
+
+const (
+	AlertLvlError   = "danger"
+	AlertLvlWarning = "warning"
+	AlertLvlInfo    = "info"
+	AlertLvlSuccess = "success"
+
+	// AlertMsgGeneric is shown when something goes wrong that the
+	// user can't do anything about
+	AlertMsgGeneric = "Something went wrong. Please try again, and contact us if the problem persists."
+)
+
+// Data is the top level structure every view renders. Render
+// wraps anything else it is given in Data as the Yield.
+type Data struct {
+	Alert *Alert
+	User  *models.User
+	Yield interface{}
+
+	// Status is the HTTP status Render responds with, 200 if unset
+	Status int
+}
+
+// Alert is rendered by the alert template, Level is one of the
+// bootstrap alert classes above
+type Alert struct {
+	Level   string
+	Message string
+}
+
+// AlertError sets an error alert with the provided message
+func (d *Data) AlertError(msg string) {
+	d.Alert = &Alert{
+		Level:   AlertLvlError,
+		Message: msg,
+	}
+}
+
+// AlertGeneric sets an error alert with AlertMsgGeneric
+func (d *Data) AlertGeneric() {
+	d.AlertError(AlertMsgGeneric)
+}
+
+// SetAlert shows err to the user along with its status code if it
+// is a models.PublicError. Any other error is logged and replaced
+// with AlertMsgGeneric and a 500.
+func (d *Data) SetAlert(err error) {
+	if pErr, ok := models.IsPublic(err); ok {
+		d.AlertError(pErr.Public())
+		d.Status = pErr.Status()
+		return
+	}
+	log.Println(err)
+	d.AlertGeneric()
+	d.Status = http.StatusInternalServerError
+}
@@ -1,9 +1,15 @@
 package views
 
 import (
+	"bytes"
+	"errors"
 	"html/template"
+	"io"
 	"path/filepath"
 	"net/http"
+
+	"github.com/gorilla/csrf"
+	"../context"
 )
 
 var (
@@ -19,7 +25,13 @@ func NewView(layout string, files ...string) *View {
 	
 	files = append(files, layoutFiles()...)
 
-	t, err := template.ParseFiles(files...)
+	// csrfField is replaced with the real token field in Render,
+	// this placeholder only exists so the templates parse
+	t, err := template.New("").Funcs(template.FuncMap{
+		"csrfField": func() (template.HTML, error) {
+			return "", errors.New("csrfField is not implemented")
+		},
+	}).ParseFiles(files...)
 	if err != nil {
 		panic(err)
 	}
@@ -36,15 +48,53 @@ type View struct {
 }
 
 func (v *View) ServeHTTP(w http.ResponseWriter, r *http.Request){
-	if err := v.Render(w, nil); err != nil{
+	if err := v.Render(w, r, nil); err != nil{
 		panic(err)
 	}
 }
 
-// Render is used to to render the view with predefined layout
-func (v *View) Render(w http.ResponseWriter, data interface{}) error {
+// Render is used to to render the view with predefined layout.
+// Anything that isn't already a Data is wrapped in one as the
+// Yield, and the signed in user is added for the navbar.
+// Every form can include {{csrfField}} to get the hidden CSRF
+// token input for the current request.
+func (v *View) Render(w http.ResponseWriter, r *http.Request, data interface{}) error {
 	w.Header().Set("Content-Type", "text/html")
-	return v.Template.ExecuteTemplate(w, v.Layout, data)
+	var vd Data
+	switch d := data.(type) {
+	case Data:
+		vd = d
+	case *Data:
+		vd = *d
+	default:
+		vd = Data{
+			Yield: data,
+		}
+	}
+	vd.User = context.User(r.Context())
+
+	// Clone so concurrent requests don't share each other's csrfField
+	tpl, err := v.Template.Clone()
+	if err != nil {
+		return err
+	}
+	tpl.Funcs(template.FuncMap{
+		"csrfField": func() template.HTML {
+			return csrf.TemplateField(r)
+		},
+	})
+
+	// Render into a buffer first so a template error doesn't
+	// leave a half written page
+	var buf bytes.Buffer
+	if err := tpl.ExecuteTemplate(&buf, v.Layout, vd); err != nil {
+		return err
+	}
+	if vd.Status != 0 {
+		w.WriteHeader(vd.Status)
+	}
+	_, err = io.Copy(w, &buf)
+	return err
 }
 
 // layoutFiles returns a slice of strings with